@@ -1,32 +1,254 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// Заголовки кэширования файлов
+const (
+	// Файлы с хэшем содержимого в имени никогда не меняются
+	CacheControlImmutable = "public, max-age=31536000, immutable"
+	// Изображения рецептов могут стать закрытыми вместе с рецептом,
+	// поэтому общие кэши хранят их недолго и потом перепроверяют
+	CacheControlRecipeFile = "public, max-age=60, must-revalidate"
+	// Остальные файлы браузер должен каждый раз перепроверять
+	CacheControlRevalidate = "public, no-cache"
+)
+
+// Имя файла, хранящегося по хэшу содержимого: <sha256>[_<вариант>].<расширение>
+var contentAddressedName = regexp.MustCompile(`^[0-9a-f]{64}(_[a-z0-9_]+)?\.[a-z0-9]+$`)
+
+// Допустимое название варианта изображения
+var variantName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Форматы, которые можно отдать вместо исходного, в порядке предпочтения
+var negotiableFormats = []string{"avif", "webp"}
+
+// Форматы, для которых подбирается более компактная версия.
+// GIF не подменяется, чтобы не потерять анимацию
+var negotiableSourceFormats = map[string]bool{"jpg": true, "jpeg": true, "png": true}
+
+// Функция для проверки, что имя файла - хэш его содержимого
+func IsContentAddressed(filename string) bool {
+	return contentAddressedName.MatchString(filename)
+}
+
+// Функция для проверки, что клиент принимает MIME-тип
+//
+// Разбирает заголовок Accept, типы с q=0 считаются непринимаемыми.
+// Маски вида image/* не учитываются, потому что их присылают
+// и клиенты, которые не умеют показывать новые форматы
+func AcceptsMediaType(accept string, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), mediaType) {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.TrimSpace(key) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Функция для подбора версии изображения в формате, который понимает клиент
+//
+// Возвращает имя файла, который нужно отдать, и признак того,
+// что ответ зависит от заголовка Accept
+func (server *Server) NegotiateImageFormat(filename string, accept string) (string, bool) {
+	ext := strings.TrimPrefix(path.Ext(filename), ".")
+	if !negotiableSourceFormats[ext] {
+		return filename, false
+	}
+
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	for _, format := range negotiableFormats {
+		if !AcceptsMediaType(accept, "image/"+format) {
+			continue
+		}
+
+		candidate := fmt.Sprintf("%s.%s", base, format)
+		_, err := server.Storage.Stat(candidate)
+		if err == nil {
+			return candidate, true
+		}
+	}
+
+	return filename, true
+}
+
+// Функция для получения ETag файла
+//
+// Для файлов с хэшем в имени это имя, для остальных - размер и время изменения
+func FileETag(info *FileInfo) string {
+	if IsContentAddressed(info.Name) {
+		return fmt.Sprintf("\"%s\"", info.Name)
+	}
+	return fmt.Sprintf("\"%x-%x\"", info.Size, info.ModTime.UnixNano())
+}
+
 // Функция для скачивания файла
+//
+// Файл читается из хранилища и отдаётся через сервер.
+// Параметр variant выбирает уменьшенную копию изображения (например, thumbnail).
+// Если клиент принимает AVIF или WebP и такая версия есть, то отдаётся она.
+// Поддерживаются условные запросы (If-None-Match, If-Modified-Since) и Range.
+// Изображения скрытых рецептов отдаются только по подписанной ссылке
 func (server *Server) DownloadFile(c echo.Context) error {
 	// Получаем имя файла
 	filename := c.Param("filename")
-	if filename == "" {
+	if filename == "" || path.Base(filename) != filename {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{
 			Message: "Не указано имя файла",
 		})
 	}
 
-	// Получаем путь к файлу
-	filePath := path.Join(server.UploadsPath, filename)
+	// Проверяем доступ к изображениям скрытых рецептов
+	private, recipeFile, err := server.CheckFileAccess(filename)
+	if err != nil {
+		log.Printf("Check file %s access: %s", filename, err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{
+			Message: "Не удалось получить файл",
+		})
+	}
+
+	var expiresAt time.Time
+	if private {
+		var valid bool
+		expiresAt, valid = server.VerifyFileSignature(filename, c.QueryParam(ExpiresParam), c.QueryParam(SignatureParam))
+		if !valid {
+			return c.JSON(http.StatusForbidden, &DefaultResponse{
+				Message: "Нет доступа к файлу",
+			})
+		}
+	}
+
+	// Получаем имя нужной копии изображения
+	variant := c.QueryParam("variant")
+	if variant != "" {
+		if !variantName.MatchString(variant) {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{
+				Message: "Неверное название копии изображения",
+			})
+		}
+		ext := path.Ext(filename)
+		filename = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(filename, ext), variant, ext)
+	}
+
+	// Подбираем формат по заголовку Accept
+	filename, negotiated := server.NegotiateImageFormat(filename, c.Request().Header.Get(echo.HeaderAccept))
 
-	// Проверяем, существует ли файл
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	// Открываем файл в хранилище
+	file, info, err := server.Storage.Get(filename)
+	if err == ErrFileNotFound {
 		return c.JSON(http.StatusNotFound, &DefaultResponse{
 			Message: "Файл не найден",
 		})
 	}
+	if err != nil {
+		log.Printf("Get file %s: %s", filename, err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{
+			Message: "Не удалось получить файл",
+		})
+	}
+	defer file.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, info.ContentType)
+	header.Set("ETag", FileETag(info))
+	if negotiated {
+		header.Add(echo.HeaderVary, echo.HeaderAccept)
+	}
+	if private {
+		// Закрытый файл нельзя хранить в общих кэшах дольше, чем действует ссылка
+		header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(expiresAt).Seconds())))
+	} else if recipeFile {
+		header.Set("Cache-Control", CacheControlRecipeFile)
+	} else if IsContentAddressed(info.Name) {
+		header.Set("Cache-Control", CacheControlImmutable)
+	} else {
+		header.Set("Cache-Control", CacheControlRevalidate)
+	}
+
+	http.ServeContent(c.Response(), c.Request(), info.Name, info.ModTime, file)
+	return nil
+}
+
+// Функция для получения ссылки на скачивание файла
+//
+// Если хранилище поддерживает подписанные ссылки, то возвращается
+// ссылка для скачивания напрямую из хранилища, иначе - ссылка на сервер.
+// Для изображений скрытых рецептов нужна действующая подписанная ссылка
+func (server *Server) GetFileURLHandle(c echo.Context) error {
+	filename := c.Param("filename")
+	if filename == "" || path.Base(filename) != filename {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{
+			Message: "Не указано имя файла",
+		})
+	}
+
+	private, err := server.IsPrivateFile(filename)
+	if err != nil {
+		log.Printf("Check file %s access: %s", filename, err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{
+			Message: "Не удалось получить файл",
+		})
+	}
+	if private {
+		_, valid := server.VerifyFileSignature(filename, c.QueryParam(ExpiresParam), c.QueryParam(SignatureParam))
+		if !valid {
+			return c.JSON(http.StatusForbidden, &DefaultResponse{
+				Message: "Нет доступа к файлу",
+			})
+		}
+	}
+
+	_, err = server.Storage.Stat(filename)
+	if err == ErrFileNotFound {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{
+			Message: "Файл не найден",
+		})
+	}
+	if err != nil {
+		log.Printf("Stat file %s: %s", filename, err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{
+			Message: "Не удалось получить файл",
+		})
+	}
+
+	expiry := server.GetPresignExpiry()
+	url, err := server.Storage.PresignedURL(filename, expiry)
+	if err == ErrPresignNotSupported {
+		if private {
+			expiresAt := time.Now().Add(expiry)
+			return c.JSON(http.StatusOK, &FileURLResponse{Url: server.SignedFileURL(filename), ExpiresAt: &expiresAt})
+		}
+		return c.JSON(http.StatusOK, &FileURLResponse{Url: "/assets/" + filename})
+	}
+	if err != nil {
+		log.Printf("Presign file %s: %s", filename, err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{
+			Message: "Не удалось получить ссылку на файл",
+		})
+	}
 
-	return c.File(filePath)
+	expiresAt := time.Now().Add(expiry)
+	return c.JSON(http.StatusOK, &FileURLResponse{Url: url, ExpiresAt: &expiresAt})
 }
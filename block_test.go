@@ -1,12 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Recipe-book-PetrSU-2022/backend/models"
 	"github.com/labstack/echo/v4"
@@ -707,6 +719,7 @@ func TestUpdateRecipe(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -792,6 +805,70 @@ func TestGetNotExistsRecipe(t *testing.T) {
 	}
 }
 
+func changeRecipeStatusForTest(t *testing.T, handler echo.HandlerFunc, id uint) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/my-recipe/status/%d", id), nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/status/:id")
+	c.SetParamNames("id")
+	c.SetParamValues(fmt.Sprint(id))
+
+	assert.NoError(t, TestJwtMiddleware(handler)(c))
+	return rec
+}
+
+func TestPublishIncompleteRecipe(t *testing.T) {
+	rec := changeRecipeStatusForTest(t, TestServer.PublishRecipeHandle, 1)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	respJson := RecipeValidationResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &respJson))
+	assert.Equal(t, []string{
+		"Количество порций должно быть больше нуля",
+		"В рецепте нет ни одного этапа",
+		"В рецепте нет ни одного ингредиента",
+	}, respJson.Errors)
+
+	recipe, _ := TestServer.GetRecipeById(1)
+	assert.Equal(t, RecipeStatusDraft, recipe.StrRecipeStatus)
+
+	// Дополняем рецепт через API, после чего его можно опубликовать
+	rec = editRequestForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/1",
+		map[string]string{"id": "1"}, map[string]interface{}{"name": "b", "servings": 1})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.NewIngredient, UserJWT, "/ingredient/create", nil,
+		map[string]interface{}{"name": "Соль", "calories": 0, "proteins": 0, "fats": 0, "carbohydrates": 0})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ingredient := IngredientResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ingredient))
+	ingredientBody := map[string]interface{}{"ingredient_id": ingredient.Id, "grams": 5}
+	rec = editRequestForTest(t, TestServer.AddIngredientHandle, UserJWT, "/my-recipe/1/ingredient/add",
+		map[string]string{"recipe_id": "1"}, ingredientBody)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = editRequestForTest(t, TestServer.CreateStageHandle, UserJWT, "/my-recipe/1/stage/add",
+		map[string]string{"recipe_id": "1"}, map[string]interface{}{"description": "Посолить"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	stage := StageResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stage))
+
+	rec = changeRecipeStatusForTest(t, TestServer.PublishRecipeHandle, 1)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	recipe, _ = TestServer.GetRecipeById(1)
+	assert.Equal(t, RecipeStatusPublished, recipe.StrRecipeStatus)
+
+	// Этапы и ингредиенты рецепта проверяются в тестах ниже,
+	// поэтому убираем добавленные, а рецепт остаётся опубликованным
+	rec = editRequestForTest(t, TestServer.DeleteStageHandle, UserJWT, fmt.Sprintf("/my-recipe/stage/%d/delete", stage.Id),
+		map[string]string{"stage_id": fmt.Sprint(stage.Id)}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = editRequestForTest(t, TestServer.RemoveIngredientHandle, UserJWT, "/my-recipe/1/ingredient/remove",
+		map[string]string{"recipe_id": "1"}, ingredientBody)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
 func TestChangeVisibilitySetVisibleRecipe(t *testing.T) {
 
 	reqMap := map[string]interface{}{
@@ -804,6 +881,7 @@ func TestChangeVisibilitySetVisibleRecipe(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -835,6 +913,7 @@ func TestChangeVisibilitySetInvisibleRecipe(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -1299,6 +1378,7 @@ func TestChangeVisibilitySetInvisibleRecipeByAnother(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT2))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -1648,6 +1728,7 @@ func TestCreateStage(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -1679,6 +1760,7 @@ func TestUpdateStage(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -1708,6 +1790,7 @@ func TestDeleteStage(t *testing.T) {
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
 
 	rec := httptest.NewRecorder()
 
@@ -1727,15 +1810,16 @@ func TestDeleteStage(t *testing.T) {
 	}
 }
 
-func TestDownloadFile(t *testing.T) {
+func TestCreateFilterByNotAdmin(t *testing.T) {
 
 	reqMap := map[string]interface{}{
-		"description": "a",
+		"name":     "Веганское",
+		"category": "diet",
 	}
 	reqJson, _ := json.Marshal(reqMap)
 
 	req := httptest.NewRequest(
-		http.MethodPost, "/assets/a.hehe", strings.NewReader(string(reqJson)),
+		http.MethodPost, "/filter/create", strings.NewReader(string(reqJson)),
 	)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
@@ -1743,51 +1827,3741 @@ func TestDownloadFile(t *testing.T) {
 	rec := httptest.NewRecorder()
 
 	c := TestE.NewContext(req, rec)
-	c.SetPath("/assets/a.hehe")
-	c.SetParamNames("filename")
-	c.SetParamValues("a.hehe")
 
-	if assert.NoError(t, TestJwtMiddleware(TestServer.DownloadFile)(c)) {
-		assert.Equal(t, http.StatusNotFound, rec.Code)
+	if assert.NoError(t, TestJwtMiddleware(TestServer.CreateFilterHandle)(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
 
 		respJson := DefaultResponse{}
 		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
 		assert.Nil(t, err)
 
-		assert.Equal(t, "Файл не найден", respJson.Message)
+		assert.Equal(t, "Недостаточно прав", respJson.Message)
 	}
 }
 
-func TestDeleteRecipe(t *testing.T) {
+func TestCreateFilterWithWrongCategory(t *testing.T) {
+	TestServer.DB.Model(&models.User{}).Where("id = ?", 1).Update("int_user_rights", UserRightsAdmin)
+
+	reqMap := map[string]interface{}{
+		"name":     "Веганское",
+		"category": "color",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/filter/create", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.CreateFilterHandle)(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		respJson := DefaultResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Неизвестная категория тегов", respJson.Message)
+	}
+}
+
+func TestCreateFilter(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"name":     "Веганское",
+		"category": "diet",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/filter/create", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.CreateFilterHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := FilterResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Тег создан", respJson.Message)
+		assert.Equal(t, uint(1), respJson.Id)
+	}
+}
+
+func TestUpdateRecipeWithNotExistsTag(t *testing.T) {
 
 	reqMap := map[string]interface{}{
 		"name": "b",
+		"tags": []uint{1, 100},
 	}
 	reqJson, _ := json.Marshal(reqMap)
 
 	req := httptest.NewRequest(
-		http.MethodGet, "/my-recipe/delete/1", strings.NewReader(string(reqJson)),
+		http.MethodPost, "/my-recipe/change/1", strings.NewReader(string(reqJson)),
 	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
 
 	rec := httptest.NewRecorder()
 
 	c := TestE.NewContext(req, rec)
-	c.SetPath("/my-recipe/delete/1")
+	c.SetPath("/my-recipe/change/1")
 	c.SetParamNames("id")
 	c.SetParamValues("1")
 
-	if assert.NoError(t, TestJwtMiddleware(TestServer.DeleteRecipeHandle)(c)) {
+	if assert.NoError(t, TestJwtMiddleware(TestServer.UpdateRecipeHandle)(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		respJson := DefaultResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Тег не найден", respJson.Message)
+	}
+}
+
+func TestUpdateRecipeTags(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"name": "b",
+		"tags": []uint{1},
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/change/1", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/change/1")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.UpdateRecipeHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		recipe, err := TestServer.GetRecipeById(1)
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(recipe.RecipeFilters)) {
+			assert.Equal(t, "Веганское", recipe.RecipeFilters[0].StrFilterName)
+		}
+	}
+}
+
+func TestUpdateRecipeDuplicateTags(t *testing.T) {
+	rec := recipeRequestWithHeadersForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/1",
+		map[string]string{"id": "1"}, map[string]interface{}{"name": "b", "tags": []uint{1, 1}}, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	recipe, err := TestServer.GetRecipeById(1)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(recipe.RecipeFilters)) {
+		assert.Equal(t, uint(1), recipe.RecipeFilters[0].ID)
+	}
+}
+
+func TestGetRecipesByTags(t *testing.T) {
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/all?tags=1", nil,
+	)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestServer.GetRecipesHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := []models.Recipe{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		if assert.Equal(t, 1, len(respJson)) {
+			assert.Equal(t, uint(1), respJson[0].ID)
+		}
+	}
+}
+
+func TestGetRecipesByRepeatedTags(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/recipe/all?tags=1,1", nil)
+	rec := httptest.NewRecorder()
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestServer.GetRecipesHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := []models.Recipe{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &respJson))
+		if assert.Equal(t, 1, len(respJson)) {
+			assert.Equal(t, uint(1), respJson[0].ID)
+		}
+	}
+
+	// Отбор по тегам работает и в запросах с другими таблицами
+	var count int64
+	err := FilterRecipesByTags(TestServer.DB.Model(&models.Recipe{}).Joins("LEFT JOIN stages ON stages.int_recipe_id = recipes.id"), []uint{1}).
+		Distinct("recipes.id").Count(&count).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestFindRecipeByTags(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"text": "b",
+		"tags": []uint{1, 2},
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/find", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestServer.FindRecipesHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := []models.Recipe{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		// Тега с id 2 нет, поэтому ни один рецепт не подходит
+		assert.Equal(t, 0, len(respJson))
+	}
+}
+
+func TestGetFilterCategories(t *testing.T) {
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/filter/categories", nil,
+	)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestServer.GetFilterCategoriesHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := []FilterCategoryResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		if assert.Equal(t, len(models.FilterCategories), len(respJson)) {
+			assert.Equal(t, models.FilterCategoryDiet, respJson[0].Category)
+			assert.Equal(t, 1, len(respJson[0].Filters))
+		}
+	}
+}
+
+func TestAddIngredient(t *testing.T) {
+	ingredient := models.Ingredient{
+		StrIngredientName: "Гречка",
+		IntCalories:       313,
+		IntProteins:       13,
+		IntFats:           3,
+		IntCarbohydrates:  62,
+	}
+	TestServer.DB.Create(&ingredient)
+
+	reqMap := map[string]interface{}{
+		"ingredient_id": ingredient.ID,
+		"grams":         200,
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/1/ingredient/add", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/1/ingredient/add")
+	c.SetParamNames("recipe_id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.AddIngredientHandle)(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
 
 		respJson := DefaultResponse{}
 		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
 		assert.Nil(t, err)
-		assert.Equal(t, "Рецепт удален", respJson.Message)
+		assert.Equal(t, "Ингредиент добавлен", respJson.Message)
 
 		var recipe models.Recipe
 		TestServer.DB.First(&recipe, "id = ?", 1)
-		assert.NotEqual(t, "", recipe.DeletedAt)
+		assert.Equal(t, 626.0, recipe.TotalNutrition.FloatCalories)
+		assert.Equal(t, 124.0, recipe.TotalNutrition.FloatCarbohydrates)
+		assert.Equal(t, 626.0, recipe.ServingNutrition.FloatCalories)
+	}
+}
+
+func TestUpdateRecipeServingsNutrition(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"name":     "b",
+		"servings": 4,
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/change/1", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/change/1")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.UpdateRecipeHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var recipe models.Recipe
+		TestServer.DB.First(&recipe, "id = ?", 1)
+		assert.Equal(t, 626.0, recipe.TotalNutrition.FloatCalories)
+		assert.Equal(t, 156.5, recipe.ServingNutrition.FloatCalories)
+		assert.Equal(t, 6.5, recipe.ServingNutrition.FloatProteins)
+	}
+}
+
+func TestFindRecipeByNutrition(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"nutrition": map[string]interface{}{
+			"calories": map[string]interface{}{"min": 100, "max": 200},
+		},
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/find", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestServer.FindRecipesHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := []models.Recipe{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		if assert.Equal(t, 1, len(respJson)) {
+			assert.Equal(t, 156.5, respJson[0].ServingNutrition.FloatCalories)
+		}
+	}
+}
+
+func TestFindRecipeByNutritionOutOfRange(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"nutrition": map[string]interface{}{
+			"proteins": map[string]interface{}{"min": 20},
+		},
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/find", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestServer.FindRecipesHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := []models.Recipe{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 0, len(respJson))
+	}
+}
+
+func TestGetRecipeWithServings(t *testing.T) {
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/1?servings=8", nil,
+	)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/recipe/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestServer.GetRecipeHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := ScaledRecipeResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 8, respJson.IntServings)
+		assert.Equal(t, 1252.0, respJson.TotalNutrition.FloatCalories)
+		assert.Equal(t, 156.5, respJson.ServingNutrition.FloatCalories)
+		if assert.NotNil(t, respJson.Scale) && assert.Equal(t, 1, len(respJson.Scale.Ingredients)) {
+			assert.Equal(t, 4, respJson.Scale.OriginalServings)
+			assert.Equal(t, 400.0, respJson.Scale.Ingredients[0].Grams)
+			assert.Equal(t, UnitGram, respJson.Scale.Ingredients[0].Unit)
+			assert.Equal(t, 400, respJson.RecipeIngredients[0].IntGrams)
+		}
+	}
+}
+
+func TestGetRecipeWithServingsInKitchenUnits(t *testing.T) {
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/1?servings=1&kitchen=true", nil,
+	)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/recipe/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestServer.GetRecipeHandle(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := ScaledRecipeResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		if assert.NotNil(t, respJson.Scale) && assert.Equal(t, 1, len(respJson.Scale.Ingredients)) {
+			assert.Equal(t, 50.0, respJson.Scale.Ingredients[0].Grams)
+			assert.Equal(t, 3.5, respJson.Scale.Ingredients[0].Quantity)
+			assert.Equal(t, UnitTablespoon, respJson.Scale.Ingredients[0].Unit)
+		}
+	}
+}
+
+func TestGetRecipeWithWrongServings(t *testing.T) {
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/recipe/1?servings=0", nil,
+	)
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/recipe/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestServer.GetRecipeHandle(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		respJson := DefaultResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Не удалось изменить количество порций: неверное количество порций", respJson.Message)
+	}
+}
+
+func TestAddIngredientInPiecesWithoutWeight(t *testing.T) {
+	ingredient := models.Ingredient{
+		StrIngredientName: "Лавровый лист",
+	}
+	TestServer.DB.Create(&ingredient)
+
+	reqMap := map[string]interface{}{
+		"ingredient_id": ingredient.ID,
+		"quantity":      2,
+		"unit":          "pcs",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/1/ingredient/add", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/1/ingredient/add")
+	c.SetParamNames("recipe_id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.AddIngredientHandle)(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		respJson := DefaultResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+		assert.Equal(t, "Неверное количество ингредиента: для ингредиента не задан вес одной штуки", respJson.Message)
+	}
+}
+
+func TestAddIngredientInPieces(t *testing.T) {
+	ingredient := models.Ingredient{
+		StrIngredientName: "Яйцо",
+		IntCalories:       157,
+		FloatPieceGrams:   50,
+	}
+	TestServer.DB.Create(&ingredient)
+
+	reqMap := map[string]interface{}{
+		"ingredient_id": ingredient.ID,
+		"quantity":      2,
+		"unit":          "pcs",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/1/ingredient/add", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/1/ingredient/add")
+	c.SetParamNames("recipe_id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.AddIngredientHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var recipeIngredient models.RecipeIngredient
+		err := TestServer.DB.First(&recipeIngredient, "int_recipe_id = ? AND int_ingredient_id = ?", 1, ingredient.ID).Error
+		assert.Nil(t, err)
+		assert.Equal(t, 100, recipeIngredient.IntGrams)
+		assert.Equal(t, 2.0, recipeIngredient.FloatQuantity)
+		assert.Equal(t, UnitPiece, recipeIngredient.StrUnit)
+
+		var recipe models.Recipe
+		TestServer.DB.First(&recipe, "id = ?", 1)
+		assert.Equal(t, 783.0, recipe.TotalNutrition.FloatCalories)
+	}
+}
+
+func TestUpdateIngredientPieceWeight(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"colories":    157,
+		"piece_grams": 60,
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	var ingredient models.Ingredient
+	TestServer.DB.First(&ingredient, "str_ingredient_name = ?", "Яйцо")
+	id := fmt.Sprint(ingredient.ID)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/ingredient/"+id+"/update", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/ingredient/:id/update")
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.UpdateIngredientHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var recipeIngredient models.RecipeIngredient
+		TestServer.DB.First(&recipeIngredient, "int_recipe_id = ? AND int_ingredient_id = ?", 1, ingredient.ID)
+		assert.Equal(t, 120, recipeIngredient.IntGrams)
+
+		var recipe models.Recipe
+		TestServer.DB.First(&recipe, "id = ?", 1)
+		assert.Equal(t, 814.4, recipe.TotalNutrition.FloatCalories)
+	}
+}
+
+func TestUpdateIngredientKeepsOmittedFields(t *testing.T) {
+	ingredient := models.Ingredient{StrIngredientName: "Сливки", IntCalories: 206, IntProteins: 3, IntFats: 20, IntCarbohydrates: 4, FloatDensity: 1, FloatPieceGrams: 200}
+	assert.NoError(t, TestServer.DB.Create(&ingredient).Error)
+	defer TestServer.DB.Unscoped().Delete(&ingredient)
+	id := fmt.Sprint(ingredient.ID)
+
+	// Администратор исправляет только плотность
+	rec := recipeRequestForTest(t, TestServer.UpdateIngredientHandle, UserJWT, "/ingredient/"+id+"/update",
+		map[string]string{"id": id}, map[string]interface{}{"density": 1.01})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var updated models.Ingredient
+	TestServer.DB.First(&updated, ingredient.ID)
+	assert.Equal(t, 1.01, updated.FloatDensity)
+	assert.Equal(t, "Сливки", updated.StrIngredientName)
+	assert.Equal(t, 206, updated.IntCalories)
+	assert.Equal(t, 3, updated.IntProteins)
+	assert.Equal(t, 20, updated.IntFats)
+	assert.Equal(t, 4, updated.IntCarbohydrates)
+	assert.Equal(t, 200.0, updated.FloatPieceGrams)
+}
+
+func TestChangeUserInfoUnitSystem(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"unit_system": "imperial",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/profile/update", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.ChangeProfileHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var user models.User
+		TestServer.DB.First(&user, "id = ?", 1)
+		assert.Equal(t, UnitSystemImperial, user.StrUnitSystem)
+	}
+}
+
+func TestGetPersonalRecipeInImperialUnits(t *testing.T) {
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/my-recipe/1", nil,
+	)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.GetMyRecipeHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := models.Recipe{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		units := map[string]float64{}
+		for _, recipeIngredient := range respJson.RecipeIngredients {
+			units[recipeIngredient.StrUnit] = recipeIngredient.FloatQuantity
+		}
+		assert.Equal(t, 7.05, units[UnitOunce])
+		assert.Equal(t, 2.0, units[UnitPiece])
+	}
+
+	TestServer.DB.Model(&models.User{}).Where("id = ?", 1).Update("str_unit_system", UnitSystemMetric)
+}
+
+func createStageForTest(t *testing.T, reqMap map[string]interface{}) *httptest.ResponseRecorder {
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/1/stage/add", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/:recipe_id/stage/add")
+	c.SetParamNames("recipe_id")
+	c.SetParamValues("1")
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.CreateStageHandle)(c))
+	return rec
+}
+
+func recipeStagesDescForTest(t *testing.T) []string {
+	recipe, err := TestServer.GetRecipeById(1)
+	assert.Nil(t, err)
+
+	descs := []string{}
+	for i, stage := range recipe.RecipeStages {
+		assert.Equal(t, i, stage.IntStageOrder)
+		descs = append(descs, stage.StrStageDesc)
+	}
+	return descs
+}
+
+func TestCreateStagesInOrder(t *testing.T) {
+	rec := createStageForTest(t, map[string]interface{}{"description": "первый"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = createStageForTest(t, map[string]interface{}{"description": "третий"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = createStageForTest(t, map[string]interface{}{"description": "второй", "position": 1})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, []string{"первый", "второй", "третий"}, recipeStagesDescForTest(t))
+}
+
+func TestCreateStageAtWrongPosition(t *testing.T) {
+	rec := createStageForTest(t, map[string]interface{}{"description": "лишний", "position": 10})
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	respJson := DefaultResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "Неверная позиция этапа", respJson.Message)
+}
+
+func reorderStagesForTest(t *testing.T, stages []uint) *httptest.ResponseRecorder {
+	reqMap := map[string]interface{}{
+		"stages": stages,
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/1/stage/reorder", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/:recipe_id/stage/reorder")
+	c.SetParamNames("recipe_id")
+	c.SetParamValues("1")
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.ReorderStagesHandle)(c))
+	return rec
+}
+
+func TestReorderStagesWithIncompleteList(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	rec := reorderStagesForTest(t, []uint{recipe.RecipeStages[0].ID, recipe.RecipeStages[0].ID, recipe.RecipeStages[1].ID})
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	respJson := DefaultResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "Список должен содержать все этапы рецепта по одному разу", respJson.Message)
+	assert.Equal(t, []string{"первый", "второй", "третий"}, recipeStagesDescForTest(t))
+}
+
+func TestReorderStages(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stages := recipe.RecipeStages
+
+	rec := reorderStagesForTest(t, []uint{stages[2].ID, stages[0].ID, stages[1].ID})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"третий", "первый", "второй"}, recipeStagesDescForTest(t))
+}
+
+func TestDeleteStageKeepsOrder(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stageID := fmt.Sprint(recipe.RecipeStages[1].ID)
+
+	req := httptest.NewRequest(
+		http.MethodDelete, "/my-recipe/stage/"+stageID+"/delete", nil,
+	)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/stage/:stage_id/delete")
+	c.SetParamNames("stage_id")
+	c.SetParamValues(stageID)
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.DeleteStageHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{"третий", "второй"}, recipeStagesDescForTest(t))
+	}
+}
+
+func pngForTest() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func multipartForTest(field string, count int, values map[string]string) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i := 0; i < count; i++ {
+		part, _ := writer.CreateFormFile(field, fmt.Sprintf("photo%d.png", i))
+		part.Write(pngForTest())
+	}
+	for key, value := range values {
+		writer.WriteField(key, value)
+	}
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func uploadStagePhotosForTest(t *testing.T, stageID uint, count int) *httptest.ResponseRecorder {
+	body, contentType := multipartForTest("file", count, map[string]string{"caption": "подпись"})
+	return uploadStagePhotosBodyForTest(t, stageID, body, contentType)
+}
+
+func uploadStagePhotosBodyForTest(t *testing.T, stageID uint, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(
+		http.MethodPost, fmt.Sprintf("/my-recipe/stage/%d/upload-photo", stageID), body,
+	)
+	req.Header.Set(echo.HeaderContentType, contentType)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/stage/:stage_id/upload-photo")
+	c.SetParamNames("stage_id")
+	c.SetParamValues(fmt.Sprint(stageID))
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.AddStagePhotoHandle)(c))
+	return rec
+}
+
+func TestAddStagePhotos(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[0]
+
+	rec := uploadStagePhotosForTest(t, stage.ID, 2)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	respJson := PhotosResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	if assert.Equal(t, 2, len(respJson.Photos)) {
+		for i, photo := range respJson.Photos {
+			assert.Equal(t, i, photo.IntPhotoOrder)
+			assert.Equal(t, "подпись", photo.StrCaption)
+			assert.FileExists(t, path.Join(TestServer.UploadsPath, photo.StrImage))
+			for _, key := range []string{"webp", "thumbnail", "large"} {
+				if assert.Contains(t, photo.PhotoVariants, key) {
+					assert.FileExists(t, path.Join(TestServer.UploadsPath, photo.PhotoVariants[key]))
+				}
+			}
+		}
+	}
+}
+
+func TestAddStagePhotosOverLimit(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[0]
+
+	rec := uploadStagePhotosForTest(t, stage.ID, 2)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	respJson := DefaultResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "У этапа может быть не больше 3 фото", respJson.Message)
+}
+
+func TestReorderStagePhotos(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[0]
+	photos := stage.StagePhotos
+
+	reqMap := map[string]interface{}{
+		"photos": []uint{photos[1].ID, photos[0].ID},
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, fmt.Sprintf("/my-recipe/stage/%d/photo/reorder", stage.ID), strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/stage/:stage_id/photo/reorder")
+	c.SetParamNames("stage_id")
+	c.SetParamValues(fmt.Sprint(stage.ID))
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.ReorderStagePhotosHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		reordered, _ := TestServer.GetStageById(int(stage.ID))
+		assert.Equal(t, photos[1].ID, reordered.StagePhotos[0].ID)
+		assert.Equal(t, photos[0].ID, reordered.StagePhotos[1].ID)
+	}
+}
+
+func TestUpdateStagePhotoCaption(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	photo := recipe.RecipeStages[0].StagePhotos[0]
+
+	reqMap := map[string]interface{}{
+		"caption": "новая подпись",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, fmt.Sprintf("/my-recipe/photo/%d/update", photo.ID), strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT2))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/photo/:photo_id/update")
+	c.SetParamNames("photo_id")
+	c.SetParamValues(fmt.Sprint(photo.ID))
+
+	// Чужой пользователь не может менять подпись
+	if assert.NoError(t, TestJwtMiddleware(TestServer.UpdateStagePhotoHandle)(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	req = httptest.NewRequest(
+		http.MethodPost, fmt.Sprintf("/my-recipe/photo/%d/update", photo.ID), strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec = httptest.NewRecorder()
+
+	c = TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/photo/:photo_id/update")
+	c.SetParamNames("photo_id")
+	c.SetParamValues(fmt.Sprint(photo.ID))
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.UpdateStagePhotoHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		updated, _ := TestServer.GetPhotoById(int(photo.ID))
+		assert.Equal(t, "новая подпись", updated.StrCaption)
+	}
+}
+
+func TestDeleteStagePhoto(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[0]
+	photo := stage.StagePhotos[0]
+
+	req := httptest.NewRequest(
+		http.MethodDelete, fmt.Sprintf("/my-recipe/photo/%d/delete", photo.ID), nil,
+	)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/photo/:photo_id/delete")
+	c.SetParamNames("photo_id")
+	c.SetParamValues(fmt.Sprint(photo.ID))
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.DeleteStagePhotoHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// Оставшееся фото этапа использует то же изображение, поэтому файл не удаляется
+		assert.FileExists(t, path.Join(TestServer.UploadsPath, photo.StrImage))
+
+		updated, _ := TestServer.GetStageById(int(stage.ID))
+		if assert.Equal(t, 1, len(updated.StagePhotos)) {
+			assert.Equal(t, 0, updated.StagePhotos[0].IntPhotoOrder)
+		}
+	}
+}
+
+func deleteStagePhotoForTest(t *testing.T, photoID uint) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(
+		http.MethodDelete, fmt.Sprintf("/my-recipe/photo/%d/delete", photoID), nil,
+	)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/photo/:photo_id/delete")
+	c.SetParamNames("photo_id")
+	c.SetParamValues(fmt.Sprint(photoID))
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.DeleteStagePhotoHandle)(c))
+	return rec
+}
+
+func TestDeleteLastPhotoReference(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[len(recipe.RecipeStages)-1]
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i := 0; i < 2; i++ {
+		part, _ := writer.CreateFormFile("file", fmt.Sprintf("photo%d.png", i))
+		part.Write(pngColorForTest(color.RGBA{R: 128, G: 64, A: 255}))
+	}
+	writer.Close()
+
+	// Два одинаковых фото хранятся в одном файле
+	rec := uploadStagePhotosBodyForTest(t, stage.ID, body, writer.FormDataContentType())
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	respJson := PhotosResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &respJson)
+	if !assert.Equal(t, 2, len(respJson.Photos)) {
+		return
+	}
+	first, second := respJson.Photos[0], respJson.Photos[1]
+	assert.Equal(t, first.StrImage, second.StrImage)
+
+	rec = deleteStagePhotoForTest(t, first.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, first.StrImage))
+
+	// После удаления последней ссылки файл и его копии ждут сборщика мусора
+	rec = deleteStagePhotoForTest(t, second.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, second.StrImage))
+	assert.Equal(t, int64(1), orphanRecordsForTest(second.StrImage))
+
+	names := []string{second.StrImage}
+	for _, filename := range second.PhotoVariants {
+		assert.Equal(t, int64(1), orphanRecordsForTest(filename))
+		names = append(names, filename)
+	}
+
+	collectReleasedFilesForTest(t, names)
+	for _, filename := range names {
+		assert.NoFileExists(t, path.Join(TestServer.UploadsPath, filename))
+	}
+}
+
+// Функция для удаления освобождённых файлов, срок ожидания которых истёк
+func collectReleasedFilesForTest(t *testing.T, names []string) {
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range names {
+		os.Chtimes(path.Join(TestServer.UploadsPath, name), old, old)
+	}
+	TestServer.DB.Model(&models.OrphanFile{}).Where("str_file_name IN ?", names).Update("created_at", old)
+
+	_, err := TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour})
+	assert.Nil(t, err)
+}
+
+func TestReuploadReleasedPhoto(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[len(recipe.RecipeStages)-1]
+	data := pngColorForTest(color.RGBA{R: 77, G: 11, A: 255})
+
+	upload := func() models.Photo {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.png")
+		part.Write(data)
+		writer.Close()
+
+		rec := uploadStagePhotosBodyForTest(t, stage.ID, body, writer.FormDataContentType())
+		respJson := PhotosResponse{}
+		json.Unmarshal(rec.Body.Bytes(), &respJson)
+		if assert.Equal(t, http.StatusOK, rec.Code) && assert.Equal(t, 1, len(respJson.Photos)) {
+			return respJson.Photos[0]
+		}
+		return models.Photo{}
+	}
+
+	photo := upload()
+	rec := deleteStagePhotoForTest(t, photo.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(1), orphanRecordsForTest(photo.StrImage))
+
+	// Повторная загрузка снимает отметку, и сборщик мусора файл не трогает
+	photo = upload()
+	assert.Equal(t, int64(0), orphanRecordsForTest(photo.StrImage))
+	for _, filename := range photo.PhotoVariants {
+		assert.Equal(t, int64(0), orphanRecordsForTest(filename))
+	}
+
+	collectReleasedFilesForTest(t, []string{photo.StrImage})
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, photo.StrImage))
+
+	deleteStagePhotoForTest(t, photo.ID)
+}
+
+func uploadRecipeCoverForTest(t *testing.T) *httptest.ResponseRecorder {
+	return uploadRecipeCoverDataForTest(t, pngForTest())
+}
+
+func uploadRecipeCoverDataForTest(t *testing.T, data []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "cover")
+	part.Write(data)
+	writer.Close()
+	contentType := writer.FormDataContentType()
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/my-recipe/upload-cover/1", body,
+	)
+	req.Header.Set(echo.HeaderContentType, contentType)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/upload-cover/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.UploadRecipeCoverHandle)(c))
+	return rec
+}
+
+func TestUploadRecipeCover(t *testing.T) {
+	rec := uploadRecipeCoverForTest(t)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	respJson := CoverResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, respJson.Cover))
+	for _, key := range []string{"webp", "thumbnail", "thumbnail_webp", "card", "card_webp", "hero", "hero_webp"} {
+		if assert.Contains(t, respJson.Variants, key) {
+			assert.FileExists(t, path.Join(TestServer.UploadsPath, respJson.Variants[key]))
+		}
+	}
+
+	// Миниатюра вписывается в 160x160 с сохранением пропорций
+	file, err := os.Open(path.Join(TestServer.UploadsPath, respJson.Variants["thumbnail"]))
+	if assert.Nil(t, err) {
+		defer file.Close()
+		config, _, err := image.DecodeConfig(file)
+		assert.Nil(t, err)
+		assert.Equal(t, 160, config.Width)
+		assert.Equal(t, 120, config.Height)
+	}
+
+	recipe, _ := TestServer.GetRecipeById(1)
+	assert.Equal(t, respJson.Cover, recipe.StrRecipeImage)
+	assert.Equal(t, respJson.Variants, recipe.RecipeImageVariants)
+}
+
+func pngColorForTest(c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestReplaceRecipeCover(t *testing.T) {
+	rec := uploadRecipeCoverDataForTest(t, pngColorForTest(color.RGBA{R: 255, A: 255}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	old, _ := TestServer.GetRecipeById(1)
+
+	rec = uploadRecipeCoverDataForTest(t, pngColorForTest(color.RGBA{B: 255, A: 255}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Старая обложка удаляется сборщиком мусора
+	names := []string{old.StrRecipeImage}
+	for _, filename := range old.RecipeImageVariants {
+		names = append(names, filename)
+	}
+	assert.Equal(t, int64(1), orphanRecordsForTest(old.StrRecipeImage))
+
+	collectReleasedFilesForTest(t, names)
+	for _, filename := range names {
+		assert.NoFileExists(t, path.Join(TestServer.UploadsPath, filename))
+	}
+}
+
+func TestUploadSameRecipeCover(t *testing.T) {
+	old, _ := TestServer.GetRecipeById(1)
+
+	// То же изображение получает то же имя и не удаляется при замене
+	rec := uploadRecipeCoverDataForTest(t, pngColorForTest(color.RGBA{B: 255, A: 255}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	recipe, _ := TestServer.GetRecipeById(1)
+	assert.Equal(t, old.StrRecipeImage, recipe.StrRecipeImage)
+	assert.Equal(t, ContentFileName(mustReadFileForTest(t, recipe.StrRecipeImage), "png"), recipe.StrRecipeImage)
+
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, recipe.StrRecipeImage))
+	for _, filename := range recipe.RecipeImageVariants {
+		assert.FileExists(t, path.Join(TestServer.UploadsPath, filename))
+	}
+}
+
+func mustReadFileForTest(t *testing.T, filename string) []byte {
+	data, err := os.ReadFile(path.Join(TestServer.UploadsPath, filename))
+	assert.Nil(t, err)
+	return data
+}
+
+func TestSharedImageReferences(t *testing.T) {
+	// Обложка с тем же изображением, что и у фото этапов
+	rec := uploadRecipeCoverForTest(t)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	recipe, _ := TestServer.GetRecipeById(1)
+	filename := recipe.StrRecipeImage
+
+	var photos []models.Photo
+	TestServer.DB.Find(&photos, "str_image = ?", filename)
+	assert.NotEmpty(t, photos)
+
+	count, err := TestServer.CountFileReferences(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(photos)+1), count)
+
+	// После замены обложки файл остаётся, потому что он нужен фото
+	rec = uploadRecipeCoverDataForTest(t, pngColorForTest(color.RGBA{G: 255, A: 255}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	count, _ = TestServer.CountFileReferences(filename)
+	assert.Equal(t, int64(len(photos)), count)
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, filename))
+}
+
+func TestRandomString(t *testing.T) {
+	a, b := RandomString(16), RandomString(16)
+	assert.Len(t, a, 16)
+	assert.NotEqual(t, a, b)
+}
+
+// JPEG 40x20 с EXIF: ориентация "поворот на 90°" и строка вместо координат
+func jpegWithExifForTest() []byte {
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil)
+	data := buf.Bytes()
+
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // заголовок TIFF
+		0x00, 0x01, // одна запись в каталоге
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00, // ориентация = 6
+		0x00, 0x00, 0x00, 0x00, // следующего каталога нет
+	}
+	tiff = append(tiff, []byte("GPS 61.78N 34.35E")...)
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}
+	segment = append(segment, exif...)
+
+	result := append([]byte{}, data[:2]...)
+	result = append(result, segment...)
+	return append(result, data[2:]...)
+}
+
+func TestReadJpegOrientation(t *testing.T) {
+	assert.Equal(t, OrientationRotate90, ReadJpegOrientation(jpegWithExifForTest()))
+	assert.Equal(t, OrientationNormal, ReadJpegOrientation(pngForTest()))
+	assert.Equal(t, OrientationNormal, ReadJpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF}))
+}
+
+func TestApplyOrientation(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	rotated := ApplyOrientation(img, OrientationRotate90)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), rotated.Bounds())
+	assert.Equal(t, red, rotated.At(0, 0))
+	assert.Equal(t, blue, rotated.At(0, 1))
+
+	flipped := ApplyOrientation(img, OrientationFlipH)
+	assert.Equal(t, blue, flipped.At(0, 0))
+	assert.Equal(t, red, flipped.At(1, 0))
+
+	assert.Equal(t, img, ApplyOrientation(img, OrientationNormal))
+}
+
+func TestUploadRecipeCoverStripsExif(t *testing.T) {
+	rec := uploadRecipeCoverDataForTest(t, jpegWithExifForTest())
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	respJson := CoverResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(path.Join(TestServer.UploadsPath, respJson.Cover))
+	if assert.Nil(t, err) {
+		assert.NotContains(t, string(data), "Exif")
+		assert.NotContains(t, string(data), "GPS")
+
+		// Изображение повёрнуто согласно EXIF
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.Equal(t, 20, config.Width)
+		assert.Equal(t, 40, config.Height)
+	}
+}
+
+func TestUploadRecipeCoverTooLarge(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, TestServer.MaxImageSide+1, 10)))
+
+	files, _ := os.ReadDir(TestServer.UploadsPath)
+
+	rec := uploadRecipeCoverDataForTest(t, buf.Bytes())
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Отклонённое изображение не остаётся на диске
+	filesAfter, _ := os.ReadDir(TestServer.UploadsPath)
+	assert.Equal(t, len(files), len(filesAfter))
+}
+
+func gifFramesForTest(frames int, side int) []byte {
+	palette := color.Palette{color.Black, color.White}
+	animation := gif.GIF{}
+	for i := 0; i < frames; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, side, side), palette))
+		animation.Delay = append(animation.Delay, 1)
+	}
+	buf := bytes.Buffer{}
+	gif.EncodeAll(&buf, &animation)
+	return buf.Bytes()
+}
+
+func TestGifFrameLimit(t *testing.T) {
+	data := gifFramesForTest(40, 100)
+	pixels, err := GifFramePixels(data)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(40*100*100), pixels)
+
+	_, err = GifFramePixels(data[:len(data)-10])
+	assert.Error(t, err)
+
+	// Размер экрана допустим, но кадров слишком много
+	defer func(limit int) { TestServer.MaxImagePixels = limit }(TestServer.MaxImagePixels)
+	TestServer.MaxImagePixels = 100000
+
+	buf := bytes.Buffer{}
+	assert.Error(t, TestServer.SanitizeImage(&buf, data, "gif"))
+	assert.NoError(t, TestServer.SanitizeImage(&buf, gifFramesForTest(5, 100), "gif"))
+}
+
+func TestSaveFileUploadLimit(t *testing.T) {
+	defer func(limit string) { TestServer.UploadBodyLimit = limit }(TestServer.UploadBodyLimit)
+	TestServer.UploadBodyLimit = "1K"
+
+	name := path.Join(t.TempDir(), "large.png")
+	assert.NoError(t, os.WriteFile(name, append(pngForTest(), make([]byte, 2048)...), 0644))
+	file, err := os.Open(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+
+	_, err = TestServer.SaveFileWithExt(file, "png")
+	assert.Equal(t, ErrFileTooLarge, err)
+}
+
+func TestUploadRecipeCoverNotImage(t *testing.T) {
+	// Заголовок PNG без данных изображения
+	rec := uploadRecipeCoverDataForTest(t, pngForTest()[:64])
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestImagePoolRun(t *testing.T) {
+	pool := NewImagePool(1)
+
+	calls := 0
+	err := pool.Run(func() error {
+		calls++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+
+	assert.Equal(t, ErrImagePoolBusy, pool.Run(func() error { return ErrImagePoolBusy }))
+}
+
+func TestImagePoolPanic(t *testing.T) {
+	pool := NewImagePool(1)
+
+	// Паника в задаче возвращается как ошибка, а обработчик продолжает работать
+	err := pool.Run(func() error {
+		var image []byte
+		_ = image[1]
+		return nil
+	})
+	assert.Error(t, err)
+
+	assert.Nil(t, pool.Run(func() error { return nil }))
+}
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := NewLocalStorage(path.Join(os.TempDir(), "recipe_book_storage_test"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(storage.Root)
+
+	err = storage.Put("file.txt", strings.NewReader("hello"), 5, "text/plain")
+	assert.Nil(t, err)
+
+	info, err := storage.Stat("file.txt")
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(5), info.Size)
+		assert.Equal(t, "file.txt", info.Name)
+	}
+
+	// Путь к файлу не выходит за пределы папки хранилища
+	file, _, err := storage.Get("../../file.txt")
+	if assert.Nil(t, err) {
+		data, _ := io.ReadAll(file)
+		file.Close()
+		assert.Equal(t, "hello", string(data))
+	}
+
+	_, err = storage.PresignedURL("file.txt", time.Minute)
+	assert.Equal(t, ErrPresignNotSupported, err)
+
+	assert.Nil(t, storage.Delete("file.txt"))
+	assert.Nil(t, storage.Delete("file.txt"))
+
+	_, err = storage.Stat("file.txt")
+	assert.Equal(t, ErrFileNotFound, err)
+
+	_, _, err = storage.Get("file.txt")
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func TestS3StoragePresignedURL(t *testing.T) {
+	storage, err := NewS3Storage(S3Config{
+		Endpoint:       "minio:9000",
+		PublicEndpoint: "cdn.example.com",
+		AccessKey:      "access",
+		SecretKey:      "secret",
+		Bucket:         "uploads",
+		UseSSL:         true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	presigned, err := storage.PresignedURL("cover.jpg", time.Minute)
+	if assert.Nil(t, err) {
+		assert.True(t, strings.HasPrefix(presigned, "https://cdn.example.com/uploads/cover.jpg?"))
+		assert.Contains(t, presigned, "X-Amz-Expires=60")
+		assert.Contains(t, presigned, "X-Amz-Signature=")
+	}
+
+	_, err = NewS3Storage(S3Config{Endpoint: "minio:9000"})
+	assert.NotNil(t, err)
+}
+
+// Проверка S3-хранилища на настоящем MinIO, например:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	MINIO_ENDPOINT=localhost:9000 go test -run TestS3StorageMinio
+func TestS3StorageMinio(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT не задан")
+	}
+
+	accessKey, secretKey := os.Getenv("MINIO_ACCESS_KEY"), os.Getenv("MINIO_SECRET_KEY")
+	if accessKey == "" {
+		accessKey, secretKey = "minioadmin", "minioadmin"
+	}
+
+	storage, err := NewS3Storage(S3Config{
+		Endpoint:  endpoint,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Bucket:    "recipe-book-test",
+	})
+	if !assert.Nil(t, err) || !assert.Nil(t, storage.EnsureBucket()) {
+		return
+	}
+
+	err = storage.Put("file.txt", strings.NewReader("hello"), 5, "text/plain")
+	assert.Nil(t, err)
+
+	info, err := storage.Stat("file.txt")
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(5), info.Size)
+		assert.Equal(t, "text/plain", info.ContentType)
+	}
+
+	file, _, err := storage.Get("file.txt")
+	if assert.Nil(t, err) {
+		data, _ := io.ReadAll(file)
+		file.Close()
+		assert.Equal(t, "hello", string(data))
+	}
+
+	// Файл скачивается по подписанной ссылке без авторизации
+	presigned, err := storage.PresignedURL("file.txt", time.Minute)
+	if assert.Nil(t, err) {
+		resp, err := http.Get(presigned)
+		if assert.Nil(t, err) {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "hello", string(data))
+		}
+	}
+
+	assert.Nil(t, storage.Delete("file.txt"))
+	assert.Nil(t, storage.Delete("file.txt"))
+
+	_, err = storage.Stat("file.txt")
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func getFileURLForTest(t *testing.T, filename string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/assets/%s/url", filename), nil)
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/assets/:filename/url")
+	c.SetParamNames("filename")
+	c.SetParamValues(filename)
+
+	assert.NoError(t, TestServer.GetFileURLHandle(c))
+	return rec
+}
+
+func TestGetFileURL(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	rec := getFileURLForTest(t, recipe.StrRecipeImage)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	respJson := FileURLResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+	assert.Nil(t, err)
+
+	// Локальное хранилище отдаёт файлы через сервер
+	assert.Equal(t, "/assets/"+recipe.StrRecipeImage, respJson.Url)
+	assert.Nil(t, respJson.ExpiresAt)
+
+	rec = getFileURLForTest(t, "a.hehe")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDownloadStoredFile(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/"+recipe.StrRecipeImage, nil)
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/assets/:filename")
+	c.SetParamNames("filename")
+	c.SetParamValues(recipe.StrRecipeImage)
+
+	if assert.NoError(t, TestServer.DownloadFile(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, ContentTypeByName(recipe.StrRecipeImage), rec.Header().Get(echo.HeaderContentType))
+
+		_, _, err := image.Decode(rec.Body)
+		assert.Nil(t, err)
+	}
+}
+
+func orphanRecordsForTest(name string) int64 {
+	var count int64
+	TestServer.DB.Model(&models.OrphanFile{}).Where("str_file_name = ?", name).Count(&count)
+	return count
+}
+
+func TestCollectGarbage(t *testing.T) {
+	name := "orphan_for_gc_test.png"
+	data := pngColorForTest(color.RGBA{R: 1, G: 2, B: 3, A: 255})
+	err := TestServer.Storage.Put(name, bytes.NewReader(data), int64(len(data)), "image/png")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(path.Join(TestServer.UploadsPath, name), old, old)
+
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	// Пробный запуск ничего не удаляет и не запоминает
+	report, err := TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour, DryRun: true})
+	if assert.Nil(t, err) {
+		assert.True(t, report.DryRun)
+		assert.Contains(t, report.Pending, name)
+		assert.NotContains(t, report.Pending, recipe.StrRecipeImage)
+		assert.NotContains(t, report.Deleted, recipe.StrRecipeImage)
+		assert.Equal(t, int64(0), orphanRecordsForTest(name))
+	}
+
+	// Файл без ссылок запоминается, но срок ожидания ещё не прошёл
+	report, err = TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour})
+	if assert.Nil(t, err) {
+		assert.Contains(t, report.Pending, name)
+		assert.NotContains(t, report.Deleted, name)
+		assert.Equal(t, int64(1), orphanRecordsForTest(name))
+		assert.FileExists(t, path.Join(TestServer.UploadsPath, name))
+	}
+
+	// Файл без ссылок дольше срока ожидания
+	TestServer.DB.Model(&models.OrphanFile{}).Where("str_file_name = ?", name).Update("created_at", old)
+
+	report, err = TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour, DryRun: true})
+	if assert.Nil(t, err) {
+		assert.Contains(t, report.Deleted, name)
+		assert.FileExists(t, path.Join(TestServer.UploadsPath, name))
+	}
+
+	report, err = TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour})
+	if assert.Nil(t, err) {
+		assert.Contains(t, report.Deleted, name)
+		assert.GreaterOrEqual(t, report.FreedBytes, int64(len(data)))
+		assert.NoFileExists(t, path.Join(TestServer.UploadsPath, name))
+		assert.Equal(t, int64(0), orphanRecordsForTest(name))
+	}
+
+	// Используемые файлы не удаляются
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, recipe.StrRecipeImage))
+	for _, filename := range recipe.RecipeImageVariants {
+		assert.FileExists(t, path.Join(TestServer.UploadsPath, filename))
+	}
+}
+
+func TestCollectGarbageDeletedRecipePhotos(t *testing.T) {
+	original, variant := "deleted_recipe_photo_for_gc_test.png", "deleted_recipe_photo_for_gc_test_thumbnail.png"
+	data := pngColorForTest(color.RGBA{R: 3, G: 2, B: 1, A: 255})
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{original, variant} {
+		if !assert.Nil(t, TestServer.Storage.Put(name, bytes.NewReader(data), int64(len(data)), "image/png")) {
+			return
+		}
+		os.Chtimes(path.Join(TestServer.UploadsPath, name), old, old)
+	}
+
+	recipe := models.Recipe{StrRecipeName: "Удалённый рецепт с фото", IntUserId: 1, StrRecipeStatus: RecipeStatusDraft}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	stage := models.Stage{StrStageDesc: "Этап", IntRecipeId: recipe.ID}
+	assert.NoError(t, TestServer.DB.Create(&stage).Error)
+	photo := models.Photo{StrImage: original, PhotoVariants: map[string]string{"thumbnail": variant}, IntStageId: stage.ID}
+	assert.NoError(t, TestServer.DB.Create(&photo).Error)
+
+	// Пока рецепт не удалён, файл и его копия используются
+	count, err := TestServer.CountFileReferences(original)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	count, _ = TestServer.CountFileReferences(variant)
+	assert.Equal(t, int64(1), count)
+
+	// Фото этапов удалённого рецепта ссылками не считаются
+	assert.NoError(t, TestServer.DB.Delete(&recipe).Error)
+	count, _ = TestServer.CountFileReferences(original)
+	assert.Equal(t, int64(0), count)
+
+	_, err = TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour})
+	assert.Nil(t, err)
+	TestServer.DB.Model(&models.OrphanFile{}).Where("str_file_name IN ?", []string{original, variant}).Update("created_at", old)
+
+	report, err := TestServer.CollectGarbage(GCOptions{GracePeriod: time.Hour})
+	if assert.Nil(t, err) {
+		assert.Contains(t, report.Deleted, original)
+		assert.Contains(t, report.Deleted, variant)
+	}
+	assert.NoFileExists(t, path.Join(TestServer.UploadsPath, original))
+	assert.NoFileExists(t, path.Join(TestServer.UploadsPath, variant))
+	assert.Equal(t, int64(0), orphanRecordsForTest(original))
+}
+
+func TestGCCommand(t *testing.T) {
+	out := bytes.Buffer{}
+	err := TestServer.RunGCCommand([]string{"-dry-run", "-grace", "1h"}, &out)
+	if assert.Nil(t, err) {
+		report := GCReport{}
+		assert.Nil(t, json.Unmarshal(out.Bytes(), &report))
+		assert.True(t, report.DryRun)
+		assert.Greater(t, report.Referenced, 0)
+	}
+
+	assert.NotNil(t, TestServer.RunGCCommand([]string{"-grace", "abc"}, &out))
+}
+
+func downloadFileForTest(t *testing.T, filename string, query string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/assets/"+filename+query, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/assets/:filename")
+	c.SetParamNames("filename")
+	c.SetParamValues(filename)
+
+	assert.NoError(t, TestServer.DownloadFile(c))
+	return rec
+}
+
+func TestAcceptsMediaType(t *testing.T) {
+	assert.True(t, AcceptsMediaType("image/avif,image/webp,image/apng,*/*;q=0.8", "image/webp"))
+	assert.True(t, AcceptsMediaType("image/webp;q=0.5", "image/webp"))
+	assert.False(t, AcceptsMediaType("image/webp;q=0", "image/webp"))
+	assert.False(t, AcceptsMediaType("image/*,*/*", "image/webp"))
+	assert.False(t, AcceptsMediaType("", "image/avif"))
+}
+
+func TestDownloadFileCacheHeaders(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	// Изображение рецепта может стать закрытым, поэтому кэшируется недолго
+	rec := downloadFileForTest(t, recipe.StrRecipeImage, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, CacheControlRecipeFile, rec.Header().Get("Cache-Control"))
+
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, fmt.Sprintf("\"%s\"", recipe.StrRecipeImage), etag)
+
+	// Файл не изменился
+	rec = downloadFileForTest(t, recipe.StrRecipeImage, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, 0, rec.Body.Len())
+
+	rec = downloadFileForTest(t, recipe.StrRecipeImage, "", map[string]string{"If-None-Match": "\"other\""})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Файл с хэшем в имени, который не используется в рецептах, не меняется
+	name := strings.Repeat("ab", 32) + ".png"
+	data := pngColorForTest(color.RGBA{G: 9, A: 255})
+	TestServer.Storage.Put(name, bytes.NewReader(data), int64(len(data)), "image/png")
+	defer TestServer.Storage.Delete(name)
+
+	rec = downloadFileForTest(t, name, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, CacheControlImmutable, rec.Header().Get("Cache-Control"))
+}
+
+func TestDownloadFileRange(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	data := mustReadFileForTest(t, recipe.StrRecipeImage)
+
+	rec := downloadFileForTest(t, recipe.StrRecipeImage, "", map[string]string{"Range": "bytes=0-9"})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, data[:10], rec.Body.Bytes())
+	assert.Equal(t, fmt.Sprintf("bytes 0-9/%d", len(data)), rec.Header().Get("Content-Range"))
+
+	rec = downloadFileForTest(t, recipe.StrRecipeImage, "", map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(data)+10)})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+}
+
+func TestDownloadFileNegotiation(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	cover := recipe.StrRecipeImage
+
+	rec := downloadFileForTest(t, cover, "", map[string]string{"Accept": "image/webp,*/*"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/webp", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, echo.HeaderAccept, rec.Header().Get(echo.HeaderVary))
+	assert.Equal(t, mustReadFileForTest(t, recipe.RecipeImageVariants["webp"]), rec.Body.Bytes())
+
+	// Копия нужного размера тоже подбирается по формату
+	rec = downloadFileForTest(t, cover, "?variant=thumbnail", map[string]string{"Accept": "image/webp"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mustReadFileForTest(t, recipe.RecipeImageVariants["thumbnail_webp"]), rec.Body.Bytes())
+
+	rec = downloadFileForTest(t, cover, "?variant=thumbnail", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mustReadFileForTest(t, recipe.RecipeImageVariants["thumbnail"]), rec.Body.Bytes())
+
+	rec = downloadFileForTest(t, cover, "?variant=../x", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = downloadFileForTest(t, cover, "?variant=huge", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// AVIF предпочтительнее WebP, если такая версия есть
+	base := strings.TrimSuffix(cover, path.Ext(cover))
+	avif := []byte("avif")
+	TestServer.Storage.Put(base+".avif", bytes.NewReader(avif), int64(len(avif)), "image/avif")
+	defer TestServer.Storage.Delete(base + ".avif")
+
+	rec = downloadFileForTest(t, cover, "", map[string]string{"Accept": "image/avif,image/webp"})
+	assert.Equal(t, "image/avif", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, avif, rec.Body.Bytes())
+
+	rec = downloadFileForTest(t, cover, "", map[string]string{"Accept": "image/avif;q=0,image/webp"})
+	assert.Equal(t, "image/webp", rec.Header().Get(echo.HeaderContentType))
+}
+
+func TestDownloadLegacyFileCacheHeaders(t *testing.T) {
+	name := "legacyNameForTest.png"
+	data := pngColorForTest(color.RGBA{R: 9, A: 255})
+	TestServer.Storage.Put(name, bytes.NewReader(data), int64(len(data)), "image/png")
+	defer TestServer.Storage.Delete(name)
+
+	rec := downloadFileForTest(t, name, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, CacheControlRevalidate, rec.Header().Get("Cache-Control"))
+
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	rec = downloadFileForTest(t, name, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+}
+
+func profileForTest(t *testing.T, token string) UserProfileResponse {
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+
+	respJson := UserProfileResponse{}
+	if assert.NoError(t, TestJwtMiddleware(TestServer.ProfileHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &respJson))
+	}
+	return respJson
+}
+
+func setUserQuotaForTest(t *testing.T, token string, userID uint, quota int64) *httptest.ResponseRecorder {
+	reqJson, _ := json.Marshal(map[string]interface{}{"quota": quota})
+
+	req := httptest.NewRequest(
+		http.MethodPost, fmt.Sprintf("/user/%d/quota", userID), strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/user/:id/quota")
+	c.SetParamNames("id")
+	c.SetParamValues(fmt.Sprint(userID))
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.SetUserQuotaHandle)(c))
+	return rec
+}
+
+func TestProfileStorageUsage(t *testing.T) {
+	profile := profileForTest(t, UserJWT)
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	// Занято место под обложку и фото этапов рецепта
+	expected := recipe.IntRecipeImageSize
+	for _, stage := range recipe.RecipeStages {
+		expected += PhotosBytes(stage.StagePhotos)
+	}
+	assert.Greater(t, recipe.IntRecipeImageSize, int64(0))
+	assert.Equal(t, expected, profile.Storage.Used)
+	assert.Equal(t, int64(DefaultStorageQuota), profile.Storage.Quota)
+	assert.Equal(t, profile.Storage.Quota-profile.Storage.Used, profile.Storage.Remaining)
+}
+
+func TestSetUserQuota(t *testing.T) {
+	// Только администратор может менять квоту
+	rec := setUserQuotaForTest(t, UserJWT2, 2, StorageQuotaUnlimited)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = setUserQuotaForTest(t, UserJWT, 2, -5)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = setUserQuotaForTest(t, UserJWT, 100, 1)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = setUserQuotaForTest(t, UserJWT, 2, StorageQuotaUnlimited)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	profile := profileForTest(t, UserJWT2)
+	assert.Equal(t, int64(StorageQuotaUnlimited), profile.Storage.Quota)
+	assert.Equal(t, int64(StorageQuotaUnlimited), profile.Storage.Remaining)
+
+	rec = setUserQuotaForTest(t, UserJWT, 2, StorageQuotaDefault)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(DefaultStorageQuota), profileForTest(t, UserJWT2).Storage.Quota)
+}
+
+func TestUploadQuotaExceeded(t *testing.T) {
+	used := profileForTest(t, UserJWT).Storage.Used
+
+	// Места остаётся меньше, чем размер загружаемого файла
+	rec := setUserQuotaForTest(t, UserJWT, 1, used+10)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	defer setUserQuotaForTest(t, UserJWT, 1, StorageQuotaDefault)
+
+	files, _ := os.ReadDir(TestServer.UploadsPath)
+
+	rec = uploadRecipeCoverDataForTest(t, pngColorForTest(color.RGBA{R: 77, A: 255}))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	recipe, _ := TestServer.GetRecipeById(1)
+	rec = uploadStagePhotosForTest(t, recipe.RecipeStages[0].ID, 1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Файлы не сохранялись
+	filesAfter, _ := os.ReadDir(TestServer.UploadsPath)
+	assert.Equal(t, len(files), len(filesAfter))
+	assert.Equal(t, used, profileForTest(t, UserJWT).Storage.Used)
+}
+
+func TestReserveStorageWithVariants(t *testing.T) {
+	used := profileForTest(t, UserJWT).Storage.Used
+	data := pngColorForTest(color.RGBA{G: 91, B: 19, A: 255})
+
+	// Исходный файл помещается в квоту, а вместе с копиями - уже нет
+	rec := setUserQuotaForTest(t, UserJWT, 1, used+int64(len(data))+1)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	defer setUserQuotaForTest(t, UserJWT, 1, StorageQuotaDefault)
+
+	recipe, _ := TestServer.GetRecipeById(1)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.png")
+	part.Write(data)
+	writer.Close()
+
+	rec = uploadStagePhotosBodyForTest(t, recipe.RecipeStages[0].ID, body, writer.FormDataContentType())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, used, profileForTest(t, UserJWT).Storage.Used)
+
+	// Место занимается только в пределах квоты
+	var user models.User
+	TestServer.DB.First(&user, 1)
+	assert.NoError(t, TestServer.ReserveStorage(&user, int64(len(data))))
+	assert.Equal(t, ErrQuotaExceeded, TestServer.ReserveStorage(&user, int64(len(data))))
+	assert.Equal(t, used+int64(len(data)), profileForTest(t, UserJWT).Storage.Used)
+
+	TestServer.ChargeStorage(user.ID, -int64(len(data)))
+	assert.Equal(t, used, profileForTest(t, UserJWT).Storage.Used)
+}
+
+func TestStorageUsageRefund(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	stage := recipe.RecipeStages[len(recipe.RecipeStages)-1]
+	used := profileForTest(t, UserJWT).Storage.Used
+
+	rec := uploadStagePhotosForTest(t, stage.ID, 1)
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	respJson := PhotosResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &respJson)
+	photo := respJson.Photos[0]
+	assert.Greater(t, photo.IntImageSize, int64(0))
+	assert.Equal(t, used+photo.IntImageSize, profileForTest(t, UserJWT).Storage.Used)
+
+	rec = deleteStagePhotoForTest(t, photo.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, used, profileForTest(t, UserJWT).Storage.Used)
+}
+
+func TestUploadBodyLimit(t *testing.T) {
+	body := bytes.NewReader(make([]byte, 2<<20))
+	req := httptest.NewRequest(http.MethodPost, "/my-recipe/upload-cover/1", body)
+	req.Header.Set(echo.HeaderContentType, "multipart/form-data; boundary=x")
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	req.Header.Set("If-Match", "*")
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/upload-cover/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	handler := TestServer.UploadLimitMiddleware()(TestJwtMiddleware(TestServer.UploadRecipeCoverHandle))
+	err := handler(c)
+
+	httpErr, ok := err.(*echo.HTTPError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, httpErr.Code)
+	}
+}
+
+func getMyRecipeForTest(t *testing.T, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/user/recipe/"+id, nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/user/recipe/:id")
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+
+	assert.NoError(t, TestJwtMiddleware(TestServer.GetMyRecipeHandle)(c))
+	return rec
+}
+
+func TestPrivateRecipeSignedURLs(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	assert.NoError(t, TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("bool_recipe_visibility", false).Error)
+	defer TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("bool_recipe_visibility", true)
+
+	private, err := TestServer.IsPrivateFile(recipe.StrRecipeImage)
+	assert.NoError(t, err)
+	assert.True(t, private)
+
+	// Без подписи файл не отдаётся
+	rec := downloadFileForTest(t, recipe.StrRecipeImage, "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = downloadFileForTest(t, recipe.StrRecipeImage, "?variant=thumbnail", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Автор получает подписанные ссылки вместе с рецептом
+	rec = getMyRecipeForTest(t, "1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := ScaledRecipeResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	signed, ok := resp.SignedURLs[recipe.StrRecipeImage]
+	if assert.True(t, ok) {
+		query := strings.TrimPrefix(signed, "/assets/"+recipe.StrRecipeImage)
+		rec = downloadFileForTest(t, recipe.StrRecipeImage, query, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Cache-Control"), "private, max-age="))
+
+		rec = downloadFileForTest(t, recipe.StrRecipeImage, query+"&variant=thumbnail", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// Подпись не подходит к другому файлу
+		other := ImageFamily(recipe.StrRecipeImage) + "_thumbnail.png"
+		rec = downloadFileForTest(t, other, query, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+
+	// Истёкшая и подделанная подписи
+	expired := time.Now().Add(-time.Minute).Unix()
+	query := fmt.Sprintf("?expires=%d&signature=%s", expired, TestServer.SignFileName(recipe.StrRecipeImage, expired))
+	rec = downloadFileForTest(t, recipe.StrRecipeImage, query, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	future := time.Now().Add(time.Hour).Unix()
+	query = fmt.Sprintf("?expires=%d&signature=%s", future+1, TestServer.SignFileName(recipe.StrRecipeImage, future))
+	rec = downloadFileForTest(t, recipe.StrRecipeImage, query, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestPublicRecipeImageNotSigned(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+
+	private, err := TestServer.IsPrivateFile(recipe.StrRecipeImage)
+	assert.NoError(t, err)
+	assert.False(t, private)
+
+	rec := getMyRecipeForTest(t, "1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "signed_urls")
+}
+
+func TestDraftRecipeImageSigned(t *testing.T) {
+	recipe, _ := TestServer.GetRecipeById(1)
+	assert.True(t, recipe.BoolRecipeVisibility)
+	assert.NoError(t, TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("str_recipe_status", RecipeStatusDraft).Error)
+	defer TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("str_recipe_status", recipe.StrRecipeStatus)
+
+	// Черновик закрыт, даже если он отмечен как видимый
+	private, err := TestServer.IsPrivateFile(recipe.StrRecipeImage)
+	assert.NoError(t, err)
+	assert.True(t, private)
+
+	rec := downloadFileForTest(t, recipe.StrRecipeImage, "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = getMyRecipeForTest(t, "1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := ScaledRecipeResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Contains(t, resp.SignedURLs, recipe.StrRecipeImage)
+}
+
+func TestRecipeLifecycle(t *testing.T) {
+	var user models.User
+	assert.NoError(t, TestServer.DB.First(&user, "id = ?", 1).Error)
+	var ingredient models.Ingredient
+	assert.NoError(t, TestServer.DB.First(&ingredient).Error)
+
+	recipe := models.Recipe{
+		StrRecipeName:        "Lifecycle",
+		IntServings:          2,
+		BoolRecipeVisibility: true,
+		StrRecipeStatus:      RecipeStatusDraft,
+		IntUserId:            user.ID,
+		RecipeStages:         []models.Stage{{StrStageDesc: "Этап"}},
+		RecipeIngredients:    []models.RecipeIngredient{{IntIngredientId: ingredient.ID, IntGrams: 100}},
+	}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+
+	listed := func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/recipe/all", nil)
+		rec := httptest.NewRecorder()
+		c := TestE.NewContext(req, rec)
+		assert.NoError(t, TestServer.GetRecipesHandle(c))
+
+		var recipes []models.Recipe
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &recipes))
+		for _, r := range recipes {
+			if r.ID == recipe.ID {
+				return true
+			}
+		}
+		return false
+	}
+	status := func() string {
+		var r models.Recipe
+		TestServer.DB.First(&r, "id = ?", recipe.ID)
+		return r.StrRecipeStatus
+	}
+
+	// Черновик не показывается в списках и не открывается по ссылке
+	assert.False(t, listed())
+	req := httptest.NewRequest(http.MethodGet, "/recipe/", nil)
+	rec := httptest.NewRecorder()
+	c := TestE.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(fmt.Sprint(recipe.ID))
+	assert.NoError(t, TestServer.GetRecipeHandle(c))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// Из черновика нельзя сразу в архив
+	rec = changeRecipeStatusForTest(t, TestServer.ArchiveRecipeHandle, recipe.ID)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = changeRecipeStatusForTest(t, TestServer.PublishRecipeHandle, recipe.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, RecipeStatusPublished, status())
+	assert.True(t, listed())
+
+	// Архивный рецепт пропадает из списков
+	rec = changeRecipeStatusForTest(t, TestServer.ArchiveRecipeHandle, recipe.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, RecipeStatusArchived, status())
+	assert.False(t, listed())
+
+	rec = changeRecipeStatusForTest(t, TestServer.UnpublishRecipeHandle, recipe.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, RecipeStatusDraft, status())
+
+	// Без порций рецепт не опубликовать
+	TestServer.DB.Model(&recipe).UpdateColumn("int_servings", 0)
+	rec = changeRecipeStatusForTest(t, TestServer.PublishRecipeHandle, recipe.ID)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Количество порций должно быть больше нуля")
+}
+
+func TestChangeRecipeStatusByAnother(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/my-recipe/publish/1", nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT2))
+	req.Header.Set("If-Match", "*")
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.ArchiveRecipeHandle)(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Рецепт принадлежит другому пользователю")
+	}
+}
+
+func recipeRequestForTest(t *testing.T, handler echo.HandlerFunc, token string, path string, params map[string]string, body map[string]interface{}) *httptest.ResponseRecorder {
+	return recipeRequestWithHeadersForTest(t, handler, token, path, params, body, nil)
+}
+
+func editRequestForTest(t *testing.T, handler echo.HandlerFunc, token string, path string, params map[string]string, body map[string]interface{}) *httptest.ResponseRecorder {
+	return recipeRequestWithHeadersForTest(t, handler, token, path, params, body, map[string]string{"If-Match": "*"})
+}
+
+func recipeRequestWithHeadersForTest(t *testing.T, handler echo.HandlerFunc, token string, path string, params map[string]string, body map[string]interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	reqJson, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(reqJson)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	var names, values []string
+	for name, value := range params {
+		names = append(names, name)
+		values = append(values, value)
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	// Запрос от анонимного пользователя
+	if token == "" {
+		assert.NoError(t, handler(c))
+		return rec
+	}
+
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	assert.NoError(t, TestJwtMiddleware(handler)(c))
+	return rec
+}
+
+func TestRecipeRevisions(t *testing.T) {
+	var ingredient models.Ingredient
+	assert.NoError(t, TestServer.DB.First(&ingredient).Error)
+
+	recipe := models.Recipe{StrRecipeName: "Первая версия", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+
+	id := fmt.Sprint(recipe.ID)
+	revision, err := TestServer.SaveRecipeRevision(recipe.ID, 1, "Рецепт создан")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revision.IntRevision)
+
+	// Каждое сохранение - новая версия
+	rec := recipeRequestWithHeadersForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/"+id,
+		map[string]string{"id": id}, map[string]interface{}{"name": "Вторая версия", "servings": 4}, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = editRequestForTest(t, TestServer.CreateStageHandle, UserJWT, "/my-recipe/"+id+"/stage/add",
+		map[string]string{"recipe_id": id}, map[string]interface{}{"description": "Сварить"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = editRequestForTest(t, TestServer.AddIngredientHandle, UserJWT, "/my-recipe/"+id+"/ingredient/add",
+		map[string]string{"recipe_id": id}, map[string]interface{}{"ingredient_id": ingredient.ID, "grams": 100})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Сохранение без изменений версию не создаёт
+	revision, err = TestServer.SaveRecipeRevision(recipe.ID, 1, "Без изменений")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, revision.IntRevision)
+
+	rec = recipeRequestForTest(t, TestServer.GetRecipeRevisionsHandle, UserJWT, "/my-recipe/"+id+"/revisions",
+		map[string]string{"recipe_id": id}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var revisions []models.RecipeRevision
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &revisions))
+	if assert.Len(t, revisions, 4) {
+		assert.Equal(t, 4, revisions[0].IntRevision)
+		assert.Equal(t, "Ингредиент добавлен", revisions[0].StrMessage)
+		assert.Nil(t, revisions[0].Snapshot)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.GetRecipeRevisionHandle, UserJWT, "/my-recipe/"+id+"/revisions/3",
+		map[string]string{"recipe_id": id, "revision": "3"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var third models.RecipeRevision
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &third))
+	if assert.NotNil(t, third.Snapshot) {
+		assert.Equal(t, "Вторая версия", third.Snapshot.Name)
+		assert.Len(t, third.Snapshot.Stages, 1)
+		assert.Len(t, third.Snapshot.Ingredients, 0)
+	}
+
+	// Разница между первой и последней версией
+	req := httptest.NewRequest(http.MethodGet, "/my-recipe/"+id+"/revisions/diff?from=1", nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+	rec = httptest.NewRecorder()
+	c := TestE.NewContext(req, rec)
+	c.SetParamNames("recipe_id")
+	c.SetParamValues(id)
+	assert.NoError(t, TestJwtMiddleware(TestServer.DiffRecipeRevisionsHandle)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var diff RecipeDiff
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, 1, diff.From)
+	assert.Equal(t, 4, diff.To)
+	if assert.Len(t, diff.Fields, 2) {
+		assert.Equal(t, "name", diff.Fields[0].Field)
+		assert.Equal(t, "Первая версия", diff.Fields[0].Old)
+		assert.Equal(t, "Вторая версия", diff.Fields[0].New)
+		assert.Equal(t, "servings", diff.Fields[1].Field)
+	}
+	if assert.Len(t, diff.Stages, 1) {
+		assert.Equal(t, DiffAdded, diff.Stages[0].Change)
+		assert.Equal(t, "Сварить", diff.Stages[0].NewDescription)
+	}
+	if assert.Len(t, diff.Ingredients, 1) {
+		assert.Equal(t, DiffAdded, diff.Ingredients[0].Change)
+		assert.Equal(t, ingredient.ID, diff.Ingredients[0].IngredientId)
+	}
+
+	// Чужой рецепт восстановить нельзя
+	rec = editRequestForTest(t, TestServer.RestoreRecipeRevisionHandle, UserJWT2, "/my-recipe/"+id+"/revisions/1/restore",
+		map[string]string{"recipe_id": id, "revision": "1"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = editRequestForTest(t, TestServer.RestoreRecipeRevisionHandle, UserJWT, "/my-recipe/"+id+"/revisions/10/restore",
+		map[string]string{"recipe_id": id, "revision": "10"}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Возвращаемся к первой версии
+	rec = editRequestForTest(t, TestServer.RestoreRecipeRevisionHandle, UserJWT, "/my-recipe/"+id+"/revisions/1/restore",
+		map[string]string{"recipe_id": id, "revision": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	respJson := RevisionResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &respJson))
+	assert.Equal(t, 5, respJson.Revision)
+
+	restored, err := TestServer.GetRecipeById(int(recipe.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, "Первая версия", restored.StrRecipeName)
+	assert.Equal(t, 1, restored.IntServings)
+	assert.Len(t, restored.RecipeStages, 0)
+	assert.Len(t, restored.RecipeIngredients, 0)
+	assert.Equal(t, 0.0, restored.TotalNutrition.FloatCalories)
+
+	// И обратно к версии с этапом и ингредиентом
+	rec = editRequestForTest(t, TestServer.RestoreRecipeRevisionHandle, UserJWT, "/my-recipe/"+id+"/revisions/4/restore",
+		map[string]string{"recipe_id": id, "revision": "4"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	restored, err = TestServer.GetRecipeById(int(recipe.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, "Вторая версия", restored.StrRecipeName)
+	if assert.Len(t, restored.RecipeStages, 1) {
+		assert.Equal(t, "Сварить", restored.RecipeStages[0].StrStageDesc)
+	}
+	if assert.Len(t, restored.RecipeIngredients, 1) {
+		assert.Equal(t, 100, restored.RecipeIngredients[0].IntGrams)
+	}
+}
+
+func TestRestoreRevisionStagePhotos(t *testing.T) {
+	name := "revision_stage_photo.png"
+	data := pngColorForTest(color.RGBA{R: 4, G: 5, B: 6, A: 255})
+	err := TestServer.Storage.Put(name, bytes.NewReader(data), int64(len(data)), "image/png")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	recipe := models.Recipe{StrRecipeName: "Рецепт с фото этапа", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+	stage := models.Stage{StrStageDesc: "Нарезать", IntRecipeId: recipe.ID}
+	assert.NoError(t, TestServer.DB.Create(&stage).Error)
+	photo := models.Photo{StrImage: name, IntImageSize: int64(len(data)), StrCaption: "Нарезка", IntStageId: stage.ID}
+	assert.NoError(t, TestServer.DB.Create(&photo).Error)
+	TestServer.ChargeStorage(1, photo.IntImageSize)
+
+	id := fmt.Sprint(recipe.ID)
+	revision, err := TestServer.SaveRecipeRevision(recipe.ID, 1, "Этап с фото")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Удаляем этап вместе с фото
+	rec := editRequestForTest(t, TestServer.DeleteStageHandle, UserJWT, "/recipe/"+id+"/stage/"+fmt.Sprint(stage.ID)+"/delete",
+		map[string]string{"recipe_id": id, "stage_id": fmt.Sprint(stage.ID)}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(1), orphanRecordsForTest(name))
+	usedBefore := profileForTest(t, UserJWT).Storage.Used
+
+	// Восстановление возвращает этап вместе с фото
+	rec = editRequestForTest(t, TestServer.RestoreRecipeRevisionHandle, UserJWT, "/my-recipe/"+id+"/revisions/"+fmt.Sprint(revision.IntRevision)+"/restore",
+		map[string]string{"recipe_id": id, "revision": fmt.Sprint(revision.IntRevision)}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	restored, err := TestServer.GetRecipeById(int(recipe.ID))
+	if assert.NoError(t, err) && assert.Len(t, restored.RecipeStages, 1) && assert.Len(t, restored.RecipeStages[0].StagePhotos, 1) {
+		assert.Equal(t, name, restored.RecipeStages[0].StagePhotos[0].StrImage)
+		assert.Equal(t, "Нарезка", restored.RecipeStages[0].StagePhotos[0].StrCaption)
+	}
+	assert.Equal(t, int64(0), orphanRecordsForTest(name))
+	assert.Equal(t, usedBefore+photo.IntImageSize, profileForTest(t, UserJWT).Storage.Used)
+
+	// Версия до восстановления сохранена, поэтому его можно отменить
+	var before models.RecipeRevision
+	assert.NoError(t, TestServer.DB.Where("int_recipe_id = ? AND int_revision = ?", recipe.ID, revision.IntRevision+1).First(&before).Error)
+	if assert.NotNil(t, before.Snapshot) {
+		assert.Len(t, before.Snapshot.Stages, 0)
+	}
+}
+
+func TestDiffRecipeSnapshotsStages(t *testing.T) {
+	from := &models.RecipeSnapshot{Stages: []models.StageSnapshot{{Id: 1, Description: "a"}, {Id: 2, Description: "b"}, {Id: 3, Description: "c"}}}
+	to := &models.RecipeSnapshot{Stages: []models.StageSnapshot{{Id: 2, Description: "b"}, {Id: 1, Description: "a!"}, {Id: 4, Description: "d"}}}
+
+	diff := DiffRecipeSnapshots(from, to)
+	if assert.Len(t, diff.Stages, 4) {
+		assert.Equal(t, DiffMoved, diff.Stages[0].Change)
+		assert.Equal(t, uint(2), diff.Stages[0].Id)
+		assert.Equal(t, DiffChanged, diff.Stages[1].Change)
+		assert.Equal(t, "a!", diff.Stages[1].NewDescription)
+		assert.Equal(t, DiffAdded, diff.Stages[2].Change)
+		assert.Equal(t, DiffRemoved, diff.Stages[3].Change)
+		assert.Equal(t, uint(3), diff.Stages[3].Id)
+	}
+}
+
+func TestForkRecipe(t *testing.T) {
+	source, err := TestServer.GetRecipeById(1)
+	assert.NoError(t, err)
+	usedBefore := profileForTest(t, UserJWT2).Storage.Used
+
+	rec := recipeRequestForTest(t, TestServer.ForkRecipeHandle, UserJWT2, "/recipe/1/fork", map[string]string{"id": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	respJson := RecipeResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &respJson))
+	fork, err := TestServer.GetRecipeById(int(respJson.Id))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Копия - черновик нового автора со ссылкой на исходный рецепт
+	assert.NotEqual(t, source.IntUserId, fork.IntUserId)
+	assert.Equal(t, RecipeStatusDraft, fork.StrRecipeStatus)
+	if assert.NotNil(t, fork.IntForkedFromId) && assert.NotNil(t, fork.IntForkedFromUserId) {
+		assert.Equal(t, source.ID, *fork.IntForkedFromId)
+		assert.Equal(t, source.IntUserId, *fork.IntForkedFromUserId)
+	}
+	assert.Equal(t, source.StrRecipeName, fork.StrRecipeName)
+	assert.Equal(t, source.StrRecipeImage, fork.StrRecipeImage)
+	assert.Equal(t, len(source.RecipeFilters), len(fork.RecipeFilters))
+	assert.Equal(t, len(source.RecipeIngredients), len(fork.RecipeIngredients))
+	if assert.Equal(t, len(source.RecipeStages), len(fork.RecipeStages)) {
+		for i, stage := range source.RecipeStages {
+			assert.NotEqual(t, stage.ID, fork.RecipeStages[i].ID)
+			assert.Equal(t, stage.StrStageDesc, fork.RecipeStages[i].StrStageDesc)
+			if assert.Equal(t, len(stage.StagePhotos), len(fork.RecipeStages[i].StagePhotos)) {
+				for j, photo := range stage.StagePhotos {
+					assert.Equal(t, photo.StrImage, fork.RecipeStages[i].StagePhotos[j].StrImage)
+				}
+			}
+		}
+	}
+	assert.Equal(t, source.TotalNutrition, fork.TotalNutrition)
+
+	// Общие изображения не занимают место в квоте
+	assert.Equal(t, usedBefore, profileForTest(t, UserJWT2).Storage.Used)
+
+	updated, _ := TestServer.GetRecipeById(1)
+	assert.Equal(t, source.IntForkCount+1, updated.IntForkCount)
+
+	forksForTest := func() []models.Recipe {
+		rec := recipeRequestForTest(t, TestServer.GetRecipeForksHandle, "", "/recipe/1/forks", map[string]string{"id": "1"}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var forks []models.Recipe
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &forks))
+		return forks
+	}
+
+	// Черновик в списке копий не показывается
+	assert.Len(t, forksForTest(), 0)
+	TestServer.DB.Model(&models.Recipe{}).Where("id = ?", fork.ID).
+		Updates(map[string]interface{}{"str_recipe_status": RecipeStatusPublished, "bool_recipe_visibility": true})
+	forks := forksForTest()
+	if assert.Len(t, forks, 1) {
+		assert.Equal(t, fork.ID, forks[0].ID)
+	}
+
+	// После удаления копии счётчик уменьшается, а общие файлы остаются
+	id := fmt.Sprint(fork.ID)
+	rec = recipeRequestForTest(t, TestServer.DeleteRecipeHandle, UserJWT2, "/my-recipe/delete/"+id, map[string]string{"id": id}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	updated, _ = TestServer.GetRecipeById(1)
+	assert.Equal(t, source.IntForkCount, updated.IntForkCount)
+	assert.Equal(t, usedBefore, profileForTest(t, UserJWT2).Storage.Used)
+	assert.FileExists(t, path.Join(TestServer.UploadsPath, source.StrRecipeImage))
+}
+
+func TestForkHiddenRecipe(t *testing.T) {
+	TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("bool_recipe_visibility", false)
+	defer TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("bool_recipe_visibility", true)
+
+	rec := recipeRequestForTest(t, TestServer.ForkRecipeHandle, UserJWT2, "/recipe/1/fork", map[string]string{"id": "1"}, nil)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// Автор может скопировать свой скрытый рецепт
+	rec = recipeRequestForTest(t, TestServer.ForkRecipeHandle, UserJWT, "/recipe/1/fork", map[string]string{"id": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	respJson := RecipeResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &respJson))
+	id := fmt.Sprint(respJson.Id)
+	rec = recipeRequestForTest(t, TestServer.DeleteRecipeHandle, UserJWT, "/my-recipe/delete/"+id, map[string]string{"id": id}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRemoveIngredient(t *testing.T) {
+	oats := models.Ingredient{StrIngredientName: "Овсянка для удаления", IntCalories: 100}
+	assert.NoError(t, TestServer.DB.Create(&oats).Error)
+	defer TestServer.DB.Unscoped().Delete(&oats)
+
+	other := models.Recipe{StrRecipeName: "Чужая овсянка", IntServings: 1, IntUserId: 2}
+	recipe := models.Recipe{StrRecipeName: "Своя овсянка", IntServings: 1, IntUserId: 1}
+	for _, r := range []*models.Recipe{&other, &recipe} {
+		r.RecipeIngredients = []models.RecipeIngredient{{IntIngredientId: oats.ID, FloatQuantity: 100, StrUnit: UnitGram, IntGrams: 100}}
+		assert.NoError(t, TestServer.DB.Create(r).Error)
+		assert.NoError(t, TestServer.UpdateRecipeNutrition(r.ID))
+		defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", r.ID).Delete(&models.RecipeIngredient{})
+		defer TestServer.DB.Delete(r)
+	}
+
+	id := fmt.Sprint(recipe.ID)
+	rec := editRequestForTest(t, TestServer.RemoveIngredientHandle, UserJWT, "/my-recipe/"+id+"/ingredient/delete",
+		map[string]string{"recipe_id": id}, map[string]interface{}{"ingredient_id": oats.ID})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Удаляется ингредиент только этого рецепта
+	var count int64
+	TestServer.DB.Model(&models.RecipeIngredient{}).Where("int_recipe_id = ?", recipe.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	TestServer.DB.Model(&models.RecipeIngredient{}).Where("int_recipe_id = ?", other.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	var updated models.Recipe
+	assert.NoError(t, TestServer.DB.First(&updated, recipe.ID).Error)
+	assert.Equal(t, 0.0, updated.TotalNutrition.FloatCalories)
+	var untouched models.Recipe
+	assert.NoError(t, TestServer.DB.First(&untouched, other.ID).Error)
+	assert.Equal(t, 100.0, untouched.TotalNutrition.FloatCalories)
+}
+
+func TestBackfillRecipesNutrition(t *testing.T) {
+	buckwheat := models.Ingredient{StrIngredientName: "Гречка для пересчёта", IntCalories: 100}
+	assert.NoError(t, TestServer.DB.Create(&buckwheat).Error)
+	defer TestServer.DB.Unscoped().Delete(&buckwheat)
+
+	old := models.Recipe{StrRecipeName: "Старая каша", IntServings: 2, IntUserId: 1}
+	counted := models.Recipe{StrRecipeName: "Посчитанная каша", IntServings: 2, IntUserId: 1,
+		TotalNutrition: models.Nutrition{FloatCalories: 1}}
+	for _, r := range []*models.Recipe{&old, &counted} {
+		r.RecipeIngredients = []models.RecipeIngredient{{IntIngredientId: buckwheat.ID, FloatQuantity: 200, StrUnit: UnitGram, IntGrams: 200}}
+		assert.NoError(t, TestServer.DB.Create(r).Error)
+		defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", r.ID).Delete(&models.RecipeIngredient{})
+		defer TestServer.DB.Delete(r)
+	}
+
+	assert.NoError(t, TestServer.BackfillRecipesNutrition())
+
+	// Пересчитывается только рецепт без пищевой ценности
+	var recipe models.Recipe
+	assert.NoError(t, TestServer.DB.First(&recipe, old.ID).Error)
+	assert.Equal(t, 200.0, recipe.TotalNutrition.FloatCalories)
+	assert.Equal(t, 100.0, recipe.ServingNutrition.FloatCalories)
+
+	recipe = models.Recipe{}
+	assert.NoError(t, TestServer.DB.First(&recipe, counted.ID).Error)
+	assert.Equal(t, 1.0, recipe.TotalNutrition.FloatCalories)
+}
+
+func TestMigrateFavoritesToCollections(t *testing.T) {
+	assert.NoError(t, TestServer.MigrateFavoritesToCollections())
+	// Повторный запуск ничего не меняет
+	assert.NoError(t, TestServer.MigrateFavoritesToCollections())
+
+	var collections []models.Collection
+	assert.NoError(t, TestServer.DB.Preload("CollectionRecipes").Find(&collections, "int_user_id = ? AND bool_collection_default = ?", 1, true).Error)
+	if assert.Len(t, collections, 1) {
+		assert.Equal(t, DefaultCollectionName, collections[0].StrCollectionName)
+		if assert.Len(t, collections[0].CollectionRecipes, 1) {
+			assert.Equal(t, uint(1), collections[0].CollectionRecipes[0].IntRecipeId)
+		}
+
+		// Коллекцию по умолчанию удалить нельзя
+		id := fmt.Sprint(collections[0].ID)
+		rec := recipeRequestForTest(t, TestServer.DeleteCollectionHandle, UserJWT, "/my-collection/"+id+"/delete", map[string]string{"id": id}, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	rec := recipeRequestForTest(t, TestServer.CreateCollectionHandle, UserJWT2, "/my-collection/add", nil,
+		map[string]interface{}{"name": "Завтраки"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	first := CollectionResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &first))
+
+	rec = recipeRequestForTest(t, TestServer.CreateCollectionHandle, UserJWT2, "/my-collection/add", nil,
+		map[string]interface{}{"name": ""})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.CreateCollectionHandle, UserJWT2, "/my-collection/add", nil,
+		map[string]interface{}{"name": "Ужины", "public": true})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	second := CollectionResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &second))
+
+	id := fmt.Sprint(second.Id)
+
+	// Добавление рецепта идемпотентно
+	rec = recipeRequestForTest(t, TestServer.AddRecipeToCollectionHandle, UserJWT2, "/my-collection/"+id+"/recipe/1",
+		map[string]string{"id": id, "recipe_id": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Рецепт добавлен в коллекцию")
+	rec = recipeRequestForTest(t, TestServer.AddRecipeToCollectionHandle, UserJWT2, "/my-collection/"+id+"/recipe/1",
+		map[string]string{"id": id, "recipe_id": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Рецепт уже в коллекции")
+
+	// Чужую коллекцию менять нельзя
+	rec = recipeRequestForTest(t, TestServer.AddRecipeToCollectionHandle, UserJWT, "/my-collection/"+id+"/recipe/1",
+		map[string]string{"id": id, "recipe_id": "1"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Открытую коллекцию видят все, но только с общедоступными рецептами
+	rec = recipeRequestForTest(t, TestServer.GetCollectionHandle, "", "/collection/"+id, map[string]string{"id": id}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var collection models.Collection
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &collection))
+	assert.Len(t, collection.CollectionRecipes, 1)
+
+	TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("bool_recipe_visibility", false)
+	rec = recipeRequestForTest(t, TestServer.GetCollectionHandle, "", "/collection/"+id, map[string]string{"id": id}, nil)
+	collection = models.Collection{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &collection))
+	assert.Len(t, collection.CollectionRecipes, 0)
+	TestServer.DB.Model(&models.Recipe{}).Where("id = ?", 1).UpdateColumn("bool_recipe_visibility", true)
+
+	// Закрытую коллекцию видит только владелец
+	private := fmt.Sprint(first.Id)
+	rec = recipeRequestForTest(t, TestServer.GetCollectionHandle, "", "/collection/"+private, map[string]string{"id": private}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.GetMyCollectionHandle, UserJWT2, "/my-collection/"+private, map[string]string{"id": private}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.GetMyCollectionHandle, UserJWT, "/my-collection/"+private, map[string]string{"id": private}, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Переименование и открытие доступа
+	rec = recipeRequestForTest(t, TestServer.UpdateCollectionHandle, UserJWT2, "/my-collection/"+private+"/update",
+		map[string]string{"id": private}, map[string]interface{}{"name": "Завтраки и обеды", "public": true})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.GetCollectionHandle, "", "/collection/"+private, map[string]string{"id": private}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Завтраки и обеды")
+
+	// Порядок коллекций
+	rec = recipeRequestForTest(t, TestServer.ReorderCollectionsHandle, UserJWT2, "/my-collection/reorder", nil,
+		map[string]interface{}{"collections": []uint{second.Id}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.ReorderCollectionsHandle, UserJWT2, "/my-collection/reorder", nil,
+		map[string]interface{}{"collections": []uint{second.Id, first.Id}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetMyCollectionsHandle, UserJWT2, "/my-collection/all", nil, nil)
+	var collections []models.Collection
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &collections))
+	if assert.Len(t, collections, 2) {
+		assert.Equal(t, second.Id, collections[0].ID)
+		assert.Equal(t, first.Id, collections[1].ID)
+	}
+
+	// Удаление рецепта из коллекции и самой коллекции
+	rec = recipeRequestForTest(t, TestServer.RemoveRecipeFromCollectionHandle, UserJWT2, "/my-collection/"+id+"/recipe/1",
+		map[string]string{"id": id, "recipe_id": "1"}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.RemoveRecipeFromCollectionHandle, UserJWT2, "/my-collection/"+id+"/recipe/1",
+		map[string]string{"id": id, "recipe_id": "1"}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	for _, collectionID := range []string{id, private} {
+		rec = recipeRequestForTest(t, TestServer.DeleteCollectionHandle, UserJWT2, "/my-collection/"+collectionID+"/delete",
+			map[string]string{"id": collectionID}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func TestReorderCollectionRecipes(t *testing.T) {
+	collection := models.Collection{StrCollectionName: "Порядок", IntUserId: 1}
+	assert.NoError(t, TestServer.DB.Create(&collection).Error)
+	id := fmt.Sprint(collection.ID)
+
+	recipes := []models.Recipe{
+		{StrRecipeName: "Первый", IntUserId: 1, StrRecipeStatus: RecipeStatusDraft},
+		{StrRecipeName: "Второй", IntUserId: 1, StrRecipeStatus: RecipeStatusDraft},
+	}
+	assert.NoError(t, TestServer.DB.Create(&recipes).Error)
+	defer TestServer.DB.Delete(&recipes)
+
+	for _, recipe := range recipes {
+		recipeID := fmt.Sprint(recipe.ID)
+		rec := recipeRequestForTest(t, TestServer.AddRecipeToCollectionHandle, UserJWT, "/my-collection/"+id+"/recipe/"+recipeID,
+			map[string]string{"id": id, "recipe_id": recipeID}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := recipeRequestForTest(t, TestServer.ReorderCollectionRecipesHandle, UserJWT, "/my-collection/"+id+"/recipe/reorder",
+		map[string]string{"id": id}, map[string]interface{}{"recipes": []uint{recipes[1].ID, recipes[0].ID}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	updated, err := TestServer.GetCollectionById(int(collection.ID))
+	assert.NoError(t, err)
+	if assert.Len(t, updated.CollectionRecipes, 2) {
+		assert.Equal(t, recipes[1].ID, updated.CollectionRecipes[0].IntRecipeId)
+		assert.Equal(t, recipes[0].ID, updated.CollectionRecipes[1].IntRecipeId)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.DeleteCollectionHandle, UserJWT, "/my-collection/"+id+"/delete", map[string]string{"id": id}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestCollectionWithRemovedRecipes(t *testing.T) {
+	collection := models.Collection{StrCollectionName: "Чужие рецепты", IntUserId: 2}
+	assert.NoError(t, TestServer.DB.Create(&collection).Error)
+	id := fmt.Sprint(collection.ID)
+	params := map[string]string{"id": id}
+
+	recipes := []models.Recipe{
+		{StrRecipeName: "Остаётся", IntUserId: 1, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished},
+		{StrRecipeName: "Удаляется", IntUserId: 1, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished},
+		{StrRecipeName: "Скрывается", IntUserId: 1, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished},
+		{StrRecipeName: "В черновики", IntUserId: 1, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished},
+	}
+	assert.NoError(t, TestServer.DB.Create(&recipes).Error)
+	defer TestServer.DB.Unscoped().Delete(&recipes)
+
+	for _, recipe := range recipes {
+		recipeID := fmt.Sprint(recipe.ID)
+		rec := recipeRequestForTest(t, TestServer.AddRecipeToCollectionHandle, UserJWT2, "/my-collection/"+id+"/recipe/"+recipeID,
+			map[string]string{"id": id, "recipe_id": recipeID}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// Автор удаляет, скрывает и возвращает в черновики свои рецепты
+	assert.NoError(t, TestServer.DB.Delete(&recipes[1]).Error)
+	TestServer.DB.Model(&recipes[2]).UpdateColumn("bool_recipe_visibility", false)
+	TestServer.DB.Model(&recipes[3]).UpdateColumn("str_recipe_status", RecipeStatusDraft)
+
+	rec := recipeRequestForTest(t, TestServer.GetMyCollectionHandle, UserJWT2, "/my-collection/"+id, params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got models.Collection
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	if assert.Len(t, got.CollectionRecipes, 1) {
+		assert.Equal(t, recipes[0].ID, got.CollectionRecipes[0].IntRecipeId)
+	}
+
+	// Порядок можно менять по тем рецептам, которые пользователь видит
+	rec = recipeRequestForTest(t, TestServer.ReorderCollectionRecipesHandle, UserJWT2, "/my-collection/"+id+"/recipe/reorder",
+		params, map[string]interface{}{"recipes": []uint{recipes[0].ID}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.ReorderCollectionRecipesHandle, UserJWT2, "/my-collection/"+id+"/recipe/reorder",
+		params, map[string]interface{}{"recipes": []uint{recipes[0].ID, recipes[2].ID}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Скрытые рецепты переносятся после видимых и не занимают их места
+	var orders []models.CollectionRecipe
+	assert.NoError(t, TestServer.DB.Where("int_collection_id = ? AND int_recipe_id IN ?", collection.ID,
+		[]uint{recipes[0].ID, recipes[2].ID, recipes[3].ID}).Order("int_recipe_order").Find(&orders).Error)
+	if assert.Len(t, orders, 3) {
+		for i, recipe := range []models.Recipe{recipes[0], recipes[2], recipes[3]} {
+			assert.Equal(t, recipe.ID, orders[i].IntRecipeId)
+			assert.Equal(t, i, orders[i].IntRecipeOrder)
+		}
+	}
+
+	// Несуществующий и недоступный рецепты добавить нельзя
+	for _, recipeID := range []string{"100000", fmt.Sprint(recipes[2].ID)} {
+		rec = recipeRequestForTest(t, TestServer.AddRecipeToCollectionHandle, UserJWT2, "/my-collection/"+id+"/recipe/"+recipeID,
+			map[string]string{"id": id, "recipe_id": recipeID}, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.DeleteCollectionHandle, UserJWT2, "/my-collection/"+id+"/delete", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestFavoritesAPI(t *testing.T) {
+	recipe := models.Recipe{StrRecipeName: "Избранный рецепт", IntServings: 1, IntUserId: 1, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+
+	id := fmt.Sprint(recipe.ID)
+	params := map[string]string{"id": id}
+
+	// Повторное добавление не создаёт дубликатов
+	for i := 0; i < 2; i++ {
+		rec := recipeRequestForTest(t, TestServer.AddRecipeToFavoritesHandle, UserJWT2, "/recipe/favorite/"+id, params, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := recipeRequestForTest(t, TestServer.AddRecipeToFavoritesHandle, UserJWT, "/recipe/favorite/"+id, params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var count int64
+	TestServer.DB.Table("user_favorite_recipes").Where("recipe_id = ?", recipe.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// Пользователь видит, что рецепт у него в избранном
+	rec = recipeRequestForTest(t, TestServer.GetRecipeHandle, UserJWT2, "/recipe/"+id, params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got models.Recipe
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, int64(2), got.IntFavoriteCount)
+	assert.True(t, got.BoolFavorite)
+
+	// Гость видит только количество
+	rec = recipeRequestForTest(t, TestServer.GetRecipeHandle, "", "/recipe/"+id, params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	got = models.Recipe{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, int64(2), got.IntFavoriteCount)
+	assert.False(t, got.BoolFavorite)
+
+	// Список избранного по страницам
+	rec = recipeRequestForTest(t, TestServer.GetFavoritesHandle, UserJWT2, "/profile/favorites?page=1&limit=1", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var page RecipesPageResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, int64(1), page.Total)
+	assert.Equal(t, 1, page.Limit)
+	if assert.Len(t, page.Recipes, 1) {
+		assert.Equal(t, recipe.ID, page.Recipes[0].ID)
+		assert.True(t, page.Recipes[0].BoolFavorite)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.GetFavoritesHandle, UserJWT2, "/profile/favorites?page=0", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Скрытый рецепт пропадает из чужого избранного
+	TestServer.DB.Model(&recipe).Update("bool_recipe_visibility", false)
+	rec = recipeRequestForTest(t, TestServer.GetFavoritesHandle, UserJWT2, "/profile/favorites", nil, nil)
+	page = RecipesPageResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, int64(0), page.Total)
+
+	// Соавтор видит скрытый рецепт в избранном
+	collaborator := models.RecipeCollaborator{IntRecipeId: recipe.ID, IntUserId: 2, StrRole: RecipeRoleViewer, BoolAccepted: true, IntInvitedById: 1}
+	assert.NoError(t, TestServer.DB.Create(&collaborator).Error)
+	rec = recipeRequestForTest(t, TestServer.GetFavoritesHandle, UserJWT2, "/profile/favorites", nil, nil)
+	page = RecipesPageResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, int64(1), page.Total)
+	TestServer.DB.Unscoped().Delete(&collaborator)
+	TestServer.DB.Model(&recipe).Update("bool_recipe_visibility", true)
+
+	// Удаление из избранного
+	for _, token := range []string{UserJWT, UserJWT2} {
+		rec = recipeRequestForTest(t, TestServer.RemoveRecipeFromFavoritesHandle, token, "/recipe/favorite/"+id, params, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec = recipeRequestForTest(t, TestServer.RemoveRecipeFromFavoritesHandle, UserJWT2, "/recipe/favorite/"+id, params, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAddHiddenRecipeToFavorites(t *testing.T) {
+	recipe := models.Recipe{StrRecipeName: "Скрытый рецепт", IntServings: 1, IntUserId: 1, BoolRecipeVisibility: false, StrRecipeStatus: RecipeStatusPublished}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+
+	id := fmt.Sprint(recipe.ID)
+	rec := recipeRequestForTest(t, TestServer.AddRecipeToFavoritesHandle, UserJWT2, "/recipe/favorite/"+id, map[string]string{"id": id}, nil)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	var count int64
+	TestServer.DB.Table("user_favorite_recipes").Where("recipe_id = ?", recipe.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestShoppingList(t *testing.T) {
+	flour := models.Ingredient{StrIngredientName: "Мука для списка", FloatDensity: 0.5}
+	milk := models.Ingredient{StrIngredientName: "Молоко для списка", FloatDensity: 1}
+	egg := models.Ingredient{StrIngredientName: "Яйцо для списка", FloatPieceGrams: 50}
+	for _, ingredient := range []*models.Ingredient{&flour, &milk, &egg} {
+		assert.NoError(t, TestServer.DB.Create(ingredient).Error)
+		defer TestServer.DB.Unscoped().Delete(ingredient)
+	}
+
+	pancakes := models.Recipe{
+		StrRecipeName: "Блины", IntServings: 2, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft,
+		RecipeIngredients: []models.RecipeIngredient{
+			{IntIngredientId: flour.ID, FloatQuantity: 400, StrUnit: UnitGram, IntGrams: 400},
+			{IntIngredientId: milk.ID, FloatQuantity: 0.5, StrUnit: UnitLiter, IntGrams: 500},
+			{IntIngredientId: egg.ID, FloatQuantity: 2, StrUnit: UnitPiece, IntGrams: 100},
+		},
+	}
+	omelette := models.Recipe{
+		StrRecipeName: "Омлет", IntServings: 1, IntUserId: 2, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished,
+		RecipeIngredients: []models.RecipeIngredient{
+			{IntIngredientId: flour.ID, FloatQuantity: 2, StrUnit: UnitTablespoon, IntGrams: 15},
+			{IntIngredientId: milk.ID, FloatQuantity: 100, StrUnit: UnitMilliliter, IntGrams: 100},
+			{IntIngredientId: egg.ID, FloatQuantity: 3, StrUnit: UnitPiece, IntGrams: 150},
+		},
+	}
+	hidden := models.Recipe{StrRecipeName: "Чужой черновик", IntServings: 1, IntUserId: 2, StrRecipeStatus: RecipeStatusDraft}
+	for _, recipe := range []*models.Recipe{&pancakes, &omelette, &hidden} {
+		assert.NoError(t, TestServer.DB.Create(recipe).Error)
+		defer TestServer.DB.Delete(recipe)
+	}
+
+	// Чужой черновик в список не попадает
+	rec := recipeRequestForTest(t, TestServer.CreateShoppingListHandle, UserJWT, "/shopping-list/create", nil, map[string]interface{}{
+		"recipes": []map[string]interface{}{{"recipe_id": hidden.ID}},
+	})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.CreateShoppingListHandle, UserJWT, "/shopping-list/create", nil, map[string]interface{}{})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Блины на 4 порции и омлет на 1
+	rec = recipeRequestForTest(t, TestServer.CreateShoppingListHandle, UserJWT, "/shopping-list/create", nil, map[string]interface{}{
+		"name": "На выходные",
+		"recipes": []map[string]interface{}{
+			{"recipe_id": pancakes.ID, "servings": 4},
+			{"recipe_id": omelette.ID},
+		},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var created ShoppingListResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	id := fmt.Sprint(created.Id)
+	params := map[string]string{"id": id}
+
+	rec = recipeRequestForTest(t, TestServer.GetShoppingListHandle, UserJWT2, "/shopping-list/"+id, params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetShoppingListHandle, UserJWT, "/shopping-list/"+id, params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list models.ShoppingList
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Equal(t, "На выходные", list.StrListName)
+	assert.Len(t, list.ListRecipes, 2)
+	if assert.Len(t, list.ListItems, 3) {
+		// Молоко по объёму: 1 л + 100 мл
+		assert.Equal(t, milk.ID, list.ListItems[0].IntIngredientId)
+		assert.Equal(t, 1.1, list.ListItems[0].FloatQuantity)
+		assert.Equal(t, UnitLiter, list.ListItems[0].StrUnit)
+		// Мука в граммах и ложках складывается по весу
+		assert.Equal(t, 815.0, list.ListItems[1].FloatQuantity)
+		assert.Equal(t, UnitGram, list.ListItems[1].StrUnit)
+		// Яйца в штуках
+		assert.Equal(t, 7.0, list.ListItems[2].FloatQuantity)
+		assert.Equal(t, UnitPiece, list.ListItems[2].StrUnit)
+	}
+
+	// Отмечаем купленное
+	rec = recipeRequestForTest(t, TestServer.CheckShoppingListItemHandle, UserJWT, "/shopping-list/"+id+"/item/",
+		map[string]string{"id": id, "item_id": fmt.Sprint(list.ListItems[2].ID)}, map[string]interface{}{"checked": true})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.CheckShoppingListItemHandle, UserJWT, "/shopping-list/"+id+"/item/",
+		map[string]string{"id": id, "item_id": "100000"}, map[string]interface{}{"checked": true})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Выгрузка
+	rec = recipeRequestForTest(t, TestServer.ExportShoppingListHandle, UserJWT, "/shopping-list/"+id+"/export?format=markdown", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/markdown")
+	assert.Contains(t, rec.Body.String(), "# На выходные")
+	assert.Contains(t, rec.Body.String(), "- [ ] **Молоко для списка** — 1.1 l")
+	assert.Contains(t, rec.Body.String(), "- [x] **Яйцо для списка** — 7 pcs")
+
+	rec = recipeRequestForTest(t, TestServer.ExportShoppingListHandle, UserJWT, "/shopping-list/"+id+"/export", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "[ ] Мука для списка - 815 g")
+
+	rec = recipeRequestForTest(t, TestServer.ExportShoppingListHandle, UserJWT, "/shopping-list/"+id+"/export?format=pdf", params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Удаление
+	rec = recipeRequestForTest(t, TestServer.DeleteShoppingListHandle, UserJWT2, "/shopping-list/"+id+"/delete", params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.DeleteShoppingListHandle, UserJWT, "/shopping-list/"+id+"/delete", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetShoppingListHandle, UserJWT, "/shopping-list/"+id, params, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestMealPlan(t *testing.T) {
+	rice := models.Ingredient{StrIngredientName: "Рис для плана"}
+	assert.NoError(t, TestServer.DB.Create(&rice).Error)
+	defer TestServer.DB.Unscoped().Delete(&rice)
+
+	recipe := models.Recipe{
+		StrRecipeName: "Плов, по-домашнему", IntServings: 2, IntTime: 60, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft,
+		ServingNutrition: models.Nutrition{FloatCalories: 300, FloatProteins: 10, FloatFats: 5, FloatCarbohydrates: 50},
+		RecipeIngredients: []models.RecipeIngredient{
+			{IntIngredientId: rice.ID, FloatQuantity: 200, StrUnit: UnitGram, IntGrams: 200},
+		},
+	}
+	hidden := models.Recipe{StrRecipeName: "Чужой черновик", IntServings: 1, IntUserId: 2, StrRecipeStatus: RecipeStatusDraft}
+	for _, r := range []*models.Recipe{&recipe, &hidden} {
+		assert.NoError(t, TestServer.DB.Create(r).Error)
+		defer TestServer.DB.Delete(r)
+	}
+	defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.MealPlanEntry{})
+
+	monday := WeekStart(time.Now())
+	wednesday := monday.AddDate(0, 0, 2).Format(PlanDateLayout)
+
+	add := func(token string, body map[string]interface{}) *httptest.ResponseRecorder {
+		return recipeRequestForTest(t, TestServer.AddMealPlanEntryHandle, token, "/meal-plan/add", nil, body)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, add(UserJWT, map[string]interface{}{"recipe_id": recipe.ID, "date": "19.10.2026", "slot": MealSlotDinner}).Code)
+	assert.Equal(t, http.StatusBadRequest, add(UserJWT, map[string]interface{}{"recipe_id": recipe.ID, "date": wednesday, "slot": "brunch"}).Code)
+	assert.Equal(t, http.StatusNotFound, add(UserJWT, map[string]interface{}{"recipe_id": hidden.ID, "date": wednesday, "slot": MealSlotDinner}).Code)
+
+	rec := add(UserJWT, map[string]interface{}{"recipe_id": recipe.ID, "date": wednesday, "slot": MealSlotDinner, "servings": 2})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var dinner MealPlanEntryResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &dinner))
+
+	rec = add(UserJWT, map[string]interface{}{"recipe_id": recipe.ID, "date": wednesday, "slot": MealSlotBreakfast})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Изменять можно только свой план
+	params := map[string]string{"id": fmt.Sprint(dinner.Id)}
+	rec = recipeRequestForTest(t, TestServer.UpdateMealPlanEntryHandle, UserJWT2, "/meal-plan/update", params, map[string]interface{}{"servings": 4})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.UpdateMealPlanEntryHandle, UserJWT, "/meal-plan/update", params, map[string]interface{}{"servings": 4})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// План на неделю по любому её дню
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanWeekHandle, UserJWT, "/meal-plan/week?start="+wednesday, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var week MealPlanWeekResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &week))
+	assert.Equal(t, monday.Format(PlanDateLayout), week.Start)
+	assert.Len(t, week.Days, 7)
+	day := week.Days[2]
+	if assert.Len(t, day.Entries, 2) {
+		assert.Equal(t, MealSlotBreakfast, day.Entries[0].StrMealSlot)
+		assert.Equal(t, 2, day.Entries[0].IntServings)
+		assert.Equal(t, MealSlotDinner, day.Entries[1].StrMealSlot)
+		assert.Equal(t, 4, day.Entries[1].IntServings)
+	}
+	assert.Equal(t, 1800.0, day.Nutrition.FloatCalories)
+	assert.Equal(t, 300.0, day.Nutrition.FloatCarbohydrates)
+	assert.Equal(t, 1800.0, week.Nutrition.FloatCalories)
+	assert.Empty(t, week.Days[0].Entries)
+
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanWeekHandle, UserJWT2, "/meal-plan/week?start="+wednesday, nil, nil)
+	week = MealPlanWeekResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &week))
+	assert.Equal(t, 0.0, week.Nutrition.FloatCalories)
+
+	// Список покупок по плану: 6 порций по 100 г риса
+	rec = recipeRequestForTest(t, TestServer.CreateMealPlanShoppingListHandle, UserJWT, "/meal-plan/week/shopping-list?start="+wednesday, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var created ShoppingListResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	list, err := TestServer.GetShoppingListById(int(created.Id))
+	if assert.NoError(t, err) && assert.Len(t, list.ListItems, 1) {
+		assert.Equal(t, 600.0, list.ListItems[0].FloatQuantity)
+		assert.Equal(t, UnitGram, list.ListItems[0].StrUnit)
+	}
+	TestServer.DB.Select("ListItems", "ListRecipes").Delete(list)
+
+	rec = recipeRequestForTest(t, TestServer.CreateMealPlanShoppingListHandle, UserJWT2, "/meal-plan/week/shopping-list?start="+wednesday, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Календарь по секретной ссылке
+	rec = recipeRequestForTest(t, TestServer.CreateMealPlanCalendarKeyHandle, UserJWT, "/meal-plan/calendar", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var link CalendarLinkResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &link))
+	key := strings.TrimPrefix(link.URL, "/meal-plan-calendar/")
+
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanCalendarHandle, "", link.URL, map[string]string{"key": key}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/calendar")
+	body := rec.Body.String()
+	assert.Contains(t, body, "BEGIN:VCALENDAR\r\n")
+	assert.Contains(t, body, fmt.Sprintf("UID:meal-plan-%d@recipe-book\r\n", dinner.Id))
+	assert.Contains(t, body, "SUMMARY:Ужин: Плов\\, по-домашнему\r\n")
+	assert.Contains(t, body, "DTSTART:"+monday.AddDate(0, 0, 2).Format("20060102")+"T190000\r\n")
+	assert.Contains(t, body, "DTEND:"+monday.AddDate(0, 0, 2).Format("20060102")+"T200000\r\n")
+
+	// После отключения ссылка не работает
+	rec = recipeRequestForTest(t, TestServer.DeleteMealPlanCalendarKeyHandle, UserJWT, "/meal-plan/calendar", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanCalendarHandle, "", link.URL, map[string]string{"key": key}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Удаление
+	rec = recipeRequestForTest(t, TestServer.DeleteMealPlanEntryHandle, UserJWT2, "/meal-plan/delete", params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.DeleteMealPlanEntryHandle, UserJWT, "/meal-plan/delete", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMealPlanHiddenRecipe(t *testing.T) {
+	recipe := models.Recipe{StrRecipeName: "Публичный суп", IntServings: 1, IntUserId: 1, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+	defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.MealPlanEntry{})
+
+	monday := WeekStart(time.Now()).Format(PlanDateLayout)
+	rec := recipeRequestForTest(t, TestServer.AddMealPlanEntryHandle, UserJWT2, "/meal-plan/add", nil,
+		map[string]interface{}{"recipe_id": recipe.ID, "date": monday, "slot": MealSlotLunch})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.CreateMealPlanCalendarKeyHandle, UserJWT2, "/meal-plan/calendar", nil, nil)
+	var link CalendarLinkResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &link))
+	key := strings.TrimPrefix(link.URL, "/meal-plan-calendar/")
+	defer recipeRequestForTest(t, TestServer.DeleteMealPlanCalendarKeyHandle, UserJWT2, "/meal-plan/calendar", nil, nil)
+
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanCalendarHandle, "", link.URL, map[string]string{"key": key}, nil)
+	assert.Contains(t, rec.Body.String(), "Публичный суп")
+
+	// Автор скрыл рецепт, и он пропал из чужого плана и календаря
+	TestServer.DB.Model(&recipe).UpdateColumn("bool_recipe_visibility", false)
+
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanWeekHandle, UserJWT2, "/meal-plan/week?start="+monday, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var week MealPlanWeekResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &week))
+	assert.Empty(t, week.Days[0].Entries)
+	assert.NotContains(t, rec.Body.String(), "Публичный суп")
+
+	rec = recipeRequestForTest(t, TestServer.GetMealPlanCalendarHandle, "", link.URL, map[string]string{"key": key}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "Публичный суп")
+
+	// Автор по-прежнему видит скрытый рецепт в своём плане
+	assert.NoError(t, TestServer.DB.Create(&models.MealPlanEntry{IntUserId: 1, IntRecipeId: recipe.ID, StrPlanDate: monday, StrMealSlot: MealSlotLunch, IntServings: 1}).Error)
+	var owner models.User
+	assert.NoError(t, TestServer.DB.First(&owner, 1).Error)
+	entries, err := TestServer.GetMealPlanEntries(&owner, monday, monday)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestPantry(t *testing.T) {
+	flour := models.Ingredient{StrIngredientName: "Мука для кладовой"}
+	milk := models.Ingredient{StrIngredientName: "Молоко для кладовой"}
+	egg := models.Ingredient{StrIngredientName: "Яйцо для кладовой"}
+	for _, ingredient := range []*models.Ingredient{&flour, &milk, &egg} {
+		assert.NoError(t, TestServer.DB.Create(ingredient).Error)
+		defer TestServer.DB.Unscoped().Delete(ingredient)
+	}
+	defer TestServer.DB.Unscoped().Where("int_user_id IN ?", []uint{1, 2}).Delete(&models.PantryItem{})
+
+	pie := models.Recipe{
+		StrRecipeName: "Пирог", IntServings: 1, IntUserId: 2, BoolRecipeVisibility: true, StrRecipeStatus: RecipeStatusPublished,
+		RecipeIngredients: []models.RecipeIngredient{
+			{IntIngredientId: flour.ID, FloatQuantity: 300, StrUnit: UnitGram, IntGrams: 300},
+			{IntIngredientId: egg.ID, FloatQuantity: 2, StrUnit: UnitPiece, IntGrams: 100},
+		},
+	}
+	cocoa := models.Recipe{
+		StrRecipeName: "Какао", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft,
+		RecipeIngredients: []models.RecipeIngredient{
+			{IntIngredientId: milk.ID, FloatQuantity: 200, StrUnit: UnitMilliliter, IntGrams: 200},
+		},
+	}
+	hidden := models.Recipe{
+		StrRecipeName: "Чужие блины", IntServings: 1, IntUserId: 2, StrRecipeStatus: RecipeStatusDraft,
+		RecipeIngredients: []models.RecipeIngredient{
+			{IntIngredientId: flour.ID, FloatQuantity: 100, StrUnit: UnitGram, IntGrams: 100},
+		},
+	}
+	for _, recipe := range []*models.Recipe{&pie, &cocoa, &hidden} {
+		assert.NoError(t, TestServer.DB.Create(recipe).Error)
+		defer TestServer.DB.Delete(recipe)
+	}
+
+	add := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		return recipeRequestForTest(t, TestServer.AddPantryItemHandle, UserJWT, "/pantry/add", nil, body)
+	}
+
+	tomorrow := DaysFromToday(1)
+	assert.Equal(t, http.StatusBadRequest, add(map[string]interface{}{"ingredient_id": flour.ID, "quantity": 1, "unit": UnitKilogram, "expires": "завтра"}).Code)
+	assert.Equal(t, http.StatusBadRequest, add(map[string]interface{}{"ingredient_id": flour.ID, "quantity": 0, "unit": UnitKilogram}).Code)
+	assert.Equal(t, http.StatusBadRequest, add(map[string]interface{}{"ingredient_id": 100000, "quantity": 1, "unit": UnitKilogram}).Code)
+	assert.Equal(t, http.StatusBadRequest, add(map[string]interface{}{"ingredient_id": egg.ID, "quantity": 1, "unit": UnitPiece}).Code)
+
+	assert.Equal(t, http.StatusOK, add(map[string]interface{}{"ingredient_id": flour.ID, "quantity": 1, "unit": UnitKilogram}).Code)
+	assert.Equal(t, http.StatusOK, add(map[string]interface{}{"ingredient_id": milk.ID, "quantity": 1, "unit": UnitLiter, "expires": DaysFromToday(10)}).Code)
+	rec := add(map[string]interface{}{"ingredient_id": flour.ID, "quantity": 500, "unit": UnitGram, "expires": tomorrow})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var added PantryItemResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &added))
+
+	// Сначала продукты, которые испортятся раньше
+	rec = recipeRequestForTest(t, TestServer.GetPantryHandle, UserJWT, "/pantry/all", nil, nil)
+	var items []models.PantryItem
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &items))
+	if assert.Len(t, items, 3) {
+		assert.Equal(t, added.Id, items[0].ID)
+		assert.Equal(t, milk.ID, items[1].IntIngredientId)
+		assert.Equal(t, "", items[2].StrExpiresAt)
+		assert.Equal(t, 1000.0, items[2].FloatGrams)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.GetPantryHandle, UserJWT, "/pantry/all?expiring=3", nil, nil)
+	items = nil
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &items))
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, flour.StrIngredientName, items[0].Ingredient.StrIngredientName)
+	}
+
+	// Что приготовить из продуктов, которые скоро испортятся
+	rec = recipeRequestForTest(t, TestServer.UseItUpHandle, UserJWT, "/pantry/use-it-up", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var suggestions []UseItUpRecipe
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &suggestions))
+	if assert.Len(t, suggestions, 1) {
+		assert.Equal(t, pie.ID, suggestions[0].Recipe.ID)
+		assert.Equal(t, []string{flour.StrIngredientName}, suggestions[0].Expiring)
+		assert.Equal(t, 1, suggestions[0].Matched)
+		assert.Equal(t, 1, suggestions[0].Missing)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.UseItUpHandle, UserJWT, "/pantry/use-it-up?days=30", nil, nil)
+	suggestions = nil
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &suggestions))
+	if assert.Len(t, suggestions, 2) {
+		assert.Equal(t, cocoa.ID, suggestions[0].Recipe.ID)
+		assert.Equal(t, pie.ID, suggestions[1].Recipe.ID)
+	}
+
+	// Пирог на 2 порции: 600 г муки, сначала из пачки с ближайшим сроком
+	params := map[string]string{"recipe_id": fmt.Sprint(pie.ID)}
+	rec = recipeRequestForTest(t, TestServer.CookRecipeHandle, UserJWT, "/pantry/cook", params, map[string]interface{}{"servings": 2})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var cooked CookResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &cooked))
+	if assert.Len(t, cooked.Missing, 1) {
+		assert.Equal(t, egg.ID, cooked.Missing[0].IngredientId)
+		assert.Equal(t, 200.0, cooked.Missing[0].Grams)
+	}
+
+	var flourItems []models.PantryItem
+	TestServer.DB.Find(&flourItems, "int_user_id = ? AND int_ingredient_id = ?", 1, flour.ID)
+	if assert.Len(t, flourItems, 1) {
+		assert.Equal(t, 900.0, flourItems[0].FloatGrams)
+		assert.InDelta(t, 0.9, flourItems[0].FloatQuantity, 1e-9)
+		assert.Equal(t, UnitKilogram, flourItems[0].StrUnit)
+	}
+
+	params = map[string]string{"recipe_id": fmt.Sprint(hidden.ID)}
+	rec = recipeRequestForTest(t, TestServer.CookRecipeHandle, UserJWT, "/pantry/cook", params, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Изменение и удаление
+	params = map[string]string{"id": fmt.Sprint(flourItems[0].ID)}
+	rec = recipeRequestForTest(t, TestServer.UpdatePantryItemHandle, UserJWT2, "/pantry/update", params, map[string]interface{}{"quantity": 2})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.UpdatePantryItemHandle, UserJWT, "/pantry/update", params, map[string]interface{}{"quantity": 2, "expires": tomorrow})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var updated models.PantryItem
+	TestServer.DB.First(&updated, flourItems[0].ID)
+	assert.Equal(t, 2000.0, updated.FloatGrams)
+	assert.Equal(t, tomorrow, updated.StrExpiresAt)
+
+	rec = recipeRequestForTest(t, TestServer.DeletePantryItemHandle, UserJWT2, "/pantry/delete", params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.DeletePantryItemHandle, UserJWT, "/pantry/delete", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUseItUpReadableRecipes(t *testing.T) {
+	rice := models.Ingredient{StrIngredientName: "Рис для подбора"}
+	peas := models.Ingredient{StrIngredientName: "Горох для подбора"}
+	for _, ingredient := range []*models.Ingredient{&rice, &peas} {
+		assert.NoError(t, TestServer.DB.Create(ingredient).Error)
+		defer TestServer.DB.Unscoped().Delete(ingredient)
+	}
+	defer TestServer.DB.Unscoped().Where("int_user_id = ?", 2).Delete(&models.PantryItem{})
+
+	withRice := func(name string, visible bool, status string, extra ...uint) *models.Recipe {
+		recipe := &models.Recipe{StrRecipeName: name, IntServings: 1, IntUserId: 1, BoolRecipeVisibility: visible, StrRecipeStatus: status}
+		for _, ingredientID := range append([]uint{rice.ID}, extra...) {
+			recipe.RecipeIngredients = append(recipe.RecipeIngredients,
+				models.RecipeIngredient{IntIngredientId: ingredientID, FloatQuantity: 100, StrUnit: UnitGram, IntGrams: 100})
+		}
+		assert.NoError(t, TestServer.DB.Create(recipe).Error)
+		return recipe
+	}
+
+	archived := withRice("Архивный плов", true, RecipeStatusArchived)
+	shared := withRice("Общий черновик", false, RecipeStatusDraft, peas.ID)
+	draft := withRice("Чужой черновик с рисом", true, RecipeStatusDraft)
+	hidden := withRice("Скрытый плов", false, RecipeStatusPublished)
+	recipes := []*models.Recipe{archived, shared, draft, hidden}
+	for i := 0; i < MaxUseItUpRecipes; i++ {
+		recipes = append(recipes, withRice(fmt.Sprintf("Рисовая каша %d", i), true, RecipeStatusPublished))
+	}
+	for _, recipe := range recipes {
+		defer TestServer.DB.Delete(recipe)
+	}
+
+	collaborator := models.RecipeCollaborator{IntRecipeId: shared.ID, IntUserId: 2, StrRole: RecipeRoleViewer, BoolAccepted: true, IntInvitedById: 1}
+	assert.NoError(t, TestServer.DB.Create(&collaborator).Error)
+	defer TestServer.DB.Unscoped().Delete(&collaborator)
+
+	for _, ingredient := range []models.Ingredient{rice, peas} {
+		rec := recipeRequestForTest(t, TestServer.AddPantryItemHandle, UserJWT2, "/pantry/add", nil,
+			map[string]interface{}{"ingredient_id": ingredient.ID, "quantity": 1, "unit": UnitKilogram, "expires": DaysFromToday(1)})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := recipeRequestForTest(t, TestServer.UseItUpHandle, UserJWT2, "/pantry/use-it-up", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var suggestions []UseItUpRecipe
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &suggestions))
+	if assert.Len(t, suggestions, MaxUseItUpRecipes) {
+		// Рецепт соавтора, в котором оба продукта, - первый
+		assert.Equal(t, shared.ID, suggestions[0].Recipe.ID)
+		assert.Len(t, suggestions[0].Expiring, 2)
+		assert.Equal(t, archived.ID, suggestions[1].Recipe.ID)
+	}
+	for _, suggestion := range suggestions {
+		assert.NotEqual(t, draft.ID, suggestion.Recipe.ID)
+		assert.NotEqual(t, hidden.ID, suggestion.Recipe.ID)
+	}
+}
+
+func TestRecipeShares(t *testing.T) {
+	recipe := models.Recipe{
+		StrRecipeName: "Секретный рецепт", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft,
+		StrRecipeImage: "shared_cover.jpg",
+		RecipeComments: []models.Comment{{StrCommentDesc: "Вкусно", IntRate: 5, IntUserId: 2}},
+	}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+	defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.RecipeShare{})
+
+	params := map[string]string{"recipe_id": fmt.Sprint(recipe.ID)}
+
+	// Ссылки создаёт только автор
+	rec := recipeRequestForTest(t, TestServer.CreateRecipeShareHandle, UserJWT2, "/my-recipe/shares/add", params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.CreateRecipeShareHandle, UserJWT, "/my-recipe/shares/add", params, map[string]interface{}{"expires_in": -1})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.CreateRecipeShareHandle, UserJWT, "/my-recipe/shares/add", params, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var forever RecipeShareResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &forever))
+	assert.Len(t, forever.Token, ShareTokenLength)
+	assert.Equal(t, "/shared/"+forever.Token, forever.URL)
+	assert.Nil(t, forever.ExpiresAt)
+
+	rec = recipeRequestForTest(t, TestServer.CreateRecipeShareHandle, UserJWT, "/my-recipe/shares/add", params, map[string]interface{}{"expires_in": 24})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var daily RecipeShareResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &daily))
+	assert.NotEqual(t, forever.Token, daily.Token)
+	if assert.NotNil(t, daily.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), *daily.ExpiresAt, time.Minute)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.GetRecipeSharesHandle, UserJWT, "/my-recipe/shares", params, nil)
+	var shares []models.RecipeShare
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &shares))
+	assert.Len(t, shares, 2)
+
+	rec = recipeRequestForTest(t, TestServer.GetRecipeSharesHandle, UserJWT2, "/my-recipe/shares", params, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// По ссылке рецепт доступен без входа вместе с изображениями и комментариями
+	rec = recipeRequestForTest(t, TestServer.GetSharedRecipeHandle, "", forever.URL, map[string]string{"token": forever.Token}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var shared ScaledRecipeResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &shared))
+	assert.Equal(t, recipe.ID, shared.ID)
+	assert.Contains(t, shared.SignedURLs["shared_cover.jpg"], "signature=")
+
+	rec = recipeRequestForTest(t, TestServer.GetSharedRecipeCommentsHandle, "", forever.URL+"/comments", map[string]string{"token": forever.Token}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var comments []models.Comment
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &comments))
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, "Вкусно", comments[0].StrCommentDesc)
+	}
+
+	rec = recipeRequestForTest(t, TestServer.GetSharedRecipeHandle, "", "/shared/wrong", map[string]string{"token": "wrong"}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Просроченная ссылка не работает
+	TestServer.DB.Model(&models.RecipeShare{}).Where("id = ?", daily.Id).Update("time_expires_at", time.Now().Add(-time.Minute))
+	rec = recipeRequestForTest(t, TestServer.GetSharedRecipeHandle, "", daily.URL, map[string]string{"token": daily.Token}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Отозванная ссылка не работает
+	revoke := map[string]string{"recipe_id": fmt.Sprint(recipe.ID), "share_id": fmt.Sprint(forever.Id)}
+	rec = recipeRequestForTest(t, TestServer.RevokeRecipeShareHandle, UserJWT2, "/my-recipe/shares", revoke, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.RevokeRecipeShareHandle, UserJWT, "/my-recipe/shares", revoke, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.RevokeRecipeShareHandle, UserJWT, "/my-recipe/shares", revoke, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetSharedRecipeHandle, "", forever.URL, map[string]string{"token": forever.Token}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRecipeCollaborators(t *testing.T) {
+	recipe := models.Recipe{StrRecipeName: "Общий рецепт", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+	defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.RecipeCollaborator{})
+	defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.RecipeRevision{})
+
+	var invitee models.User
+	assert.NoError(t, TestServer.DB.First(&invitee, 2).Error)
+
+	id := fmt.Sprint(recipe.ID)
+	recipeParams := map[string]string{"recipe_id": id}
+	idParams := map[string]string{"id": id}
+	update := map[string]interface{}{"name": "Общий рецепт соавтора", "servings": 2}
+
+	// До приглашения чужой черновик недоступен
+	rec := recipeRequestForTest(t, TestServer.GetMyRecipeHandle, UserJWT2, "/my-recipe/"+id, idParams, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	invite := func(token string, body map[string]interface{}) *httptest.ResponseRecorder {
+		return recipeRequestForTest(t, TestServer.InviteCollaboratorHandle, token, "/my-recipe/collaborators/invite", recipeParams, body)
+	}
+	assert.Equal(t, http.StatusBadRequest, invite(UserJWT2, map[string]interface{}{"user_name": invitee.StrUserName, "role": RecipeRoleViewer}).Code)
+	assert.Equal(t, http.StatusBadRequest, invite(UserJWT, map[string]interface{}{"user_name": invitee.StrUserName, "role": "admin"}).Code)
+	assert.Equal(t, http.StatusNotFound, invite(UserJWT, map[string]interface{}{"user_name": "нет такого", "role": RecipeRoleViewer}).Code)
+
+	rec = invite(UserJWT, map[string]interface{}{"user_name": invitee.StrUserName, "role": RecipeRoleViewer})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var invited CollaboratorResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invited))
+	assert.Equal(t, http.StatusConflict, invite(UserJWT, map[string]interface{}{"user_name": invitee.StrUserName, "role": RecipeRoleEditor}).Code)
+
+	// Пока приглашение не принято, доступа нет
+	rec = recipeRequestForTest(t, TestServer.GetMyRecipeHandle, UserJWT2, "/my-recipe/"+id, idParams, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetInvitesHandle, UserJWT2, "/my-recipe/invites", nil, nil)
+	var invites []models.RecipeCollaborator
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invites))
+	if assert.Len(t, invites, 1) && assert.NotNil(t, invites[0].Recipe) {
+		assert.Equal(t, recipe.StrRecipeName, invites[0].Recipe.StrRecipeName)
+	}
+
+	inviteParams := map[string]string{"invite_id": fmt.Sprint(invited.Id)}
+	rec = recipeRequestForTest(t, TestServer.AcceptInviteHandle, UserJWT, "/my-recipe/invites/accept", inviteParams, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.AcceptInviteHandle, UserJWT2, "/my-recipe/invites/accept", inviteParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Читатель видит рецепт, но не может его менять
+	rec = recipeRequestForTest(t, TestServer.GetMyRecipeHandle, UserJWT2, "/my-recipe/"+id, idParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.UpdateRecipeHandle, UserJWT2, "/my-recipe/change/"+id, idParams, update)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.GetRecipeRevisionsHandle, UserJWT2, "/my-recipe/revisions", recipeParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetCollaboratorsHandle, UserJWT2, "/my-recipe/collaborators", recipeParams, nil)
+	var collaborators []models.RecipeCollaborator
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &collaborators))
+	if assert.Len(t, collaborators, 1) {
+		assert.Equal(t, invitee.ID, collaborators[0].IntUserId)
+		assert.True(t, collaborators[0].BoolAccepted)
+	}
+
+	// Роль меняет только автор
+	roleParams := map[string]string{"recipe_id": id, "user_id": fmt.Sprint(invitee.ID)}
+	rec = recipeRequestForTest(t, TestServer.ChangeCollaboratorRoleHandle, UserJWT2, "/my-recipe/collaborators/role", roleParams, map[string]interface{}{"role": RecipeRoleEditor})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.ChangeCollaboratorRoleHandle, UserJWT, "/my-recipe/collaborators/role", roleParams, map[string]interface{}{"role": RecipeRoleEditor})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Редактор может менять рецепт, но не удалять и не публиковать его
+	rec = recipeRequestWithHeadersForTest(t, TestServer.UpdateRecipeHandle, UserJWT2, "/my-recipe/change/"+id, idParams, update, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var updated models.Recipe
+	TestServer.DB.First(&updated, recipe.ID)
+	assert.Equal(t, "Общий рецепт соавтора", updated.StrRecipeName)
+	assert.Equal(t, uint(1), updated.IntUserId)
+
+	rec = recipeRequestForTest(t, TestServer.DeleteRecipeHandle, UserJWT2, "/my-recipe/delete/"+id, idParams, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = editRequestForTest(t, TestServer.PublishRecipeHandle, UserJWT2, "/my-recipe/publish/"+id, idParams, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetCollaborativeRecipesHandle, UserJWT2, "/my-recipe/collaborative", nil, nil)
+	var recipes []models.Recipe
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &recipes))
+	if assert.Len(t, recipes, 1) {
+		assert.Equal(t, recipe.ID, recipes[0].ID)
+	}
+
+	// Соавтор может уйти сам
+	rec = recipeRequestForTest(t, TestServer.RemoveCollaboratorHandle, UserJWT2, "/my-recipe/collaborators", roleParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.GetMyRecipeHandle, UserJWT2, "/my-recipe/"+id, idParams, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Приглашение можно отклонить
+	rec = invite(UserJWT, map[string]interface{}{"user_name": invitee.StrUserName, "role": RecipeRoleEditor})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invited))
+	inviteParams = map[string]string{"invite_id": fmt.Sprint(invited.Id)}
+	rec = recipeRequestForTest(t, TestServer.DeclineInviteHandle, UserJWT2, "/my-recipe/invites/decline", inviteParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestForTest(t, TestServer.RemoveCollaboratorHandle, UserJWT, "/my-recipe/collaborators", roleParams, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestMatchETag(t *testing.T) {
+	assert.True(t, MatchETag(`"recipe-1-2"`, `"recipe-1-2"`))
+	assert.True(t, MatchETag(`"recipe-1-1", W/"recipe-1-2"`, `"recipe-1-2"`))
+	assert.True(t, MatchETag("*", `"recipe-1-2"`))
+	assert.False(t, MatchETag(`"recipe-1-1"`, `"recipe-1-2"`))
+	assert.False(t, MatchETag(`"stage-1-2"`, `"recipe-1-2"`))
+}
+
+func TestRecipeVersionConflict(t *testing.T) {
+	recipe := models.Recipe{StrRecipeName: "Общий черновик", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+	defer TestServer.DB.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.Stage{})
+	assert.Equal(t, 1, recipe.IntVersion)
+
+	id := fmt.Sprint(recipe.ID)
+	idParams := map[string]string{"id": id}
+
+	// ETag приходит вместе с рецептом
+	rec := recipeRequestForTest(t, TestServer.GetMyRecipeHandle, UserJWT, "/my-recipe/"+id, idParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, WeakETag(RecipeETag(&recipe)), etag)
+	assert.Contains(t, rec.Header().Values(echo.HeaderVary), echo.HeaderAuthorization)
+
+	// Масштабированный рецепт - другое представление той же версии
+	scaled := recipeRequestForTest(t, TestServer.GetMyRecipeHandle, UserJWT, "/my-recipe/"+id+"?servings=3", idParams, nil)
+	assert.Equal(t, http.StatusOK, scaled.Code)
+	assert.True(t, strings.HasPrefix(scaled.Header().Get("ETag"), "W/"))
+
+	// Без If-Match изменение не принимается
+	rec = recipeRequestForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/"+id, idParams, map[string]interface{}{"name": "Первая вкладка"})
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+
+	rec = recipeRequestWithHeadersForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/"+id, idParams,
+		map[string]interface{}{"name": "Первая вкладка"}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	newETag := rec.Header().Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+
+	// Вторая вкладка сохраняет по старой версии и получает текущее состояние
+	rec = recipeRequestWithHeadersForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/"+id, idParams,
+		map[string]interface{}{"name": "Вторая вкладка"}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	var conflict struct {
+		ETag    string        `json:"etag"`
+		Current models.Recipe `json:"current"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+	assert.Equal(t, newETag, conflict.ETag)
+	assert.Equal(t, newETag, rec.Header().Get("ETag"))
+	assert.Equal(t, "Первая вкладка", conflict.Current.StrRecipeName)
+
+	var saved models.Recipe
+	TestServer.DB.First(&saved, recipe.ID)
+	assert.Equal(t, "Первая вкладка", saved.StrRecipeName)
+	assert.Equal(t, 2, saved.IntVersion)
+
+	// Добавление этапа тоже меняет версию рецепта
+	rec = editRequestForTest(t, TestServer.CreateStageHandle, UserJWT, "/my-recipe/"+id+"/stage/add",
+		map[string]string{"recipe_id": id}, map[string]interface{}{"description": "Замесить"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = recipeRequestWithHeadersForTest(t, TestServer.UpdateRecipeHandle, UserJWT, "/my-recipe/change/"+id, idParams,
+		map[string]interface{}{"name": "Вторая вкладка"}, map[string]string{"If-Match": newETag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	// Этапы, ингредиенты и состояние рецепта тоже меняются только по текущей версии
+	stageParams := map[string]string{"recipe_id": id}
+	rec = recipeRequestForTest(t, TestServer.CreateStageHandle, UserJWT, "/my-recipe/"+id+"/stage/add",
+		stageParams, map[string]interface{}{"description": "Раскатать"})
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+
+	TestServer.DB.First(&saved, recipe.ID)
+	etag = RecipeETag(&saved)
+	rec = recipeRequestWithHeadersForTest(t, TestServer.CreateStageHandle, UserJWT, "/my-recipe/"+id+"/stage/add",
+		stageParams, map[string]interface{}{"description": "Раскатать"}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	rec = recipeRequestWithHeadersForTest(t, TestServer.CreateStageHandle, UserJWT, "/my-recipe/"+id+"/stage/add",
+		stageParams, map[string]interface{}{"description": "Раскатать ещё раз"}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = recipeRequestWithHeadersForTest(t, TestServer.ChangeVisibilityRecipeHandle, UserJWT, "/my-recipe/visible/"+id,
+		idParams, map[string]interface{}{"visible": true}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	var stages int64
+	TestServer.DB.Model(&models.Stage{}).Where("int_recipe_id = ?", recipe.ID).Count(&stages)
+	assert.Equal(t, int64(2), stages)
+	saved = models.Recipe{}
+	TestServer.DB.First(&saved, recipe.ID)
+	assert.False(t, saved.BoolRecipeVisibility)
+
+	// Запись, изменённая между чтением и сохранением, не перезаписывается
+	assert.ErrorIs(t, UpdateVersioned(TestServer.DB, &models.Recipe{}, recipe.ID, 1, map[string]interface{}{"str_recipe_name": "Старая"}), ErrVersionConflict)
+}
+
+func TestStageVersionConflict(t *testing.T) {
+	recipe := models.Recipe{StrRecipeName: "Рецепт с этапом", IntServings: 1, IntUserId: 1, StrRecipeStatus: RecipeStatusDraft}
+	assert.NoError(t, TestServer.DB.Create(&recipe).Error)
+	defer TestServer.DB.Delete(&recipe)
+	stage := models.Stage{StrStageDesc: "Нарезать", IntRecipeId: recipe.ID}
+	assert.NoError(t, TestServer.DB.Create(&stage).Error)
+	defer TestServer.DB.Delete(&stage)
+
+	stageParams := map[string]string{"stage_id": fmt.Sprint(stage.ID)}
+
+	// Чужой этап не отдаётся
+	rec := recipeRequestForTest(t, TestServer.GetStageHandle, UserJWT2, "/my-recipe/stage", stageParams, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = recipeRequestForTest(t, TestServer.GetStageHandle, UserJWT, "/my-recipe/stage", stageParams, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, StageETag(&stage), etag)
+
+	rec = recipeRequestWithHeadersForTest(t, TestServer.UpdateStageHandle, UserJWT, "/my-recipe/stage/update", stageParams,
+		map[string]interface{}{"description": "Мелко нарезать"}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	rec = recipeRequestWithHeadersForTest(t, TestServer.UpdateStageHandle, UserJWT, "/my-recipe/stage/update", stageParams,
+		map[string]interface{}{"description": "Крупно нарезать"}, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	var conflict struct {
+		Current models.Stage `json:"current"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+	assert.Equal(t, "Мелко нарезать", conflict.Current.StrStageDesc)
+	assert.Equal(t, 2, conflict.Current.IntVersion)
+
+	// Изменение этапа меняет и версию рецепта
+	var saved models.Recipe
+	TestServer.DB.First(&saved, recipe.ID)
+	assert.Equal(t, 2, saved.IntVersion)
+}
+
+func TestDownloadFile(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"description": "a",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodPost, "/assets/a.hehe", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/assets/a.hehe")
+	c.SetParamNames("filename")
+	c.SetParamValues("a.hehe")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.DownloadFile)(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		respJson := DefaultResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Файл не найден", respJson.Message)
+	}
+}
+
+func TestDeleteRecipe(t *testing.T) {
+
+	reqMap := map[string]interface{}{
+		"name": "b",
+	}
+	reqJson, _ := json.Marshal(reqMap)
+
+	req := httptest.NewRequest(
+		http.MethodGet, "/my-recipe/delete/1", strings.NewReader(string(reqJson)),
+	)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", UserJWT))
+
+	rec := httptest.NewRecorder()
+
+	c := TestE.NewContext(req, rec)
+	c.SetPath("/my-recipe/delete/1")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	if assert.NoError(t, TestJwtMiddleware(TestServer.DeleteRecipeHandle)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		respJson := DefaultResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &respJson)
+		assert.Nil(t, err)
+		assert.Equal(t, "Рецепт удален", respJson.Message)
+
+		var recipe models.Recipe
+		TestServer.DB.First(&recipe, "id = ?", 1)
+		assert.NotEqual(t, "", recipe.DeletedAt)
+	}
+}
+
+func TestReferencedFilesSkipDeletedRecipe(t *testing.T) {
+	var recipe models.Recipe
+	TestServer.DB.Unscoped().First(&recipe, "id = ?", 1)
+
+	referenced, err := TestServer.ReferencedFiles()
+	if assert.Nil(t, err) {
+		assert.NotContains(t, referenced, recipe.StrRecipeImage)
+		for _, filename := range recipe.RecipeImageVariants {
+			assert.NotContains(t, referenced, filename)
+		}
 	}
 }
 
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Роли соавторов рецепта
+const (
+	RecipeRoleEditor = "editor" // может изменять рецепт
+	RecipeRoleViewer = "viewer" // может только смотреть
+)
+
+// Уровень доступа к рецепту
+type RecipeAccess int
+
+const (
+	RecipeAccessNone  RecipeAccess = iota // нет доступа
+	RecipeAccessView                      // просмотр, в том числе скрытого рецепта
+	RecipeAccessEdit                      // изменение рецепта, этапов и ингредиентов
+	RecipeAccessOwner                     // удаление, публикация, управление соавторами
+)
+
+// Уровни доступа для ролей соавторов
+var RecipeRoles = map[string]RecipeAccess{
+	RecipeRoleEditor: RecipeAccessEdit,
+	RecipeRoleViewer: RecipeAccessView,
+}
+
+type CollaboratorData struct {
+	UserName string `json:"user_name"` // только при приглашении
+	Role     string `json:"role"`
+}
+
+// Функция для получения уровня доступа пользователя к рецепту
+//
+// Автор рецепта - владелец, соавторы получают доступ
+// по своей роли только после принятия приглашения
+func (server *Server) GetRecipeAccess(userID uint, recipe *models.Recipe) RecipeAccess {
+	if userID == 0 {
+		return RecipeAccessNone
+	}
+	if userID == recipe.IntUserId {
+		return RecipeAccessOwner
+	}
+
+	var collaborator models.RecipeCollaborator
+	err := server.DB.First(&collaborator, "int_recipe_id = ? AND int_user_id = ? AND bool_accepted = ?", recipe.ID, userID, true).Error
+	if err != nil {
+		return RecipeAccessNone
+	}
+
+	return RecipeRoles[collaborator.StrRole]
+}
+
+// Функция для проверки, что у пользователя есть нужный доступ к рецепту
+func (server *Server) CanAccessRecipe(user *models.User, recipe *models.Recipe, need RecipeAccess) bool {
+	return server.GetRecipeAccess(user.ID, recipe) >= need
+}
+
+// Функция для проверки, что пользователь может смотреть рецепт:
+// рецепт доступен всем или пользователь - автор или соавтор
+func (server *Server) CanReadRecipe(user *models.User, recipe *models.Recipe) bool {
+	return IsPublicRecipe(recipe) || server.CanAccessRecipe(user, recipe, RecipeAccessView)
+}
+
+// Функция для получения автора рецепта
+//
+// Место под файлы, загруженные соавторами, занимается у автора
+func (server *Server) GetRecipeOwner(recipe *models.Recipe) (*models.User, error) {
+	if recipe.User.ID == recipe.IntUserId {
+		return &recipe.User, nil
+	}
+
+	var owner models.User
+	err := server.DB.First(&owner, "id = ?", recipe.IntUserId).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &owner, nil
+}
+
+// Функция для получения рецепта, к которому у пользователя есть нужный доступ
+//
+// При ошибке ответ уже отправлен и вернётся nil
+func (server *Server) recipeForAccess(c echo.Context, user *models.User, need RecipeAccess) (*models.Recipe, error) {
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	var recipe models.Recipe
+	err = server.DB.First(&recipe, "id = ?", recipeID).Error
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return nil, c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	if !server.CanAccessRecipe(user, &recipe, need) {
+		return nil, c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	return &recipe, nil
+}
+
+// Функция для приглашения соавтора
+func (server *Server) InviteCollaboratorHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	recipe, err := server.recipeForAccess(c, user, RecipeAccessOwner)
+	if recipe == nil {
+		return err
+	}
+
+	var collaborator_data CollaboratorData
+	err = c.Bind(&collaborator_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if _, ok := RecipeRoles[collaborator_data.Role]; !ok {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверная роль"})
+	}
+
+	var invitee models.User
+	err = server.DB.First(&invitee, "str_user_name = ?", collaborator_data.UserName).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	if invitee.ID == recipe.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Автор не может быть соавтором своего рецепта"})
+	}
+
+	var count int64
+	err = server.DB.Model(&models.RecipeCollaborator{}).
+		Where("int_recipe_id = ? AND int_user_id = ?", recipe.ID, invitee.ID).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("Count collaborators: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось пригласить соавтора"})
+	}
+	if count > 0 {
+		return c.JSON(http.StatusConflict, &DefaultResponse{Message: "Пользователь уже приглашён"})
+	}
+
+	collaborator := models.RecipeCollaborator{
+		IntRecipeId:    recipe.ID,
+		IntUserId:      invitee.ID,
+		StrRole:        collaborator_data.Role,
+		IntInvitedById: user.ID,
+	}
+	err = server.DB.Create(&collaborator).Error
+	if err != nil {
+		log.Printf("Create collaborator: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось пригласить соавтора"})
+	}
+
+	return c.JSON(http.StatusOK, &CollaboratorResponse{Message: "Приглашение отправлено", Id: collaborator.ID})
+}
+
+// Функция для получения соавторов рецепта
+//
+// Список видят автор и соавторы
+func (server *Server) GetCollaboratorsHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	recipe, err := server.recipeForAccess(c, user, RecipeAccessView)
+	if recipe == nil {
+		return err
+	}
+
+	collaborators := []models.RecipeCollaborator{}
+	err = server.DB.Preload("User").Order("id").Find(&collaborators, "int_recipe_id = ?", recipe.ID).Error
+	if err != nil {
+		log.Printf("Get collaborators: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить соавторов"})
+	}
+
+	return c.JSON(http.StatusOK, collaborators)
+}
+
+// Функция для изменения роли соавтора
+func (server *Server) ChangeCollaboratorRoleHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	recipe, err := server.recipeForAccess(c, user, RecipeAccessOwner)
+	if recipe == nil {
+		return err
+	}
+
+	var collaborator_data CollaboratorData
+	err = c.Bind(&collaborator_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if _, ok := RecipeRoles[collaborator_data.Role]; !ok {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверная роль"})
+	}
+
+	result := server.DB.Model(&models.RecipeCollaborator{}).
+		Where("int_recipe_id = ? AND int_user_id = ?", recipe.ID, c.Param("user_id")).
+		Update("str_role", collaborator_data.Role)
+	if result.Error != nil {
+		log.Printf("Change collaborator role: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить роль"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Соавтор не найден"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Роль изменена"})
+}
+
+// Функция для удаления соавтора
+//
+// Автор может удалить любого соавтора, соавтор - только себя
+func (server *Server) RemoveCollaboratorHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collaboratorID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id пользователя"})
+	}
+
+	need := RecipeAccessOwner
+	if uint(collaboratorID) == user.ID {
+		need = RecipeAccessView
+	}
+
+	recipe, err := server.recipeForAccess(c, user, need)
+	if recipe == nil {
+		return err
+	}
+
+	// Удаляем запись совсем, чтобы пользователя можно было пригласить снова
+	result := server.DB.Unscoped().
+		Where("int_recipe_id = ? AND int_user_id = ?", recipe.ID, collaboratorID).
+		Delete(&models.RecipeCollaborator{})
+	if result.Error != nil {
+		log.Printf("Remove collaborator: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить соавтора"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Соавтор не найден"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Соавтор удален"})
+}
+
+// Функция для получения приглашений пользователя, которые он ещё не принял
+func (server *Server) GetInvitesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	invites := []models.RecipeCollaborator{}
+	err = server.DB.Preload("Recipe").
+		Joins("JOIN recipes ON recipes.id = recipe_collaborators.int_recipe_id AND recipes.deleted_at IS NULL").
+		Order("recipe_collaborators.id").
+		Find(&invites, "recipe_collaborators.int_user_id = ? AND recipe_collaborators.bool_accepted = ?", user.ID, false).Error
+	if err != nil {
+		log.Printf("Get invites: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить приглашения"})
+	}
+
+	return c.JSON(http.StatusOK, invites)
+}
+
+// Функция для принятия приглашения стать соавтором
+func (server *Server) AcceptInviteHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	result := server.DB.Model(&models.RecipeCollaborator{}).
+		Where("id = ? AND int_user_id = ? AND bool_accepted = ?", c.Param("invite_id"), user.ID, false).
+		Update("bool_accepted", true)
+	if result.Error != nil {
+		log.Printf("Accept invite: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось принять приглашение"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Приглашение не найдено"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Приглашение принято"})
+}
+
+// Функция для отказа от приглашения стать соавтором
+func (server *Server) DeclineInviteHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	result := server.DB.Unscoped().
+		Where("id = ? AND int_user_id = ? AND bool_accepted = ?", c.Param("invite_id"), user.ID, false).
+		Delete(&models.RecipeCollaborator{})
+	if result.Error != nil {
+		log.Printf("Decline invite: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось отклонить приглашение"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Приглашение не найдено"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Приглашение отклонено"})
+}
+
+// Функция для получения рецептов, в которых пользователь - соавтор
+func (server *Server) GetCollaborativeRecipesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var recipes []models.Recipe
+	err = PreloadRecipe(server.DB).
+		Where("recipes.id IN (SELECT int_recipe_id FROM recipe_collaborators WHERE int_user_id = ? AND bool_accepted = ? AND deleted_at IS NULL)", user.ID, true).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Get collaborative recipes: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	return server.SendRecipes(c, recipes)
+}
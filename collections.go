@@ -0,0 +1,577 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Название коллекции, в которую переносится избранное
+const DefaultCollectionName = "Избранное"
+
+// Ошибка при неполном или повторяющемся списке
+var ErrWrongOrder = errors.New("неверный порядок")
+
+type CollectionData struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Public      *bool  `json:"public"` // если не указано, то не меняется
+}
+
+type CollectionsOrderData struct {
+	Collections []uint `json:"collections"` // ID всех коллекций пользователя в нужном порядке
+}
+
+type CollectionRecipesOrderData struct {
+	Recipes []uint `json:"recipes"` // ID всех рецептов коллекции в нужном порядке
+}
+
+// Функция для сортировки коллекций пользователя по порядку
+func OrderCollections(db *gorm.DB) *gorm.DB {
+	return db.Order("int_collection_order, id")
+}
+
+// Функция для сортировки рецептов коллекции по порядку
+func OrderCollectionRecipes(db *gorm.DB) *gorm.DB {
+	return db.Order("int_recipe_order, id")
+}
+
+// Функция для получения коллекции вместе с рецептами
+func (server *Server) GetCollectionById(id int) (*models.Collection, error) {
+	var collection models.Collection
+
+	err := server.DB.
+		Preload("CollectionRecipes", OrderCollectionRecipes).
+		Preload("CollectionRecipes.Recipe").
+		First(&collection, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Удалённые рецепты не показываем
+	recipes := collection.CollectionRecipes[:0]
+	for _, collectionRecipe := range collection.CollectionRecipes {
+		if collectionRecipe.Recipe.ID != 0 {
+			recipes = append(recipes, collectionRecipe)
+		}
+	}
+	collection.CollectionRecipes = recipes
+
+	return &collection, nil
+}
+
+// Функция для отбора рецептов коллекции, которые может смотреть пользователь
+//
+// Рецепты, которые автор скрыл или вернул в черновики после добавления
+// в коллекцию, не показываются
+func (server *Server) FilterReadableCollectionRecipes(user *models.User, collection *models.Collection) {
+	recipes := collection.CollectionRecipes[:0]
+	for i := range collection.CollectionRecipes {
+		if server.CanReadRecipe(user, &collection.CollectionRecipes[i].Recipe) {
+			recipes = append(recipes, collection.CollectionRecipes[i])
+		}
+	}
+	collection.CollectionRecipes = recipes
+}
+
+// Функция для переноса избранного в коллекции по умолчанию
+//
+// Коллекция создаётся для пользователей, у которых есть избранные рецепты
+// и ещё нет коллекции по умолчанию
+func (server *Server) MigrateFavoritesToCollections() error {
+	var users []models.User
+	err := server.DB.
+		Where("EXISTS (SELECT 1 FROM user_favorite_recipes WHERE user_favorite_recipes.user_id = users.id)").
+		Where("NOT EXISTS (SELECT 1 FROM collections WHERE collections.int_user_id = users.id AND collections.bool_collection_default = ? AND collections.deleted_at IS NULL)", true).
+		Preload("UserFavorite").
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		collection := models.Collection{
+			StrCollectionName:     DefaultCollectionName,
+			BoolCollectionDefault: true,
+			IntUserId:             user.ID,
+		}
+		for i, recipe := range user.UserFavorite {
+			collection.CollectionRecipes = append(collection.CollectionRecipes, models.CollectionRecipe{
+				IntRecipeId:    recipe.ID,
+				IntRecipeOrder: i,
+			})
+		}
+
+		err = server.DB.Omit("CollectionRecipes.Recipe").Create(&collection).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Функция для создания коллекции
+func (server *Server) CreateCollectionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var collection_data CollectionData
+	err = c.Bind(&collection_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if collection_data.Name == "" {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Название коллекции не может быть пустым"})
+	}
+
+	// Новая коллекция добавляется в конец списка
+	var count int64
+	err = server.DB.Model(&models.Collection{}).Where("int_user_id = ?", user.ID).Count(&count).Error
+	if err != nil {
+		log.Printf("Count collections: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать коллекцию"})
+	}
+
+	collection := models.Collection{
+		StrCollectionName:  collection_data.Name,
+		StrCollectionDesc:  collection_data.Description,
+		IntCollectionOrder: int(count),
+		IntUserId:          user.ID,
+	}
+	if collection_data.Public != nil {
+		collection.BoolCollectionPublic = *collection_data.Public
+	}
+
+	err = server.DB.Create(&collection).Error
+	if err != nil {
+		log.Printf("Create collection: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать коллекцию"})
+	}
+
+	return c.JSON(http.StatusOK, &CollectionResponse{Message: "Коллекция создана", Id: collection.ID})
+}
+
+// Функция для получения коллекций пользователя
+func (server *Server) GetMyCollectionsHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var collections []models.Collection
+	err = OrderCollections(server.DB).Find(&collections, "int_user_id = ?", user.ID).Error
+	if err != nil {
+		log.Printf("Get collections: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти коллекции"})
+	}
+
+	return c.JSON(http.StatusOK, collections)
+}
+
+// Функция для получения своей коллекции с рецептами
+func (server *Server) GetMyCollectionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	collection, err := server.GetCollectionById(collectionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец коллекции
+	if user.ID != collection.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Коллекция принадлежит другому пользователю"})
+	}
+
+	server.FilterReadableCollectionRecipes(user, collection)
+
+	return c.JSON(http.StatusOK, collection)
+}
+
+// Функция для получения открытой коллекции
+//
+// Показываются только рецепты, доступные всем
+func (server *Server) GetCollectionHandle(c echo.Context) error {
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	collection, err := server.GetCollectionById(collectionID)
+	if err != nil || !collection.BoolCollectionPublic {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	recipes := collection.CollectionRecipes[:0]
+	for _, collectionRecipe := range collection.CollectionRecipes {
+		if IsPublicRecipe(&collectionRecipe.Recipe) {
+			recipes = append(recipes, collectionRecipe)
+		}
+	}
+	collection.CollectionRecipes = recipes
+
+	return c.JSON(http.StatusOK, collection)
+}
+
+// Функция для изменения названия, описания и видимости коллекции
+func (server *Server) UpdateCollectionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	var collection models.Collection
+	err = server.DB.First(&collection, "id = ?", collectionID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец коллекции
+	if user.ID != collection.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Коллекция принадлежит другому пользователю"})
+	}
+
+	var collection_data CollectionData
+	err = c.Bind(&collection_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if collection_data.Name == "" {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Название коллекции не может быть пустым"})
+	}
+
+	collection.StrCollectionName = collection_data.Name
+	collection.StrCollectionDesc = collection_data.Description
+	if collection_data.Public != nil {
+		collection.BoolCollectionPublic = *collection_data.Public
+	}
+
+	err = server.DB.Save(&collection).Error
+	if err != nil {
+		log.Printf("Update collection: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить коллекцию"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Коллекция обновлена"})
+}
+
+// Функция для удаления коллекции
+//
+// Коллекцию по умолчанию удалить нельзя
+func (server *Server) DeleteCollectionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	var collection models.Collection
+	err = server.DB.First(&collection, "id = ?", collectionID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец коллекции
+	if user.ID != collection.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Коллекция принадлежит другому пользователю"})
+	}
+
+	if collection.BoolCollectionDefault {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Нельзя удалить коллекцию по умолчанию"})
+	}
+
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("int_collection_id = ?", collection.ID).Delete(&models.CollectionRecipe{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&collection).Error
+	})
+	if err != nil {
+		log.Printf("Delete collection: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить коллекцию"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Коллекция удалена"})
+}
+
+// Функция для изменения порядка коллекций пользователя
+//
+// Принимает полный список ID коллекций в новом порядке
+func (server *Server) ReorderCollectionsHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var order_data CollectionsOrderData
+	err = c.Bind(&order_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		var collections []models.Collection
+		err := tx.Find(&collections, "int_user_id = ?", user.ID).Error
+		if err != nil {
+			return err
+		}
+
+		ids := make([]uint, len(collections))
+		for i, collection := range collections {
+			ids[i] = collection.ID
+		}
+		if !IsPermutation(ids, order_data.Collections) {
+			return ErrWrongOrder
+		}
+
+		for i, collectionID := range order_data.Collections {
+			err = tx.Model(&models.Collection{}).Where("id = ?", collectionID).UpdateColumn("int_collection_order", i).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrWrongOrder {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список должен содержать все коллекции пользователя по одному разу"})
+	}
+	if err != nil {
+		log.Printf("Reorder collections: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить порядок коллекций"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Порядок коллекций изменен"})
+}
+
+// Функция для добавления рецепта в коллекцию
+//
+// Повторное добавление рецепта ничего не меняет.
+// Добавить можно свой рецепт или рецепт, доступный всем
+func (server *Server) AddRecipeToCollectionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	var collection models.Collection
+	err = server.DB.First(&collection, "id = ?", collectionID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец коллекции
+	if user.ID != collection.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Коллекция принадлежит другому пользователю"})
+	}
+
+	var recipe models.Recipe
+	err = server.DB.First(&recipe, "id = ?", recipeID).Error
+	if err != nil || !server.CanReadRecipe(user, &recipe) {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	var count int64
+	err = server.DB.Model(&models.CollectionRecipe{}).Where("int_collection_id = ?", collection.ID).Count(&count).Error
+	if err != nil {
+		log.Printf("Count collection recipes: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось добавить рецепт в коллекцию"})
+	}
+
+	collectionRecipe := models.CollectionRecipe{
+		IntCollectionId: collection.ID,
+		IntRecipeId:     recipe.ID,
+		IntRecipeOrder:  int(count),
+	}
+	result := server.DB.Omit("Collection", "Recipe").
+		Where(models.CollectionRecipe{IntCollectionId: collection.ID, IntRecipeId: recipe.ID}).
+		FirstOrCreate(&collectionRecipe)
+	if result.Error != nil {
+		log.Printf("Add recipe to collection: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось добавить рецепт в коллекцию"})
+	}
+
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт уже в коллекции"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт добавлен в коллекцию"})
+}
+
+// Функция для удаления рецепта из коллекции
+func (server *Server) RemoveRecipeFromCollectionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	var collection models.Collection
+	err = server.DB.First(&collection, "id = ?", collectionID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец коллекции
+	if user.ID != collection.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Коллекция принадлежит другому пользователю"})
+	}
+
+	result := server.DB.Unscoped().
+		Where("int_collection_id = ? AND int_recipe_id = ?", collection.ID, recipeID).
+		Delete(&models.CollectionRecipe{})
+	if result.Error != nil {
+		log.Printf("Remove recipe from collection: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить рецепт из коллекции"})
+	}
+
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Рецепта нет в коллекции"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт удален из коллекции"})
+}
+
+// Функция для изменения порядка рецептов в коллекции
+//
+// Принимает полный список ID рецептов коллекции в новом порядке
+func (server *Server) ReorderCollectionRecipesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id коллекции"})
+	}
+
+	collection, err := server.GetCollectionById(collectionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Коллекция не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец коллекции
+	if user.ID != collection.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Коллекция принадлежит другому пользователю"})
+	}
+
+	// Переставляются только рецепты, которые пользователь видит в коллекции,
+	// а скрытые от него остаются после них в прежнем порядке
+	var recipeIDs, hiddenIDs []uint
+	for i := range collection.CollectionRecipes {
+		collectionRecipe := &collection.CollectionRecipes[i]
+		if server.CanReadRecipe(user, &collectionRecipe.Recipe) {
+			recipeIDs = append(recipeIDs, collectionRecipe.IntRecipeId)
+		} else {
+			hiddenIDs = append(hiddenIDs, collectionRecipe.IntRecipeId)
+		}
+	}
+
+	var order_data CollectionRecipesOrderData
+	err = c.Bind(&order_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		if !IsPermutation(recipeIDs, order_data.Recipes) {
+			return ErrWrongOrder
+		}
+
+		for i, recipeID := range append(order_data.Recipes, hiddenIDs...) {
+			err := tx.Model(&models.CollectionRecipe{}).
+				Where("int_collection_id = ? AND int_recipe_id = ?", collection.ID, recipeID).
+				UpdateColumn("int_recipe_order", i).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrWrongOrder {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список должен содержать все рецепты коллекции по одному разу"})
+	}
+	if err != nil {
+		log.Printf("Reorder collection recipes: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить порядок рецептов"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Порядок рецептов изменен"})
+}
+
+// Функция для проверки, что order содержит каждый элемент ids ровно один раз
+func IsPermutation(ids []uint, order []uint) bool {
+	if len(ids) != len(order) {
+		return false
+	}
+
+	remaining := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		remaining[id] = true
+	}
+	for _, id := range order {
+		if !remaining[id] {
+			return false
+		}
+		delete(remaining, id)
+	}
+
+	return true
+}
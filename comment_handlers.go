@@ -55,7 +55,7 @@ func (server *Server) GetCommentsHandle(c echo.Context) error {
 		log.Printf("Get recipe by id: %s", err.Error())
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
-	if !recipe.BoolRecipeVisibility {
+	if !IsPublicRecipe(recipe) {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
@@ -81,7 +81,7 @@ func (server *Server) CreateCommentHandle(c echo.Context) error {
 		log.Printf("Get recipe by id: %s", err.Error())
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
-	if !recipe.BoolRecipeVisibility {
+	if !IsPublicRecipe(recipe) {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Ошибка при сохранении рецепта или этапа, который уже изменили
+var ErrVersionConflict = errors.New("версия изменилась")
+
+// Функция для получения ETag рецепта
+func RecipeETag(recipe *models.Recipe) string {
+	return fmt.Sprintf(`"recipe-%d-%d"`, recipe.ID, recipe.IntVersion)
+}
+
+// Функция для получения ETag этапа
+func StageETag(stage *models.Stage) string {
+	return fmt.Sprintf(`"stage-%d-%d"`, stage.ID, stage.IntVersion)
+}
+
+// Функция для получения слабого ETag
+//
+// Нужен для ответов, тело которых зависит не только от версии записи,
+// но и от параметров запроса и пользователя. В If-Match его можно
+// передавать как есть
+func WeakETag(etag string) string {
+	return "W/" + etag
+}
+
+// Функция для проверки заголовка If-Match
+//
+// Заголовок может содержать несколько ETag через запятую
+// или * для любой версии
+func MatchETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция для сохранения изменений, если версия записи не изменилась
+//
+// Вместе с полями увеличивается номер версии. Если запись успели
+// изменить, то возвращается ErrVersionConflict
+func UpdateVersioned(db *gorm.DB, model interface{}, id uint, version int, values map[string]interface{}) error {
+	values["int_version"] = gorm.Expr("int_version + 1")
+
+	result := db.Model(model).Where("id = ? AND int_version = ?", id, version).Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Функция для увеличения номера версии рецепта
+//
+// Вызывается после любых изменений рецепта, его этапов и ингредиентов,
+// чтобы ETag рецепта перестал совпадать с сохранённым у клиента
+func (server *Server) BumpRecipeVersion(recipeID uint) {
+	err := server.DB.Model(&models.Recipe{}).Where("id = ?", recipeID).
+		UpdateColumn("int_version", gorm.Expr("int_version + 1")).Error
+	if err != nil {
+		log.Printf("Bump version of recipe %d: %s", recipeID, err.Error())
+	}
+}
+
+// Функция для проверки версии перед изменением
+//
+// Без заголовка If-Match изменение не принимается. Если версия устарела,
+// то в ответе возвращается текущее состояние и его ETag
+func checkIfMatch(c echo.Context, etag string, current interface{}) (bool, error) {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return false, c.JSON(http.StatusPreconditionRequired, &DefaultResponse{Message: "Не передан заголовок If-Match"})
+	}
+
+	if !MatchETag(header, etag) {
+		return false, sendVersionConflict(c, etag, current)
+	}
+
+	return true, nil
+}
+
+// Функция для проверки версии рецепта перед изменением его частей
+//
+// Используется там, где меняются не поля рецепта, а его этапы,
+// ингредиенты, фото или состояние. Следующая версия занимается сразу,
+// поэтому из двух запросов с одним ETag пройдёт только первый,
+// а второй получит текущее состояние рецепта. При ошибке ответ уже отправлен
+func (server *Server) claimRecipeVersion(c echo.Context, recipe *models.Recipe) (bool, error) {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return false, c.JSON(http.StatusPreconditionRequired, &DefaultResponse{Message: "Не передан заголовок If-Match"})
+	}
+
+	if !MatchETag(header, RecipeETag(recipe)) {
+		return false, server.sendRecipeConflict(c, recipe.ID)
+	}
+
+	err := UpdateVersioned(server.DB, &models.Recipe{}, recipe.ID, recipe.IntVersion, map[string]interface{}{})
+	if err == ErrVersionConflict {
+		return false, server.sendRecipeConflict(c, recipe.ID)
+	}
+	if err != nil {
+		log.Printf("Claim version of recipe %d: %s", recipe.ID, err.Error())
+		return false, c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить рецепт"})
+	}
+	recipe.IntVersion++
+
+	c.Response().Header().Set("ETag", RecipeETag(recipe))
+	return true, nil
+}
+
+// Функция для отправки текущего состояния при конфликте версий
+func sendVersionConflict(c echo.Context, etag string, current interface{}) error {
+	c.Response().Header().Set("ETag", etag)
+	return c.JSON(http.StatusPreconditionFailed, &VersionConflictResponse{
+		Message: "Данные изменились, обновите страницу",
+		ETag:    etag,
+		Current: current,
+	})
+}
+
+// Функция для отправки текущего состояния рецепта при конфликте версий
+func (server *Server) sendRecipeConflict(c echo.Context, recipeID uint) error {
+	recipe, err := server.GetRecipeById(int(recipeID))
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	return sendVersionConflict(c, RecipeETag(recipe), recipe)
+}
+
+// Функция для отправки текущего состояния этапа при конфликте версий
+func (server *Server) sendStageConflict(c echo.Context, stageID uint) error {
+	stage, err := server.GetStageById(int(stageID))
+	if err != nil {
+		log.Printf("Get stage by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
+	}
+
+	return sendVersionConflict(c, StageETag(stage), stage)
+}
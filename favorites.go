@@ -0,0 +1,215 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/claims"
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// Функция для получения ID пользователя, если он вошёл
+//
+// На открытых эндпоинтах токен необязателен, для гостя возвращается 0
+func OptionalUserId(c echo.Context) uint {
+	user_token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0
+	}
+
+	user_claims, ok := user_token.Claims.(*claims.UserClaims)
+	if !ok {
+		return 0
+	}
+
+	return user_claims.IntUserId
+}
+
+// Функция для получения указателей на рецепты списка
+func recipePointers(recipes []models.Recipe) []*models.Recipe {
+	pointers := make([]*models.Recipe, len(recipes))
+	for i := range recipes {
+		pointers[i] = &recipes[i]
+	}
+	return pointers
+}
+
+// Функция для заполнения количества добавлений в избранное
+// и признака того, что рецепт в избранном у пользователя userID
+func (server *Server) FillFavorites(recipes []*models.Recipe, userID uint) error {
+	if len(recipes) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(recipes))
+	for i, recipe := range recipes {
+		ids[i] = recipe.ID
+	}
+
+	var counts []struct {
+		RecipeId uint
+		Count    int64
+	}
+	err := server.DB.Table("user_favorite_recipes").
+		Select("recipe_id, COUNT(*) AS count").
+		Where("recipe_id IN ?", ids).
+		Group("recipe_id").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	countById := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		countById[count.RecipeId] = count.Count
+	}
+
+	favorite := map[uint]bool{}
+	if userID != 0 {
+		var favoriteIds []uint
+		err = server.DB.Table("user_favorite_recipes").
+			Where("user_id = ? AND recipe_id IN ?", userID, ids).
+			Pluck("recipe_id", &favoriteIds).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range favoriteIds {
+			favorite[id] = true
+		}
+	}
+
+	for _, recipe := range recipes {
+		recipe.IntFavoriteCount = countById[recipe.ID]
+		recipe.BoolFavorite = favorite[recipe.ID]
+	}
+
+	return nil
+}
+
+// Функция для отправки списка рецептов на фронтэнд
+//
+// К рецептам добавляются сведения об избранном для текущего пользователя
+func (server *Server) SendRecipes(c echo.Context, recipes []models.Recipe) error {
+	err := server.FillFavorites(recipePointers(recipes), OptionalUserId(c))
+	if err != nil {
+		log.Printf("Fill favorites: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	return c.JSON(http.StatusOK, recipes)
+}
+
+// Функция для добавления рецепта в избранное
+//
+// Повторное добавление ничего не меняет. Добавить можно рецепт,
+// доступный всем, или рецепт, где пользователь автор или соавтор
+func (server *Server) AddRecipeToFavoritesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	// Получаем информацию о рецепте
+	var recipe models.Recipe
+	err = server.DB.First(&recipe, "id = ?", recipeID).Error
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Скрытые рецепты и черновики может добавить только автор или соавтор
+	if !server.CanReadRecipe(user, &recipe) {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Добавляем рецепт в избранные пользователя, повторы пропускаются
+	err = server.DB.Model(user).Association("UserFavorite").Append(&recipe)
+	if err != nil {
+		log.Printf("Favorite: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось добавить рецепт в избранное"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Ок"})
+}
+
+// Функция для удаления рецепта из избранного
+func (server *Server) RemoveRecipeFromFavoritesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	result := server.DB.Exec("DELETE FROM user_favorite_recipes WHERE user_id = ? AND recipe_id = ?", user.ID, recipeID)
+	if result.Error != nil {
+		log.Printf("Unfavorite: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить рецепт из избранного"})
+	}
+
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Рецепта нет в избранном"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт удален из избранного"})
+}
+
+// Функция для получения избранных рецептов пользователя по страницам
+//
+// Параметры page (с единицы) и limit. Рецепты, которые стали
+// недоступны (скрыты или сняты в черновики), показываются
+// только их автору и соавторам
+func (server *Server) GetFavoritesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	page, limit, err := ParsePagination(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверные параметры страницы"})
+	}
+
+	query := ReadableRecipes(server.DB.Model(&models.Recipe{}), user.ID).
+		Joins("JOIN user_favorite_recipes ON user_favorite_recipes.recipe_id = recipes.id AND user_favorite_recipes.user_id = ?", user.ID)
+
+	var total int64
+	err = query.Count(&total).Error
+	if err != nil {
+		log.Printf("Count favorites: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить избранное"})
+	}
+
+	recipes := []models.Recipe{}
+	err = PreloadRecipe(query).Scopes(Paginate(page, limit)).Order("recipes.id").Find(&recipes).Error
+	if err != nil {
+		log.Printf("Get favorites: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить избранное"})
+	}
+
+	err = server.FillFavorites(recipePointers(recipes), user.ID)
+	if err != nil {
+		log.Printf("Fill favorites: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить избранное"})
+	}
+
+	return c.JSON(http.StatusOK, &RecipesPageResponse{Recipes: recipes, Page: page, Limit: limit, Total: total})
+}
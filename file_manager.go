@@ -1,24 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path"
 )
 
-// Функция для создания директорий для хранения изображений
-func (server *Server) CreateUploadDirs() error {
-	fsErr := os.MkdirAll(server.UploadsPath, 0755)
-	if fsErr != nil {
-		return fsErr
-	}
-	return nil
-}
+// Ошибка при загрузке файла больше допустимого размера
+var ErrFileTooLarge = errors.New("файл слишком большой")
 
 // Функция для получения расширения файла
 // Наследуется от Server, на вход принимает заголовок файла
@@ -29,6 +24,7 @@ func (server *Server) GetFileExtByMimetype(fileHeader *multipart.FileHeader) (st
 	if err != nil {
 		return "", err
 	}
+	defer file.Close()
 
 	// Берём первые 512 байт
 	buff := make([]byte, 512)
@@ -54,28 +50,104 @@ func (server *Server) GetFileExtByMimetype(fileHeader *multipart.FileHeader) (st
 	return "", errors.New("разрешены только файлы png, jpg, gif")
 }
 
-// Функция для сохранения file с расширением ext в папку dst (см. выше)
-func (server *Server) SaveFileWithExt(file multipart.File, ext string) (string, error) {
-	// Создаем новое имя файла: случайная строка в 16 символов + расширение
-	filename := fmt.Sprintf("%s.%s", RandomString(16), ext)
-	// Создаем путь, по которому будет доступен файл
-	filePath := path.Join(server.UploadsPath, filename)
+// Функция для получения имени файла по его содержимому
+//
+// Имя состоит из SHA-256 содержимого и расширения
+func ContentFileName(data []byte, ext string) string {
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("%s.%s", hex.EncodeToString(hash[:]), ext)
+}
 
-	log.Printf("Saving file to %s", filePath)
+// Функция для сохранения file с расширением ext в хранилище файлов
+//
+// Файл сохраняется не как есть, а после очистки изображения
+// (см. SanitizeImage), обработка выполняется в пуле обработчиков
+func (server *Server) SaveFileWithExt(file multipart.File, ext string) (string, error) {
+	// Читаем загруженный файл целиком, но не больше ограничения на загрузку
+	limit := server.UploadLimitBytes()
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > limit {
+		return "", ErrFileTooLarge
+	}
 
-	// Создаем файл
-	dst, err := os.Create(filePath)
+	// Перекодируем изображение
+	var buf bytes.Buffer
+	err = server.RunImageJob(func() error {
+		return server.SanitizeImage(&buf, data, ext)
+	})
 	if err != nil {
 		return "", err
 	}
-	defer dst.Close()
 
-	// Если не удается прочитать файл
-	if _, err := io.Copy(dst, file); err != nil {
-		// Т отправляем ошибку
+	// Имя файла - хэш его содержимого, поэтому одинаковые
+	// изображения хранятся в одном экземпляре
+	filename := ContentFileName(buf.Bytes(), ext)
+
+	_, err = server.Storage.Stat(filename)
+	if err == nil {
+		// Файл мог остаться без ссылок и ждать удаления
+		log.Printf("File %s already stored", filename)
+		server.KeepFiles(filename)
+		return filename, nil
+	}
+	if err != ErrFileNotFound {
+		return "", err
+	}
+
+	log.Printf("Saving file %s", filename)
+
+	// Сохраняем результат в хранилище
+	err = server.Storage.Put(filename, &buf, int64(buf.Len()), ContentTypeByName(filename))
+	if err != nil {
 		return "", err
 	}
 
 	// Иначе возвращаем имя файла
 	return filename, nil
 }
+
+// Функция для удаления файла из хранилища
+func (server *Server) RemoveFile(filename string) error {
+	log.Printf("Removing file %s", filename)
+
+	return server.Storage.Delete(filename)
+}
+
+// Функция для сохранения файла из формы
+//
+// Проверяет тип файла и сохраняет его под новым именем
+func (server *Server) SaveFormFile(fileHeader *multipart.FileHeader) (string, error) {
+	// Получаем расширение файла
+	fileExt, err := server.GetFileExtByMimetype(fileHeader)
+	if err != nil {
+		return "", fmt.Errorf("Не удалось определить тип файла: %s", err.Error())
+	}
+
+	// Пытаемся прочитать файл
+	src, err := fileHeader.Open()
+	if err != nil {
+		return "", fmt.Errorf("Не удалось прочитать файл: %s", err.Error())
+	}
+	defer src.Close()
+
+	// Сохраняем файл
+	filename, err := server.SaveFileWithExt(src, fileExt)
+	if err != nil {
+		return "", fmt.Errorf("Не удалось сохранить файл: %s", err.Error())
+	}
+
+	return filename, nil
+}
+
+// Функция для удаления нескольких файлов, ошибки только логируются
+func (server *Server) RemoveFiles(filenames []string) {
+	for _, filename := range filenames {
+		err := server.RemoveFile(filename)
+		if err != nil {
+			log.Printf("Remove file %s: %s", filename, err.Error())
+		}
+	}
+}
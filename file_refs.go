@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Колонка БД, в которой хранятся имена загруженных файлов
+//
+// variants - колонка с уменьшенными копиями в JSON, scope - условие,
+// при котором запись считается ссылкой на файл
+type fileReferenceColumn struct {
+	model    interface{}
+	column   string
+	variants string
+	scope    func(db *gorm.DB) *gorm.DB
+}
+
+// Все колонки, которые ссылаются на файлы в хранилище
+var fileReferenceColumns = []fileReferenceColumn{
+	{&models.Photo{}, "photos.str_image", "photos.photo_variants", LiveStagePhotos},
+	{&models.Recipe{}, "recipes.str_recipe_image", "recipes.recipe_image_variants", nil},
+	{&models.User{}, "users.str_user_image", "", nil},
+}
+
+// Функция для выбора фото этапов, которые не удалены вместе с рецептом
+//
+// Фото этапов удалённого рецепта ссылками на файлы не считаются
+func LiveStagePhotos(db *gorm.DB) *gorm.DB {
+	return db.
+		Joins("JOIN stages ON stages.id = photos.int_stage_id AND stages.deleted_at IS NULL").
+		Joins("JOIN recipes ON recipes.id = stages.int_recipe_id AND recipes.deleted_at IS NULL")
+}
+
+// Функция для подсчёта ссылок на файл
+//
+// Файлы хранятся по хэшу содержимого, поэтому один файл может
+// использоваться в нескольких фото, рецептах и у пользователей.
+// Ссылкой считается и упоминание файла среди уменьшенных копий.
+// Учитываются те же записи, что и в ReferencedFiles
+func (server *Server) CountFileReferences(filename string) (int64, error) {
+	var total int64
+	for _, reference := range fileReferenceColumns {
+		query := server.DB.Model(reference.model)
+		if reference.scope != nil {
+			query = query.Scopes(reference.scope)
+		}
+
+		if reference.variants != "" {
+			// Копии хранятся в JSON как значения объекта, имя файла в кавычках
+			query = query.Where(reference.column+" = ? OR "+reference.variants+" LIKE ?", filename, "%\""+filename+"\"%")
+		} else {
+			query = query.Where(reference.column+" = ?", filename)
+		}
+
+		var count int64
+		err := query.Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+
+	return total, nil
+}
+
+// Функция для освобождения изображения
+//
+// Вызывается после того, как запись перестала ссылаться на файл.
+// Если на файл больше никто не ссылается, то он и его копии отмечаются
+// как файлы без ссылок. Сами файлы удаляет сборщик мусора после срока
+// ожидания (см. CollectGarbage), поэтому повторная загрузка того же
+// изображения в это время не останется без файла
+func (server *Server) ReleaseImage(filename string, variants map[string]string) {
+	if filename == "" {
+		return
+	}
+
+	count, err := server.CountFileReferences(filename)
+	if err != nil {
+		log.Printf("Count references of %s: %s", filename, err.Error())
+		return
+	}
+
+	if count > 0 {
+		return
+	}
+
+	orphans := []models.OrphanFile{{StrFileName: filename}}
+	for _, variant := range variants {
+		orphans = append(orphans, models.OrphanFile{StrFileName: variant})
+	}
+
+	// Если файл уже отмечен, то время первой отметки не меняется
+	err = server.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&orphans).Error
+	if err != nil {
+		log.Printf("Mark orphan files of %s: %s", filename, err.Error())
+	}
+}
+
+// Функция для снятия отметки о файлах без ссылок
+//
+// Вызывается, когда уже сохранённый файл загружают повторно,
+// чтобы срок ожидания до удаления начался заново
+func (server *Server) KeepFiles(filenames ...string) {
+	err := server.DB.Unscoped().Where("str_file_name IN ?", filenames).Delete(&models.OrphanFile{}).Error
+	if err != nil {
+		log.Printf("Unmark orphan files %v: %s", filenames, err.Error())
+	}
+}
+
+// Функция для проверки, что изображение и все его копии ещё хранятся
+func (server *Server) FilesStored(filename string, variants map[string]string) bool {
+	for _, name := range append([]string{filename}, mapValues(variants)...) {
+		_, err := server.Storage.Stat(name)
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Функция для освобождения изображений фото
+func (server *Server) ReleasePhotos(photos []models.Photo) {
+	for _, photo := range photos {
+		server.ReleaseImage(photo.StrImage, photo.PhotoVariants)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+)
+
+type FilterData struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+// Функция для проверки, что категория тега допустима
+func IsValidFilterCategory(category string) bool {
+	for _, allowed := range models.FilterCategories {
+		if category == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция для получения списка тегов
+//
+// Если указан параметр category, то возвращаются только теги этой категории
+func (server *Server) GetFiltersHandle(c echo.Context) error {
+	query := server.DB.Order("str_filter_category, str_filter_name")
+
+	category := c.QueryParam("category")
+	if category != "" {
+		if !IsValidFilterCategory(category) {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неизвестная категория тегов"})
+		}
+		query = query.Where("str_filter_category = ?", category)
+	}
+
+	var filters []models.Filter
+	err := query.Find(&filters).Error
+	if err != nil {
+		log.Printf("Get filters: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить список тегов"})
+	}
+
+	return c.JSON(http.StatusOK, filters)
+}
+
+// Функция для получения тегов, сгруппированных по категориям
+func (server *Server) GetFilterCategoriesHandle(c echo.Context) error {
+	var filters []models.Filter
+	err := server.DB.Order("str_filter_name").Find(&filters).Error
+	if err != nil {
+		log.Printf("Get filters: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить список тегов"})
+	}
+
+	// Раскладываем теги по категориям в фиксированном порядке
+	categories := make([]FilterCategoryResponse, 0, len(models.FilterCategories))
+	for _, category := range models.FilterCategories {
+		group := FilterCategoryResponse{Category: category, Filters: []models.Filter{}}
+		for _, filter := range filters {
+			if filter.StrFilterCategory == category {
+				group.Filters = append(group.Filters, filter)
+			}
+		}
+		categories = append(categories, group)
+	}
+
+	return c.JSON(http.StatusOK, categories)
+}
+
+// Функция для получения опубликованных рецептов с тегом
+func (server *Server) GetFilterRecipesHandle(c echo.Context) error {
+	filterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id тега"})
+	}
+
+	var filter models.Filter
+	err = server.DB.First(&filter, "id = ?", filterID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Тег не найден"})
+	}
+
+	var recipes []models.Recipe
+	err = FilterRecipesByTags(PublishedRecipes(PreloadRecipe(server.DB)), []uint{filter.ID}).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Get filter recipes: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	return server.SendRecipes(c, recipes)
+}
+
+// Функция для создания тега (только для администратора)
+func (server *Server) CreateFilterHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	if !IsAdmin(user) {
+		return c.JSON(http.StatusForbidden, &DefaultResponse{Message: "Недостаточно прав"})
+	}
+
+	var filter_data FilterData
+	err = c.Bind(&filter_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if len(filter_data.Name) == 0 {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Название тега не может быть пустым"})
+	}
+
+	if !IsValidFilterCategory(filter_data.Category) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неизвестная категория тегов"})
+	}
+
+	filter := models.Filter{
+		StrFilterName:     filter_data.Name,
+		StrFilterCategory: filter_data.Category,
+	}
+
+	err = server.DB.Create(&filter).Error
+	if err != nil {
+		log.Printf("Create filter: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать тег"})
+	}
+
+	return c.JSON(http.StatusOK, &FilterResponse{Message: "Тег создан", Id: filter.ID})
+}
+
+// Функция для изменения тега (только для администратора)
+func (server *Server) UpdateFilterHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	if !IsAdmin(user) {
+		return c.JSON(http.StatusForbidden, &DefaultResponse{Message: "Недостаточно прав"})
+	}
+
+	filterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id тега"})
+	}
+
+	var filter models.Filter
+	err = server.DB.First(&filter, "id = ?", filterID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Тег не найден"})
+	}
+
+	var filter_data FilterData
+	err = c.Bind(&filter_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	// Пустые поля оставляем без изменений
+	if len(filter_data.Name) != 0 {
+		filter.StrFilterName = filter_data.Name
+	}
+
+	if len(filter_data.Category) != 0 {
+		if !IsValidFilterCategory(filter_data.Category) {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неизвестная категория тегов"})
+		}
+		filter.StrFilterCategory = filter_data.Category
+	}
+
+	err = server.DB.Save(&filter).Error
+	if err != nil {
+		log.Printf("Update filter: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить тег"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Тег обновлен"})
+}
+
+// Функция для удаления тега (только для администратора)
+//
+// Вместе с тегом удаляются и его связи с рецептами
+func (server *Server) DeleteFilterHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	if !IsAdmin(user) {
+		return c.JSON(http.StatusForbidden, &DefaultResponse{Message: "Недостаточно прав"})
+	}
+
+	filterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id тега"})
+	}
+
+	var filter models.Filter
+	err = server.DB.First(&filter, "id = ?", filterID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Тег не найден"})
+	}
+
+	err = server.DB.Model(&filter).Association("FilterRecipes").Clear()
+	if err != nil {
+		log.Printf("Delete filter: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить тег"})
+	}
+
+	err = server.DB.Unscoped().Delete(&filter).Error
+	if err != nil {
+		log.Printf("Delete filter: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить тег"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Тег удален"})
+}
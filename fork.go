@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Функция для копирования рецепта в черновик пользователя
+//
+// Копируются описание, теги, этапы, фото и ингредиенты. Изображения
+// не дублируются в хранилище, копия ссылается на те же файлы, поэтому
+// место в квоте пользователя они не занимают
+func (server *Server) ForkRecipe(source *models.Recipe, user *models.User) (*models.Recipe, error) {
+	fork := models.Recipe{
+		StrRecipeName:       source.StrRecipeName,
+		IntServings:         source.IntServings,
+		IntTime:             source.IntTime,
+		StrRecipeCountry:    source.StrRecipeCountry,
+		StrRecipeType:       source.StrRecipeType,
+		StrRecipeImage:      source.StrRecipeImage,
+		RecipeImageVariants: source.RecipeImageVariants,
+		StrRecipeStatus:     RecipeStatusDraft,
+		IntUserId:           user.ID,
+		IntForkedFromId:     &source.ID,
+		IntForkedFromUserId: &source.IntUserId,
+		TotalNutrition:      source.TotalNutrition,
+		ServingNutrition:    source.ServingNutrition,
+	}
+
+	for _, stage := range source.RecipeStages {
+		stageCopy := models.Stage{
+			StrStageDesc:  stage.StrStageDesc,
+			IntStageOrder: stage.IntStageOrder,
+		}
+		for _, photo := range stage.StagePhotos {
+			stageCopy.StagePhotos = append(stageCopy.StagePhotos, models.Photo{
+				StrImage:      photo.StrImage,
+				PhotoVariants: photo.PhotoVariants,
+				StrCaption:    photo.StrCaption,
+				IntPhotoOrder: photo.IntPhotoOrder,
+			})
+		}
+		fork.RecipeStages = append(fork.RecipeStages, stageCopy)
+	}
+
+	for _, recipeIngredient := range source.RecipeIngredients {
+		fork.RecipeIngredients = append(fork.RecipeIngredients, models.RecipeIngredient{
+			IntGrams:        recipeIngredient.IntGrams,
+			FloatQuantity:   recipeIngredient.FloatQuantity,
+			StrUnit:         recipeIngredient.StrUnit,
+			IntIngredientId: recipeIngredient.IntIngredientId,
+		})
+	}
+
+	err := server.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("RecipeFilters").Create(&fork).Error
+		if err != nil {
+			return err
+		}
+
+		if len(source.RecipeFilters) > 0 {
+			err = tx.Model(&fork).Association("RecipeFilters").Append(source.RecipeFilters)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(source).UpdateColumn("int_fork_count", gorm.Expr("int_fork_count + 1")).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &fork, nil
+}
+
+// Функция для уменьшения счётчика копий исходного рецепта при удалении копии
+func (server *Server) ReleaseFork(recipe *models.Recipe) {
+	if recipe.IntForkedFromId == nil {
+		return
+	}
+
+	err := server.DB.Model(&models.Recipe{}).
+		Where("id = ? AND int_fork_count > 0", *recipe.IntForkedFromId).
+		UpdateColumn("int_fork_count", gorm.Expr("int_fork_count - 1")).Error
+	if err != nil {
+		log.Printf("Release fork of recipe %d: %s", *recipe.IntForkedFromId, err.Error())
+	}
+}
+
+// Функция для копирования чужого рецепта себе
+//
+// Скопировать можно опубликованный рецепт или тот, где пользователь автор или соавтор.
+// Копия создаётся черновиком и хранит ссылку на исходный рецепт
+func (server *Server) ForkRecipeHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Скрытые рецепты и черновики может скопировать только автор или соавтор
+	if !server.CanReadRecipe(user, recipe) {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	fork, err := server.ForkRecipe(recipe, user)
+	if err != nil {
+		log.Printf("Fork recipe: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось скопировать рецепт"})
+	}
+
+	// Сохраняем первую версию копии
+	server.RecordRecipeRevision(fork.ID, user.ID, fmt.Sprintf("Скопирован рецепт %d", recipe.ID))
+
+	return c.JSON(http.StatusOK, &RecipeResponse{Message: "Рецепт скопирован", Id: fork.ID})
+}
+
+// Функция для получения опубликованных копий рецепта
+func (server *Server) GetRecipeForksHandle(c echo.Context) error {
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil || !IsPublicRecipe(recipe) {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	var forks []models.Recipe
+	err = PublishedRecipes(PreloadRecipe(server.DB)).
+		Find(&forks, "int_forked_from_id = ?", recipe.ID).Error
+	if err != nil {
+		log.Printf("Get forks: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	return server.SendRecipes(c, forks)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+)
+
+// Настройки сборщика мусора по умолчанию
+const (
+	DefaultGCInterval    = 6 * time.Hour  // как часто запускать сборку в фоне
+	DefaultGCGracePeriod = 24 * time.Hour // сколько файл должен пробыть без ссылок
+)
+
+// Параметры сборки мусора
+//
+// Переменные структуры:
+//   - Сколько файл должен пробыть без ссылок, чтобы его можно было удалить
+//   - Только показать, что будет удалено, ничего не удаляя
+type GCOptions struct {
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+// Отчёт о сборке мусора
+//
+// Переменные структуры:
+//   - Был ли это пробный запуск
+//   - Сколько файлов в хранилище
+//   - Сколько из них используется
+//   - Файлы без ссылок, для которых ещё не прошёл срок ожидания
+//   - Удалённые файлы (при пробном запуске - те, что были бы удалены)
+//   - Сколько байт освобождено
+type GCReport struct {
+	DryRun     bool     `json:"dry_run"`
+	Scanned    int      `json:"scanned"`
+	Referenced int      `json:"referenced"`
+	Pending    []string `json:"pending"`
+	Deleted    []string `json:"deleted"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+// Функция для получения всех файлов, на которые есть ссылки в БД
+//
+// Учитываются изображения и их копии у фото, рецептов и пользователей.
+// Удалённые рецепты и пользователи, а также фото этапов удалённых
+// рецептов ссылками не считаются (см. LiveStagePhotos).
+// Перед удалением файл перепроверяется через CountFileReferences
+// по тем же правилам
+func (server *Server) ReferencedFiles() (map[string]bool, error) {
+	referenced := map[string]bool{}
+	add := func(filename string, variants map[string]string) {
+		if filename != "" {
+			referenced[filename] = true
+		}
+		for _, variant := range variants {
+			referenced[variant] = true
+		}
+	}
+
+	var photos []models.Photo
+	err := server.DB.Model(&models.Photo{}).
+		Select("photos.id, photos.str_image, photos.photo_variants").
+		Scopes(LiveStagePhotos).
+		Find(&photos).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, photo := range photos {
+		add(photo.StrImage, photo.PhotoVariants)
+	}
+
+	var recipes []models.Recipe
+	err = server.DB.Select("id, str_recipe_image, recipe_image_variants").Find(&recipes).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, recipe := range recipes {
+		add(recipe.StrRecipeImage, recipe.RecipeImageVariants)
+	}
+
+	var users []models.User
+	err = server.DB.Select("id, str_user_image").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		add(user.StrUserImage, nil)
+	}
+
+	return referenced, nil
+}
+
+// Функция для удаления файлов, на которые нет ссылок
+//
+// Время, когда файл впервые оказался без ссылок, запоминается в БД
+// (см. models.OrphanFile и ReleaseImage). Файл удаляется, только если с этого момента
+// и с момента его последнего изменения прошло не меньше options.GracePeriod,
+// поэтому только что загруженные файлы, ещё не сохранённые в БД, не пострадают.
+// При пробном запуске ничего не удаляется и не запоминается
+func (server *Server) CollectGarbage(options GCOptions) (*GCReport, error) {
+	referenced, err := server.ReferencedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := server.Storage.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphanList []models.OrphanFile
+	err = server.DB.Find(&orphanList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := map[string]models.OrphanFile{}
+	for _, orphan := range orphanList {
+		orphans[orphan.StrFileName] = orphan
+	}
+
+	report := &GCReport{DryRun: options.DryRun, Scanned: len(files), Pending: []string{}, Deleted: []string{}}
+	now := time.Now()
+	stored := map[string]bool{}
+
+	for _, file := range files {
+		stored[file.Name] = true
+		orphan, known := orphans[file.Name]
+
+		if referenced[file.Name] {
+			report.Referenced++
+			// На файл снова появилась ссылка
+			if known && !options.DryRun {
+				server.DB.Unscoped().Delete(&orphan)
+			}
+			continue
+		}
+
+		firstSeen := now
+		if known {
+			firstSeen = orphan.CreatedAt
+		} else if !options.DryRun {
+			err = server.DB.Create(&models.OrphanFile{StrFileName: file.Name, IntFileSize: file.Size}).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if now.Sub(firstSeen) < options.GracePeriod || now.Sub(file.ModTime) < options.GracePeriod {
+			report.Pending = append(report.Pending, file.Name)
+			continue
+		}
+
+		if !options.DryRun {
+			// Ссылка могла появиться, пока шла сборка
+			count, err := server.CountFileReferences(file.Name)
+			if err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				report.Referenced++
+				continue
+			}
+
+			// Файл могли загрузить повторно, тогда отметка снята (см. KeepFiles)
+			var orphanCount int64
+			err = server.DB.Model(&models.OrphanFile{}).Where("str_file_name = ?", file.Name).Count(&orphanCount).Error
+			if err != nil {
+				return nil, err
+			}
+			if orphanCount == 0 {
+				report.Pending = append(report.Pending, file.Name)
+				continue
+			}
+
+			err = server.Storage.Delete(file.Name)
+			if err != nil {
+				log.Printf("GC: remove file %s: %s", file.Name, err.Error())
+				continue
+			}
+
+			server.DB.Unscoped().Where("str_file_name = ?", file.Name).Delete(&models.OrphanFile{})
+		}
+
+		report.Deleted = append(report.Deleted, file.Name)
+		report.FreedBytes += file.Size
+	}
+
+	// Забываем файлы, которых уже нет в хранилище
+	if !options.DryRun {
+		for name, orphan := range orphans {
+			if !stored[name] {
+				server.DB.Unscoped().Delete(&orphan)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Функция для запуска сборки мусора в фоне
+//
+// Сборка выполняется раз в server.GCInterval, если он больше нуля
+func (server *Server) StartGarbageCollector() {
+	if server.GCInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(server.GCInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := server.CollectGarbage(GCOptions{GracePeriod: server.GCGracePeriod})
+			if err != nil {
+				log.Printf("GC: %s", err.Error())
+				continue
+			}
+
+			log.Printf(
+				"GC: scanned %d, referenced %d, pending %d, deleted %d (%d bytes)",
+				report.Scanned, report.Referenced, len(report.Pending), len(report.Deleted), report.FreedBytes,
+			)
+		}
+	}()
+}
+
+// Функция для запуска сборки мусора из командной строки
+//
+// Использование: backend gc [-dry-run] [-grace 24h]
+// Отчёт выводится в out в формате JSON
+func (server *Server) RunGCCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "только показать, какие файлы будут удалены")
+	grace := flags.Duration("grace", server.GCGracePeriod, "сколько файл должен пробыть без ссылок")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if server.DB == nil {
+		err = server.ConnectDB()
+		if err != nil {
+			return err
+		}
+
+		// Схему и данные меняет только сервер, здесь нужна лишь
+		// таблица файлов без ссылок, которую ведёт сборщик
+		err = server.DB.AutoMigrate(&models.OrphanFile{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if server.Storage == nil {
+		err = server.InitStorage()
+		if err != nil {
+			return err
+		}
+	}
+
+	report, err := server.CollectGarbage(GCOptions{GracePeriod: *grace, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
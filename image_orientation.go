@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// Значения тега ориентации EXIF
+const (
+	OrientationNormal     = 1 // без изменений
+	OrientationFlipH      = 2 // отражение по горизонтали
+	OrientationRotate180  = 3 // поворот на 180°
+	OrientationFlipV      = 4 // отражение по вертикали
+	OrientationTranspose  = 5 // отражение относительно главной диагонали
+	OrientationRotate90   = 6 // поворот на 90° по часовой стрелке
+	OrientationTransverse = 7 // отражение относительно побочной диагонали
+	OrientationRotate270  = 8 // поворот на 90° против часовой стрелки
+)
+
+// Номер тега ориентации в EXIF
+const exifOrientationTag = 0x0112
+
+// Функция для получения ориентации из EXIF-данных JPEG
+//
+// Просматривает сегменты файла до начала данных изображения и ищет
+// тег ориентации в первом каталоге EXIF. Если тег не найден или файл
+// повреждён, то возвращает OrientationNormal
+func ReadJpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return OrientationNormal
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return OrientationNormal
+		}
+
+		marker := data[pos+1]
+		// Начало данных изображения, дальше метаданных нет
+		if marker == 0xDA || marker == 0xD9 {
+			return OrientationNormal
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return OrientationNormal
+		}
+
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return readTiffOrientation(segment[6:])
+		}
+
+		pos += 2 + length
+	}
+
+	return OrientationNormal
+}
+
+// Функция для получения ориентации из заголовка TIFF внутри EXIF
+func readTiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return OrientationNormal
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return OrientationNormal
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return OrientationNormal
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < OrientationNormal || orientation > OrientationRotate270 {
+				return OrientationNormal
+			}
+			return orientation
+		}
+	}
+
+	return OrientationNormal
+}
+
+// Функция для приведения изображения к нормальной ориентации
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate270 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// При повороте на 90° ширина и высота меняются местами
+	dstWidth, dstHeight := w, h
+	if orientation >= OrientationTranspose {
+		dstWidth, dstHeight = h, w
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case OrientationFlipH:
+				dx, dy = w-1-x, y
+			case OrientationRotate180:
+				dx, dy = w-1-x, h-1-y
+			case OrientationFlipV:
+				dx, dy = x, h-1-y
+			case OrientationTranspose:
+				dx, dy = y, x
+			case OrientationRotate90:
+				dx, dy = h-1-y, x
+			case OrientationTransverse:
+				dx, dy = h-1-y, w-1-x
+			case OrientationRotate270:
+				dx, dy = y, w-1-x
+			}
+
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
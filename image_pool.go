@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Количество обработчиков изображений по умолчанию
+const DefaultImageWorkers = 2
+
+// Сколько задач может ждать обработчика на каждого из них
+const ImageQueuePerWorker = 8
+
+// Ошибка переполнения очереди обработки изображений
+var ErrImagePoolBusy = errors.New("сервер занят обработкой изображений, попробуйте позже")
+
+// Задача на обработку изображения
+type imageJob struct {
+	run  func() error
+	done chan error
+}
+
+// Пул обработчиков изображений
+//
+// Декодирование и кодирование больших изображений занимает много
+// памяти и процессорного времени, поэтому одновременно выполняется
+// не больше заданного количества задач, а остальные ждут в очереди
+// ограниченного размера
+type ImagePool struct {
+	jobs chan imageJob
+}
+
+// Функция для создания пула с заданным количеством обработчиков
+func NewImagePool(workers int) *ImagePool {
+	if workers <= 0 {
+		workers = DefaultImageWorkers
+	}
+
+	pool := &ImagePool{jobs: make(chan imageJob, workers*ImageQueuePerWorker)}
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+
+	return pool
+}
+
+// Функция обработчика: выполняет задачи из очереди по одной
+func (pool *ImagePool) work() {
+	for job := range pool.jobs {
+		job.done <- job.safeRun()
+	}
+}
+
+// Функция для выполнения задачи с перехватом паники
+//
+// Задача выполняется вне обработчика запроса, и паника в декодере
+// на испорченном файле остановила бы весь сервер, поэтому она
+// превращается в ошибку задачи
+func (job imageJob) safeRun() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Image job panic: %v", r)
+			err = fmt.Errorf("не удалось обработать изображение: %v", r)
+		}
+	}()
+
+	return job.run()
+}
+
+// Функция для выполнения задачи в пуле
+//
+// Дожидается окончания задачи и возвращает её ошибку.
+// Если очередь заполнена, то сразу возвращает ErrImagePoolBusy
+func (pool *ImagePool) Run(run func() error) error {
+	job := imageJob{run: run, done: make(chan error, 1)}
+
+	select {
+	case pool.jobs <- job:
+	default:
+		return ErrImagePoolBusy
+	}
+
+	return <-job.done
+}
+
+// Функция для выполнения задачи обработки изображения
+//
+// Если пул не создан, то задача выполняется сразу
+func (server *Server) RunImageJob(run func() error) error {
+	if server.Images == nil {
+		return run()
+	}
+	return server.Images.Run(run)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+)
+
+// Качество сжатия для JPEG и WebP
+const (
+	JpegQuality = 85
+	WebpQuality = 80
+)
+
+// Ограничения на загружаемые изображения по умолчанию
+const (
+	DefaultMaxImageSide   = 8000     // максимальная ширина или высота
+	DefaultMaxImagePixels = 40000000 // максимальное количество пикселей
+)
+
+// Размер производного изображения
+//
+// Переменные структуры:
+//   - Название варианта
+//   - Максимальная ширина
+//   - Максимальная высота
+type ImageSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// Размеры обложки рецепта по умолчанию
+var DefaultCoverSizes = []ImageSize{
+	{Name: "thumbnail", Width: 160, Height: 160},
+	{Name: "card", Width: 480, Height: 360},
+	{Name: "hero", Width: 1600, Height: 900},
+}
+
+// Размеры фото этапов по умолчанию
+var DefaultPhotoSizes = []ImageSize{
+	{Name: "thumbnail", Width: 160, Height: 160},
+	{Name: "large", Width: 1280, Height: 1280},
+}
+
+// Функция для получения наибольшего количества пикселей в изображении
+func (server *Server) maxImagePixels() int {
+	if server.MaxImagePixels <= 0 {
+		return DefaultMaxImagePixels
+	}
+	return server.MaxImagePixels
+}
+
+// Функция для проверки размеров изображения до его декодирования
+//
+// Размеры берутся из заголовка файла, поэтому слишком большие
+// изображения отклоняются раньше, чем под них будет выделена память
+func (server *Server) CheckImageSize(config image.Config) error {
+	maxSide := server.MaxImageSide
+	if maxSide <= 0 {
+		maxSide = DefaultMaxImageSide
+	}
+
+	maxPixels := server.maxImagePixels()
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return errors.New("изображение не содержит данных")
+	}
+
+	if config.Width > maxSide || config.Height > maxSide {
+		return fmt.Errorf("ширина и высота изображения не должны превышать %d пикселей", maxSide)
+	}
+
+	if int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return fmt.Errorf("изображение не должно содержать больше %d пикселей", maxPixels)
+	}
+
+	return nil
+}
+
+// Функция для подсчёта пикселей во всех кадрах GIF без их декодирования
+//
+// Размеры кадров берутся из дескрипторов изображений, данные кадров
+// пропускаются. gif.DecodeAll выделяет память под каждый кадр,
+// поэтому ограничивать нужно сумму, а не только размер экрана
+func GifFramePixels(data []byte) (int64, error) {
+	errBroken := errors.New("повреждённый файл gif")
+
+	// Заголовок и дескриптор логического экрана
+	if len(data) < 13 {
+		return 0, errBroken
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// Пропуск последовательности подблоков до нулевого
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errBroken
+			}
+			size := int(data[pos])
+			pos++
+			if size == 0 {
+				return nil
+			}
+			pos += size
+		}
+	}
+
+	var pixels int64
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // расширение
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2C: // кадр
+			if pos+10 > len(data) {
+				return 0, errBroken
+			}
+			width := int64(data[pos+5]) | int64(data[pos+6])<<8
+			height := int64(data[pos+7]) | int64(data[pos+8])<<8
+			pixels += width * height
+
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+
+			// Минимальный размер кода LZW и данные кадра
+			pos++
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x3B: // конец файла
+			return pixels, nil
+		default:
+			return 0, errBroken
+		}
+	}
+
+	return 0, errBroken
+}
+
+// Функция для очистки загруженного изображения
+//
+// Изображение декодируется и кодируется заново, поэтому в результат
+// не попадают метаданные (EXIF, GPS и прочие). Ориентация из EXIF
+// применяется к самому изображению. GIF перекодируется целиком,
+// чтобы не потерять анимацию
+func (server *Server) SanitizeImage(w io.Writer, data []byte, ext string) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	err = server.CheckImageSize(config)
+	if err != nil {
+		return err
+	}
+
+	if ext == "gif" {
+		// Небольшой GIF может содержать тысячи кадров
+		pixels, err := GifFramePixels(data)
+		if err != nil {
+			return err
+		}
+		if pixels > int64(server.maxImagePixels()) {
+			return fmt.Errorf("кадры анимации не должны содержать больше %d пикселей", server.maxImagePixels())
+		}
+
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		return gif.EncodeAll(w, animation)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if ext == "jpg" {
+		img = ApplyOrientation(img, ReadJpegOrientation(data))
+	}
+
+	return EncodeImage(w, img, ext)
+}
+
+// Функция для уменьшения изображения так, чтобы оно вписалось в размер
+//
+// Пропорции сохраняются, маленькие изображения не увеличиваются
+func ResizeImage(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	if srcWidth <= width && srcHeight <= height {
+		return img
+	}
+
+	// Выбираем меньший из коэффициентов, чтобы вписаться в обе стороны
+	scale := float64(width) / float64(srcWidth)
+	if hScale := float64(height) / float64(srcHeight); hScale < scale {
+		scale = hScale
+	}
+
+	dstWidth := int(float64(srcWidth)*scale + 0.5)
+	dstHeight := int(float64(srcHeight)*scale + 0.5)
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	return dst
+}
+
+// Функция для кодирования изображения в формат по расширению
+func EncodeImage(w io.Writer, img image.Image, ext string) error {
+	switch ext {
+	case "jpg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: JpegQuality})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "webp":
+		return webp.Encode(w, img, &webp.Options{Quality: WebpQuality})
+	}
+
+	return fmt.Errorf("неизвестный формат изображения %s", ext)
+}
+
+// Функция для сохранения изображения в хранилище файлов
+func (server *Server) SaveImage(img image.Image, filename string, ext string) error {
+	var buf bytes.Buffer
+	err := EncodeImage(&buf, img, ext)
+	if err != nil {
+		return err
+	}
+
+	return server.Storage.Put(filename, &buf, int64(buf.Len()), ContentTypeByName(filename))
+}
+
+// Функция для создания уменьшенных копий и WebP-версий изображения
+//
+// Копии сохраняются рядом с оригиналом под именами вида
+// <имя>_<вариант>.<расширение>. Возвращает названия вариантов
+// и имена их файлов. При ошибке созданные копии остаются в хранилище,
+// их нужно освободить вместе с оригиналом (см. ReleaseImage).
+// Обработка выполняется в пуле обработчиков изображений
+func (server *Server) SaveImageVariants(filename string, sizes []ImageSize) (map[string]string, error) {
+	var variants map[string]string
+	err := server.RunImageJob(func() error {
+		var err error
+		variants, err = server.saveImageVariants(filename, sizes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// Функция для получения имён копий изображения
+//
+// Имена зависят только от имени оригинала и названий размеров,
+// поэтому у одинаковых изображений получаются одинаковые копии
+func ImageVariantNames(filename string, sizes []ImageSize) map[string]string {
+	ext := strings.TrimPrefix(path.Ext(filename), ".")
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+
+	variants := map[string]string{"webp": fmt.Sprintf("%s.webp", base)}
+	for _, size := range sizes {
+		variants[size.Name] = fmt.Sprintf("%s_%s.%s", base, size.Name, ext)
+		variants[size.Name+"_webp"] = fmt.Sprintf("%s_%s.webp", base, size.Name)
+	}
+
+	return variants
+}
+
+// Функция для создания копий изображения, см. SaveImageVariants
+//
+// Копии, которые уже есть в хранилище, заново не создаются
+func (server *Server) saveImageVariants(filename string, sizes []ImageSize) (map[string]string, error) {
+	ext := strings.TrimPrefix(path.Ext(filename), ".")
+	variants := ImageVariantNames(filename, sizes)
+
+	missing := map[string]bool{}
+	var stored []string
+	for key, name := range variants {
+		_, err := server.Storage.Stat(name)
+		if err == ErrFileNotFound {
+			missing[key] = true
+		} else if err != nil {
+			return nil, err
+		} else {
+			stored = append(stored, name)
+		}
+	}
+
+	// Уже сохранённые копии снова используются и не должны быть удалены
+	if len(stored) > 0 {
+		server.KeepFiles(stored...)
+	}
+
+	if len(missing) == 0 {
+		return variants, nil
+	}
+
+	src, _, err := server.Storage.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, err
+	}
+
+	save := func(key string, img image.Image, ext string) error {
+		if !missing[key] {
+			return nil
+		}
+		return server.SaveImage(img, variants[key], ext)
+	}
+
+	// WebP-версия оригинала
+	err = save("webp", img, "webp")
+
+	// Уменьшенные копии в исходном формате и в WebP
+	for _, size := range sizes {
+		if err != nil {
+			break
+		}
+
+		if !missing[size.Name] && !missing[size.Name+"_webp"] {
+			continue
+		}
+
+		resized := ResizeImage(img, size.Width, size.Height)
+
+		err = save(size.Name, resized, ext)
+		if err == nil {
+			err = save(size.Name+"_webp", resized, "webp")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/Recipe-book-PetrSU-2022/backend/models"
 	"github.com/labstack/echo/v4"
@@ -22,11 +24,13 @@ func (server *Server) GetIngredients(c echo.Context) error {
 }
 
 type IngredientData struct {
-	Name          string `json:"name"`
-	Colories      int    `json:"colories"`
-	Proteins      int    `json:"proteins"`
-	Fats          int    `json:"fats"`
-	Carbohydrates int    `json:"carbohydrates"`
+	Name          string  `json:"name"`
+	Colories      int     `json:"colories"`
+	Proteins      int     `json:"proteins"`
+	Fats          int     `json:"fats"`
+	Carbohydrates int     `json:"carbohydrates"`
+	Density       float64 `json:"density"`     // плотность, г/мл
+	PieceGrams    float64 `json:"piece_grams"` // вес одной штуки, г
 }
 
 func (server *Server) NewIngredient(c echo.Context) error {
@@ -44,6 +48,13 @@ func (server *Server) NewIngredient(c echo.Context) error {
 		IntProteins:       ingredient_data.Proteins,
 		IntFats:           ingredient_data.Fats,
 		IntCarbohydrates:  ingredient_data.Carbohydrates,
+		FloatDensity:      ingredient_data.Density,
+		FloatPieceGrams:   ingredient_data.PieceGrams,
+	}
+
+	// Если плотность не указана, то считаем её как у воды
+	if ingredient.FloatDensity <= 0 {
+		ingredient.FloatDensity = 1
 	}
 
 	err = server.DB.Create(&ingredient).Error
@@ -57,3 +68,87 @@ func (server *Server) NewIngredient(c echo.Context) error {
 		Id:      ingredient.ID,
 	})
 }
+
+// Функция для изменения ингредиента (только для администратора)
+//
+// После изменения пересчитываются вес ингредиента во всех рецептах
+// и пищевая ценность этих рецептов
+func (server *Server) UpdateIngredientHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	if !IsAdmin(user) {
+		return c.JSON(http.StatusForbidden, &DefaultResponse{Message: "Недостаточно прав"})
+	}
+
+	ingredientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id ингредиента"})
+	}
+
+	var ingredient models.Ingredient
+	err = server.DB.First(&ingredient, "id = ?", ingredientID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Ингредиент не найден"})
+	}
+
+	// Поля, которых нет в запросе, сохраняют прежние значения
+	ingredient_data := IngredientData{
+		Name:          ingredient.StrIngredientName,
+		Colories:      ingredient.IntCalories,
+		Proteins:      ingredient.IntProteins,
+		Fats:          ingredient.IntFats,
+		Carbohydrates: ingredient.IntCarbohydrates,
+		Density:       ingredient.FloatDensity,
+		PieceGrams:    ingredient.FloatPieceGrams,
+	}
+	err = c.Bind(&ingredient_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if len(ingredient_data.Name) != 0 {
+		ingredient.StrIngredientName = ingredient_data.Name
+	}
+	ingredient.IntCalories = ingredient_data.Colories
+	ingredient.IntProteins = ingredient_data.Proteins
+	ingredient.IntFats = ingredient_data.Fats
+	ingredient.IntCarbohydrates = ingredient_data.Carbohydrates
+	ingredient.FloatPieceGrams = ingredient_data.PieceGrams
+	ingredient.FloatDensity = ingredient_data.Density
+	if ingredient.FloatDensity <= 0 {
+		ingredient.FloatDensity = 1
+	}
+
+	err = server.DB.Save(&ingredient).Error
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить ингредиент"})
+	}
+
+	// Пересчитываем вес ингредиента во всех рецептах, где он используется
+	var recipeIngredients []models.RecipeIngredient
+	err = server.DB.Find(&recipeIngredients, "int_ingredient_id = ?", ingredient.ID).Error
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось пересчитать рецепты"})
+	}
+
+	for _, recipeIngredient := range recipeIngredients {
+		grams, err := ConvertToGrams(recipeIngredient.FloatQuantity, recipeIngredient.StrUnit, &ingredient)
+		if err == nil {
+			err = server.DB.Model(&recipeIngredient).UpdateColumn("int_grams", int(math.Round(grams))).Error
+		}
+		if err == nil {
+			err = server.UpdateRecipeNutrition(recipeIngredient.IntRecipeId)
+		}
+		if err != nil {
+			log.Printf("Recalculate recipe %d: %s", recipeIngredient.IntRecipeId, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Ингредиент обновлен"})
+}
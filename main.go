@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -26,13 +28,28 @@ import (
 //   - Информация для подключения
 //   - Объект ORM
 type Server struct {
-	Host             string     // Хост для запуска
-	Port             int        // Порт для запуска
-	E                *echo.Echo // Echo http-сервер
-	DBConnectionInfo string     // Информация для подключения
-	DB               *gorm.DB   // Объект ORM
-	TokenKey         []byte     // ключ подписи токена
-	UploadsPath      string     // путь для загрузки файлов
+	Host                string        // Хост для запуска
+	Port                int           // Порт для запуска
+	E                   *echo.Echo    // Echo http-сервер
+	DBConnectionInfo    string        // Информация для подключения
+	DB                  *gorm.DB      // Объект ORM
+	TokenKey            []byte        // ключ подписи токена
+	UploadsPath         string        // путь для загрузки файлов (для локального хранилища)
+	StorageDriver       string        // драйвер хранилища файлов: local или s3
+	S3                  S3Config      // настройки S3-хранилища
+	PresignExpiry       time.Duration // время жизни подписанных ссылок на файлы
+	Storage             Storage       // хранилище файлов
+	MaxStagePhotos      int           // максимальное количество фото у этапа
+	MaxImageSide        int           // максимальная ширина или высота изображения
+	MaxImagePixels      int           // максимальное количество пикселей изображения
+	CoverSizes          []ImageSize   // размеры копий обложки рецепта
+	PhotoSizes          []ImageSize   // размеры копий фото этапов
+	ImageWorkers        int           // количество обработчиков изображений
+	Images              *ImagePool    // пул обработчиков изображений
+	GCInterval          time.Duration // как часто удалять неиспользуемые файлы
+	GCGracePeriod       time.Duration // сколько файл должен пробыть без ссылок перед удалением
+	UploadBodyLimit     string        // максимальный размер запроса с файлами, например 20M
+	DefaultStorageQuota int64         // квота пользователя на хранение файлов в байтах
 }
 
 // Функция для поднятия сервера
@@ -50,27 +67,24 @@ func (server *Server) Run() error {
 		return err
 	}
 
-	// Автомиграция моделей
-	err = server.DB.AutoMigrate(
-		&models.User{},
-		&models.Filter{},
-		&models.Ingredient{},
-		&models.Recipe{},
-		&models.Stage{},
-		&models.Comment{},
-		&models.Photo{},
-		&models.RecipeIngredient{},
-	)
+	// Миграция БД
+	err = server.Migrate()
 	if err != nil {
 		return err
 	}
 
-	// Создание директорий для хранения файлов
-	err = server.CreateUploadDirs()
+	// Подключение хранилища файлов
+	err = server.InitStorage()
 	if err != nil {
 		return err
 	}
 
+	// Запуск обработчиков изображений
+	server.Images = NewImagePool(server.ImageWorkers)
+
+	// Запуск сборщика неиспользуемых файлов
+	server.StartGarbageCollector()
+
 	// Использование middleware
 	server.E.Use(middleware.Logger())
 	server.E.Use(middleware.Recover())
@@ -80,15 +94,33 @@ func (server *Server) Run() error {
 	}
 	jwtMiddleware := middleware.JWTWithConfig(config)
 
-	server.E.Use(middleware.CORS())
+	// На открытых эндпоинтах токен необязателен,
+	// но если он передан, то ответ учитывает пользователя
+	optionalConfig := config
+	optionalConfig.ContinueOnIgnoredError = true
+	optionalConfig.ErrorHandlerWithContext = func(err error, c echo.Context) error {
+		return nil
+	}
+	optionalJwtMiddleware := middleware.JWTWithConfig(optionalConfig)
+
+	server.E.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		ExposeHeaders: []string{"ETag"}, // версия рецепта или этапа для If-Match
+	}))
 	server.E.GET("/swagger/*", echoSwagger.WrapHandler)
 
 	// Создание групп для применения middleware
 	recipe_group := server.E.Group("/recipe") // от лица кого угодно
 	ingredient_group := server.E.Group("/ingredient")
+	filter_group := server.E.Group("/filter")
 	user_recipe_group := server.E.Group("/my-recipe", jwtMiddleware) // от лица владельца
 	profile_group := server.E.Group("/profile", jwtMiddleware)
+	user_group := server.E.Group("/user", jwtMiddleware)
+	collection_group := server.E.Group("/collection")
+	user_collection_group := server.E.Group("/my-collection", jwtMiddleware)
 	assets_group := server.E.Group("/assets")
+	shopping_list_group := server.E.Group("/shopping-list", jwtMiddleware)
+	meal_plan_group := server.E.Group("/meal-plan", jwtMiddleware)
+	pantry_group := server.E.Group("/pantry", jwtMiddleware)
 
 	// Эндпоинты для регистрации логина
 	server.E.POST("/signin", server.SignInHandle)
@@ -98,24 +130,61 @@ func (server *Server) Run() error {
 	profile_group.GET("", server.ProfileHandle)
 	profile_group.POST("/update", server.ChangeProfileHandle)
 	profile_group.DELETE("/delete", server.DeleteProfileHandle)
+	profile_group.GET("/favorites", server.GetFavoritesHandle)
+
+	// Эндпоинты для администрирования пользователей
+	user_group.POST("/:id/quota", server.SetUserQuotaHandle)
 
 	// Эндпоинты для работы с рецептом
 	user_recipe_group.POST("/add", server.CreateEmptyRecipeHandle)
+	// Устаревший адрес сохранения рецепта, оставлен для старых клиентов.
+	// Рецепт по нему не публикуется, для этого есть /publish/:id
 	user_recipe_group.POST("/complete/:id", server.UpdateRecipeHandle)
+	user_recipe_group.POST("/publish/:id", server.PublishRecipeHandle)
+	user_recipe_group.POST("/archive/:id", server.ArchiveRecipeHandle)
+	user_recipe_group.POST("/unpublish/:id", server.UnpublishRecipeHandle)
 	user_recipe_group.POST("/visible/:id", server.ChangeVisibilityRecipeHandle)
 	user_recipe_group.POST("/change/:id", server.UpdateRecipeHandle)
 	user_recipe_group.DELETE("/delete/:id", server.DeleteRecipeHandle)
-	// user_recipe_group.POST("/upload-cover/:id", server.UploadRecipeCoverHandle)
+	user_recipe_group.POST("/upload-cover/:id", server.UploadRecipeCoverHandle, server.UploadLimitMiddleware())
 	user_recipe_group.GET("/:id", server.GetMyRecipeHandle)
 	user_recipe_group.GET("/all", server.GetMyRecipesHandle)
 
+	// Эндпоинты для работы с версиями рецепта
+	user_recipe_group.GET("/:recipe_id/revisions", server.GetRecipeRevisionsHandle)
+	user_recipe_group.GET("/:recipe_id/revisions/diff", server.DiffRecipeRevisionsHandle)
+	user_recipe_group.GET("/:recipe_id/revisions/:revision", server.GetRecipeRevisionHandle)
+	user_recipe_group.POST("/:recipe_id/revisions/:revision/restore", server.RestoreRecipeRevisionHandle)
+
+	// Эндпоинты для ссылок на рецепт без публикации
+	user_recipe_group.POST("/:recipe_id/shares/add", server.CreateRecipeShareHandle)
+	user_recipe_group.GET("/:recipe_id/shares", server.GetRecipeSharesHandle)
+	user_recipe_group.DELETE("/:recipe_id/shares/:share_id", server.RevokeRecipeShareHandle)
+	server.E.GET("/shared/:token", server.GetSharedRecipeHandle)
+	server.E.GET("/shared/:token/comments", server.GetSharedRecipeCommentsHandle)
+
+	// Эндпоинты для работы с соавторами
+	user_recipe_group.GET("/collaborative", server.GetCollaborativeRecipesHandle)
+	user_recipe_group.GET("/invites", server.GetInvitesHandle)
+	user_recipe_group.POST("/invites/:invite_id/accept", server.AcceptInviteHandle)
+	user_recipe_group.DELETE("/invites/:invite_id/decline", server.DeclineInviteHandle)
+	user_recipe_group.POST("/:recipe_id/collaborators/invite", server.InviteCollaboratorHandle)
+	user_recipe_group.GET("/:recipe_id/collaborators", server.GetCollaboratorsHandle)
+	user_recipe_group.POST("/:recipe_id/collaborators/:user_id/role", server.ChangeCollaboratorRoleHandle)
+	user_recipe_group.DELETE("/:recipe_id/collaborators/:user_id", server.RemoveCollaboratorHandle)
+
 	// Эндпоинты для работы с этапами
 	user_recipe_group.POST("/:recipe_id/stage/add", server.CreateStageHandle)
+	user_recipe_group.POST("/:recipe_id/stage/reorder", server.ReorderStagesHandle)
 	user_recipe_group.POST("/:recipe_id/ingredient/add", server.AddIngredientHandle)
 	user_recipe_group.DELETE("/:recipe_id/ingredient/delete", server.RemoveIngredientHandle)
+	user_recipe_group.GET("/stage/:stage_id", server.GetStageHandle)
 	user_recipe_group.DELETE("/stage/:stage_id/delete", server.DeleteStageHandle)
 	user_recipe_group.POST("/stage/:stage_id/update", server.UpdateStageHandle)
-	// user_recipe_group.POST("/stage/:stage_id/upload-photo", server.AddStagePhotoHandle)
+	user_recipe_group.POST("/stage/:stage_id/upload-photo", server.AddStagePhotoHandle, server.UploadLimitMiddleware())
+	user_recipe_group.POST("/stage/:stage_id/photo/reorder", server.ReorderStagePhotosHandle)
+	user_recipe_group.POST("/photo/:photo_id/update", server.UpdateStagePhotoHandle)
+	user_recipe_group.DELETE("/photo/:photo_id/delete", server.DeleteStagePhotoHandle)
 
 	// Эндпоинты для работы с комментариями
 	recipe_group.GET("/:recipe_id/comment/:comment_id", server.GetCommentHandle)
@@ -124,20 +193,152 @@ func (server *Server) Run() error {
 	recipe_group.DELETE("/:recipe_id/comment/:comment_id/delete", server.DeleteCommentHandle, jwtMiddleware)
 
 	// Эндпоинты для работы с группой рецептов
-	recipe_group.GET("/:id", server.GetRecipeHandle)
-	recipe_group.GET("/all", server.GetRecipesHandle)
-	recipe_group.GET("/find", server.FindRecipesHandle)
+	recipe_group.GET("/:id", server.GetRecipeHandle, optionalJwtMiddleware)
+	recipe_group.GET("/all", server.GetRecipesHandle, optionalJwtMiddleware)
+	recipe_group.GET("/find", server.FindRecipesHandle, optionalJwtMiddleware)
 	recipe_group.POST("/favorite/:id", server.AddRecipeToFavoritesHandle, jwtMiddleware)
+	recipe_group.DELETE("/favorite/:id", server.RemoveRecipeFromFavoritesHandle, jwtMiddleware)
+	recipe_group.POST("/:id/fork", server.ForkRecipeHandle, jwtMiddleware)
+	recipe_group.GET("/:id/forks", server.GetRecipeForksHandle, optionalJwtMiddleware)
+
+	// Эндпоинты для работы с коллекциями
+	user_collection_group.POST("/add", server.CreateCollectionHandle)
+	user_collection_group.GET("/all", server.GetMyCollectionsHandle)
+	user_collection_group.POST("/reorder", server.ReorderCollectionsHandle)
+	user_collection_group.GET("/:id", server.GetMyCollectionHandle)
+	user_collection_group.POST("/:id/update", server.UpdateCollectionHandle)
+	user_collection_group.DELETE("/:id/delete", server.DeleteCollectionHandle)
+	user_collection_group.POST("/:id/recipe/reorder", server.ReorderCollectionRecipesHandle)
+	user_collection_group.POST("/:id/recipe/:recipe_id", server.AddRecipeToCollectionHandle)
+	user_collection_group.DELETE("/:id/recipe/:recipe_id", server.RemoveRecipeFromCollectionHandle)
+	collection_group.GET("/:id", server.GetCollectionHandle)
+
+	// Эндпоинты для работы со списками покупок
+	shopping_list_group.POST("/create", server.CreateShoppingListHandle)
+	shopping_list_group.GET("/all", server.GetShoppingListsHandle)
+	shopping_list_group.GET("/:id", server.GetShoppingListHandle)
+	shopping_list_group.GET("/:id/export", server.ExportShoppingListHandle)
+	shopping_list_group.POST("/:id/item/:item_id", server.CheckShoppingListItemHandle)
+	shopping_list_group.DELETE("/:id/delete", server.DeleteShoppingListHandle)
+
+	// Эндпоинты для работы с планом питания
+	meal_plan_group.POST("/add", server.AddMealPlanEntryHandle)
+	meal_plan_group.GET("/week", server.GetMealPlanWeekHandle)
+	meal_plan_group.POST("/week/shopping-list", server.CreateMealPlanShoppingListHandle)
+	meal_plan_group.POST("/calendar", server.CreateMealPlanCalendarKeyHandle)
+	meal_plan_group.DELETE("/calendar", server.DeleteMealPlanCalendarKeyHandle)
+	meal_plan_group.POST("/:id/update", server.UpdateMealPlanEntryHandle)
+	meal_plan_group.DELETE("/:id/delete", server.DeleteMealPlanEntryHandle)
+	server.E.GET("/meal-plan-calendar/:key", server.GetMealPlanCalendarHandle)
+
+	// Эндпоинты для работы с кладовой
+	pantry_group.GET("/all", server.GetPantryHandle)
+	pantry_group.POST("/add", server.AddPantryItemHandle)
+	pantry_group.GET("/use-it-up", server.UseItUpHandle)
+	pantry_group.POST("/cook/:recipe_id", server.CookRecipeHandle)
+	pantry_group.POST("/:id/update", server.UpdatePantryItemHandle)
+	pantry_group.DELETE("/:id/delete", server.DeletePantryItemHandle)
 
 	ingredient_group.GET("/all", server.GetIngredients)
 	ingredient_group.POST("/create", server.NewIngredient, jwtMiddleware)
+	ingredient_group.POST("/:id/update", server.UpdateIngredientHandle, jwtMiddleware)
+
+	// Эндпоинты для работы с тегами
+	filter_group.GET("/all", server.GetFiltersHandle)
+	filter_group.GET("/categories", server.GetFilterCategoriesHandle)
+	filter_group.GET("/:id/recipes", server.GetFilterRecipesHandle, optionalJwtMiddleware)
+	filter_group.POST("/create", server.CreateFilterHandle, jwtMiddleware)
+	filter_group.POST("/:id/update", server.UpdateFilterHandle, jwtMiddleware)
+	filter_group.DELETE("/:id/delete", server.DeleteFilterHandle, jwtMiddleware)
 
 	// Эндпоинты для работы с файлами
 	assets_group.GET("/:filename", server.DownloadFile)
+	assets_group.GET("/:filename/url", server.GetFileURLHandle)
 
 	return server.E.Start(fmt.Sprintf("%s:%d", server.Host, server.Port))
 }
 
+// Функция для миграции БД
+//
+// Мигрирует модели и заполняет новые поля у старых записей
+func (server *Server) Migrate() error {
+	// До появления черновиков все рецепты считались опубликованными
+	publishExisting := server.DB.Migrator().HasTable(&models.Recipe{}) &&
+		!server.DB.Migrator().HasColumn(&models.Recipe{}, "StrRecipeStatus")
+
+	// Автомиграция моделей
+	err := server.DB.AutoMigrate(
+		&models.User{},
+		&models.Filter{},
+		&models.Ingredient{},
+		&models.Recipe{},
+		&models.Stage{},
+		&models.Comment{},
+		&models.Photo{},
+		&models.RecipeIngredient{},
+		&models.OrphanFile{},
+		&models.RecipeRevision{},
+		&models.Collection{},
+		&models.CollectionRecipe{},
+		&models.ShoppingList{},
+		&models.ShoppingListRecipe{},
+		&models.ShoppingListItem{},
+		&models.MealPlanEntry{},
+		&models.PantryItem{},
+		&models.RecipeShare{},
+		&models.RecipeCollaborator{},
+	)
+	if err != nil {
+		return err
+	}
+
+	if publishExisting {
+		err = server.DB.Model(&models.Recipe{}).
+			Where("str_recipe_name <> ''").
+			UpdateColumn("str_recipe_status", RecipeStatusPublished).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// Раньше имя файла фото было уникальным, теперь файлы
+	// хранятся по хэшу и одно изображение может быть у нескольких фото
+	if server.DB.Migrator().HasIndex(&models.Photo{}, "str_image") {
+		err = server.DB.Migrator().DropIndex(&models.Photo{}, "str_image")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Заполнение количества и единиц измерения у старых ингредиентов рецептов
+	err = server.DB.Model(&models.RecipeIngredient{}).
+		Where("float_quantity = 0 AND int_grams > 0").
+		Updates(map[string]interface{}{"float_quantity": gorm.Expr("int_grams"), "str_unit": UnitGram}).Error
+	if err != nil {
+		return err
+	}
+
+	// Пищевая ценность рецептов, созданных до её появления
+	err = server.BackfillRecipesNutrition()
+	if err != nil {
+		return err
+	}
+
+	// Исходные версии рецептов, созданных до истории изменений
+	err = server.BackfillRecipeRevisions()
+	if err != nil {
+		return err
+	}
+
+	// Перенос избранного в коллекции по умолчанию
+	err = server.MigrateFavoritesToCollections()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Функция для подключения сервера к БД
 func (server *Server) ConnectDB() error {
 	// Соединение с БД
@@ -170,6 +371,7 @@ func main() {
 	mysqlUser := os.Getenv("MYSQL_USER")
 	mysqlPass := os.Getenv("MYSQL_PASS")
 	tokenKey := os.Getenv("TOKEN_KEY")
+	s3UseSSL, _ := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
 
 	server := Server{
 		E:                e,
@@ -178,6 +380,36 @@ func main() {
 		DBConnectionInfo: fmt.Sprintf("%s:%s@tcp(127.0.0.1:3306)/recipe_book?charset=utf8mb4&parseTime=True", mysqlUser, mysqlPass),
 		TokenKey:         []byte(tokenKey),
 		UploadsPath:      "/tmp/recipe_book_uploads/",
+		StorageDriver:    os.Getenv("STORAGE_DRIVER"),
+		S3: S3Config{
+			Endpoint:       os.Getenv("S3_ENDPOINT"),
+			PublicEndpoint: os.Getenv("S3_PUBLIC_ENDPOINT"),
+			AccessKey:      os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:      os.Getenv("S3_SECRET_KEY"),
+			Bucket:         os.Getenv("S3_BUCKET"),
+			Region:         os.Getenv("S3_REGION"),
+			UseSSL:         s3UseSSL,
+		},
+		PresignExpiry:       DefaultPresignExpiry,
+		MaxStagePhotos:      10,
+		MaxImageSide:        DefaultMaxImageSide,
+		MaxImagePixels:      DefaultMaxImagePixels,
+		CoverSizes:          DefaultCoverSizes,
+		PhotoSizes:          DefaultPhotoSizes,
+		ImageWorkers:        DefaultImageWorkers,
+		GCInterval:          DefaultGCInterval,
+		GCGracePeriod:       DefaultGCGracePeriod,
+		UploadBodyLimit:     DefaultUploadBodyLimit,
+		DefaultStorageQuota: DefaultStorageQuota,
+	}
+
+	// Сборка мусора из командной строки: backend gc [-dry-run] [-grace 24h]
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		err := server.RunGCCommand(os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Fatalf("Can't collect garbage: %s", err.Error())
+		}
+		return
 	}
 
 	// Запуск сервера
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Формат даты в плане питания
+const PlanDateLayout = "2006-01-02"
+
+// Длина ключа ссылки на календарь
+const CalendarKeyLength = 32
+
+// За сколько дней назад попадают приёмы пищи в календарь
+const CalendarPastDays = 30
+
+// Приёмы пищи
+const (
+	MealSlotBreakfast = "breakfast" // завтрак
+	MealSlotLunch     = "lunch"     // обед
+	MealSlotSnack     = "snack"     // перекус
+	MealSlotDinner    = "dinner"    // ужин
+)
+
+// Информация о приёме пищи
+//
+// Переменные структуры:
+//   - Название для календаря
+//   - Время начала по умолчанию, часы
+//   - Порядок в течение дня
+type MealSlotInfo struct {
+	Title string
+	Hour  int
+	Order int
+}
+
+// Все поддерживаемые приёмы пищи
+var MealSlots = map[string]MealSlotInfo{
+	MealSlotBreakfast: {"Завтрак", 8, 0},
+	MealSlotLunch:     {"Обед", 13, 1},
+	MealSlotSnack:     {"Перекус", 16, 2},
+	MealSlotDinner:    {"Ужин", 19, 3},
+}
+
+type MealPlanEntryData struct {
+	RecipeId uint   `json:"recipe_id"`
+	Date     string `json:"date"`     // ГГГГ-ММ-ДД
+	Slot     string `json:"slot"`     // breakfast, lunch, snack или dinner
+	Servings int    `json:"servings"` // если не указано, то как в рецепте
+}
+
+// День плана питания
+type MealPlanDay struct {
+	Date      string                 `json:"date"`
+	Entries   []models.MealPlanEntry `json:"entries"`
+	Nutrition models.Nutrition       `json:"nutrition"` // пищевая ценность за день
+}
+
+// Функция для получения понедельника недели, в которую входит день
+func WeekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return time.Date(day.Year(), day.Month(), day.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// Функция для получения начала недели из параметра start
+//
+// Если параметр не указан, то берётся текущая неделя
+func ParseWeekStart(c echo.Context) (time.Time, error) {
+	start := c.QueryParam("start")
+	if start == "" {
+		return WeekStart(time.Now()), nil
+	}
+
+	day, err := time.Parse(PlanDateLayout, start)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return WeekStart(day), nil
+}
+
+// Функция для подсчёта пищевой ценности приёма пищи
+func MealPlanEntryNutrition(entry *models.MealPlanEntry) models.Nutrition {
+	return ScaleNutrition(entry.Recipe.ServingNutrition, float64(entry.IntServings))
+}
+
+// Функция для сложения пищевой ценности
+func AddNutrition(a models.Nutrition, b models.Nutrition) models.Nutrition {
+	return models.Nutrition{
+		FloatCalories:      a.FloatCalories + b.FloatCalories,
+		FloatProteins:      a.FloatProteins + b.FloatProteins,
+		FloatFats:          a.FloatFats + b.FloatFats,
+		FloatCarbohydrates: a.FloatCarbohydrates + b.FloatCarbohydrates,
+	}
+}
+
+// Функция для получения плана питания пользователя с from по to включительно
+//
+// Приёмы пищи упорядочены по дате и времени дня. Удалённые рецепты и рецепты,
+// которые автор скрыл или вернул в черновики, если пользователь не соавтор,
+// не показываются
+func (server *Server) GetMealPlanEntries(user *models.User, from string, to string) ([]models.MealPlanEntry, error) {
+	var entries []models.MealPlanEntry
+
+	err := server.DB.
+		Preload("Recipe.RecipeIngredients.Ingredient").
+		Where("int_user_id = ? AND str_plan_date BETWEEN ? AND ?", user.ID, from, to).
+		Order("str_plan_date, id").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.MealPlanEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Recipe.ID != 0 && server.CanReadRecipe(user, &entry.Recipe) {
+			result = append(result, entry)
+		}
+	}
+
+	// Внутри дня - по порядку приёмов пищи
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].StrPlanDate != result[j].StrPlanDate {
+			return result[i].StrPlanDate < result[j].StrPlanDate
+		}
+		return MealSlots[result[i].StrMealSlot].Order < MealSlots[result[j].StrMealSlot].Order
+	})
+
+	return result, nil
+}
+
+// Функция для проверки данных приёма пищи
+func ValidateMealPlanEntryData(entry_data *MealPlanEntryData) string {
+	if _, err := time.Parse(PlanDateLayout, entry_data.Date); err != nil {
+		return "Неверная дата"
+	}
+	if _, ok := MealSlots[entry_data.Slot]; !ok {
+		return "Неверный приём пищи"
+	}
+	if entry_data.Servings < 0 || entry_data.Servings > MaxScaleServings {
+		return "Неверное количество порций"
+	}
+	return ""
+}
+
+// Функция для добавления рецепта в план питания
+func (server *Server) AddMealPlanEntryHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var entry_data MealPlanEntryData
+	err = c.Bind(&entry_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if message := ValidateMealPlanEntryData(&entry_data); message != "" {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: message})
+	}
+
+	var recipe models.Recipe
+	err = server.DB.First(&recipe, "id = ?", entry_data.RecipeId).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Скрытые рецепты и черновики доступны только автору и соавторам
+	if !server.CanReadRecipe(user, &recipe) {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	entry := models.MealPlanEntry{
+		IntUserId:   user.ID,
+		StrPlanDate: entry_data.Date,
+		StrMealSlot: entry_data.Slot,
+		IntRecipeId: recipe.ID,
+		IntServings: entry_data.Servings,
+	}
+	if entry.IntServings == 0 {
+		entry.IntServings = recipe.IntServings
+	}
+	if entry.IntServings == 0 {
+		entry.IntServings = 1
+	}
+
+	err = server.DB.Omit("Recipe").Create(&entry).Error
+	if err != nil {
+		log.Printf("Create meal plan entry: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось добавить рецепт в план"})
+	}
+
+	return c.JSON(http.StatusOK, &MealPlanEntryResponse{Message: "Рецепт добавлен в план", Id: entry.ID})
+}
+
+// Функция для изменения даты, приёма пищи или количества порций
+func (server *Server) UpdateMealPlanEntryHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	entryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id записи плана"})
+	}
+
+	var entry models.MealPlanEntry
+	err = server.DB.First(&entry, "id = ?", entryID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Запись плана не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец плана
+	if user.ID != entry.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "План питания принадлежит другому пользователю"})
+	}
+
+	// Неуказанные поля не меняются
+	entry_data := MealPlanEntryData{Date: entry.StrPlanDate, Slot: entry.StrMealSlot, Servings: entry.IntServings}
+	err = c.Bind(&entry_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if message := ValidateMealPlanEntryData(&entry_data); message != "" {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: message})
+	}
+	if entry_data.Servings == 0 {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное количество порций"})
+	}
+
+	err = server.DB.Model(&entry).Updates(map[string]interface{}{
+		"str_plan_date": entry_data.Date,
+		"str_meal_slot": entry_data.Slot,
+		"int_servings":  entry_data.Servings,
+	}).Error
+	if err != nil {
+		log.Printf("Update meal plan entry: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить запись плана"})
+	}
+
+	return c.JSON(http.StatusOK, &MealPlanEntryResponse{Message: "Запись плана изменена", Id: entry.ID})
+}
+
+// Функция для удаления рецепта из плана питания
+func (server *Server) DeleteMealPlanEntryHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	entryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id записи плана"})
+	}
+
+	var entry models.MealPlanEntry
+	err = server.DB.First(&entry, "id = ?", entryID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Запись плана не найдена"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец плана
+	if user.ID != entry.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "План питания принадлежит другому пользователю"})
+	}
+
+	err = server.DB.Delete(&entry).Error
+	if err != nil {
+		log.Printf("Delete meal plan entry: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить запись плана"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Запись плана удалена"})
+}
+
+// Функция для получения плана питания на неделю
+//
+// Параметр start - любой день недели (ГГГГ-ММ-ДД), по умолчанию текущая неделя.
+// Для каждого дня и для недели целиком считается пищевая ценность
+func (server *Server) GetMealPlanWeekHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	start, err := ParseWeekStart(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверная дата"})
+	}
+	end := start.AddDate(0, 0, 6)
+
+	entries, err := server.GetMealPlanEntries(user, start.Format(PlanDateLayout), end.Format(PlanDateLayout))
+	if err != nil {
+		log.Printf("Get meal plan: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить план питания"})
+	}
+
+	days := make([]MealPlanDay, 7)
+	index := map[string]int{}
+	for i := range days {
+		days[i].Date = start.AddDate(0, 0, i).Format(PlanDateLayout)
+		days[i].Entries = []models.MealPlanEntry{}
+		index[days[i].Date] = i
+	}
+
+	var total models.Nutrition
+	for _, entry := range entries {
+		day := &days[index[entry.StrPlanDate]]
+		nutrition := MealPlanEntryNutrition(&entry)
+
+		day.Entries = append(day.Entries, entry)
+		day.Nutrition = AddNutrition(day.Nutrition, nutrition)
+		total = AddNutrition(total, nutrition)
+	}
+
+	for i := range days {
+		days[i].Nutrition = RoundNutrition(days[i].Nutrition)
+	}
+
+	return c.JSON(http.StatusOK, &MealPlanWeekResponse{
+		Start:     days[0].Date,
+		End:       days[6].Date,
+		Days:      days,
+		Nutrition: RoundNutrition(total),
+	})
+}
+
+// Функция для составления списка покупок по плану питания на неделю
+func (server *Server) CreateMealPlanShoppingListHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	start, err := ParseWeekStart(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверная дата"})
+	}
+	end := start.AddDate(0, 0, 6)
+
+	entries, err := server.GetMealPlanEntries(user, start.Format(PlanDateLayout), end.Format(PlanDateLayout))
+	if err != nil {
+		log.Printf("Get meal plan: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить план питания"})
+	}
+
+	if len(entries) == 0 {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "План питания на неделю пуст"})
+	}
+
+	list := models.ShoppingList{
+		StrListName: fmt.Sprintf("%s на %s - %s", DefaultShoppingListName, start.Format(PlanDateLayout), end.Format(PlanDateLayout)),
+		IntUserId:   user.ID,
+	}
+
+	shopping_entries := make([]ShoppingEntry, 0, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		shopping_entries = append(shopping_entries, ShoppingEntry{Recipe: &entry.Recipe, Servings: entry.IntServings})
+		list.ListRecipes = append(list.ListRecipes, models.ShoppingListRecipe{
+			IntRecipeId:   entry.IntRecipeId,
+			StrRecipeName: entry.Recipe.StrRecipeName,
+			IntServings:   entry.IntServings,
+		})
+	}
+
+	list.ListItems = AggregateIngredients(shopping_entries, server.GetUnitSystem(c))
+
+	err = server.DB.Create(&list).Error
+	if err != nil {
+		log.Printf("Create shopping list: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать список покупок"})
+	}
+
+	return c.JSON(http.StatusOK, &ShoppingListResponse{Message: "Список покупок создан", Id: list.ID})
+}
+
+// Функция для экранирования текста в iCalendar
+func escapeICS(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return replacer.Replace(text)
+}
+
+// Функция для выгрузки плана питания в формате iCalendar
+//
+// Каждый приём пищи - отдельное событие, время начала берётся
+// по приёму пищи, длительность - время приготовления рецепта
+func FormatMealPlanICS(entries []models.MealPlanEntry, stamp time.Time) string {
+	var builder strings.Builder
+
+	builder.WriteString("BEGIN:VCALENDAR\r\n")
+	builder.WriteString("VERSION:2.0\r\n")
+	builder.WriteString("PRODID:-//Recipe book//Meal plan//RU\r\n")
+	builder.WriteString("CALSCALE:GREGORIAN\r\n")
+	builder.WriteString("X-WR-CALNAME:План питания\r\n")
+
+	for _, entry := range entries {
+		day, err := time.Parse(PlanDateLayout, entry.StrPlanDate)
+		if err != nil {
+			continue
+		}
+		slot := MealSlots[entry.StrMealSlot]
+
+		duration := time.Duration(entry.Recipe.IntTime) * time.Minute
+		if duration <= 0 {
+			duration = 30 * time.Minute
+		}
+		begin := day.Add(time.Duration(slot.Hour) * time.Hour)
+
+		builder.WriteString("BEGIN:VEVENT\r\n")
+		fmt.Fprintf(&builder, "UID:meal-plan-%d@recipe-book\r\n", entry.ID)
+		fmt.Fprintf(&builder, "DTSTAMP:%s\r\n", stamp.UTC().Format("20060102T150405Z"))
+		fmt.Fprintf(&builder, "DTSTART:%s\r\n", begin.Format("20060102T150405"))
+		fmt.Fprintf(&builder, "DTEND:%s\r\n", begin.Add(duration).Format("20060102T150405"))
+		fmt.Fprintf(&builder, "SUMMARY:%s\r\n", escapeICS(fmt.Sprintf("%s: %s", slot.Title, entry.Recipe.StrRecipeName)))
+		fmt.Fprintf(&builder, "DESCRIPTION:%s\r\n", escapeICS(fmt.Sprintf("Порций: %d", entry.IntServings)))
+		builder.WriteString("END:VEVENT\r\n")
+	}
+
+	builder.WriteString("END:VCALENDAR\r\n")
+
+	return builder.String()
+}
+
+// Функция для создания новой ссылки на календарь плана питания
+//
+// Старая ссылка перестаёт работать
+func (server *Server) CreateMealPlanCalendarKeyHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	key := RandomString(CalendarKeyLength)
+	err = server.DB.Model(user).Update("str_calendar_key", key).Error
+	if err != nil {
+		log.Printf("Update calendar key: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать ссылку на календарь"})
+	}
+
+	return c.JSON(http.StatusOK, &CalendarLinkResponse{Message: "Ссылка на календарь создана", URL: fmt.Sprintf("/meal-plan-calendar/%s.ics", key)})
+}
+
+// Функция для отключения ссылки на календарь плана питания
+func (server *Server) DeleteMealPlanCalendarKeyHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	err = server.DB.Model(user).Update("str_calendar_key", "").Error
+	if err != nil {
+		log.Printf("Update calendar key: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось отключить ссылку на календарь"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Ссылка на календарь отключена"})
+}
+
+// Функция для получения календаря плана питания по секретной ссылке
+//
+// Вход не нужен, чтобы календарь можно было подключить в сторонних
+// приложениях. В календарь попадают приёмы пищи начиная с месяца назад
+func (server *Server) GetMealPlanCalendarHandle(c echo.Context) error {
+	key := strings.TrimSuffix(c.Param("key"), ".ics")
+	if len(key) != CalendarKeyLength {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Календарь не найден"})
+	}
+
+	var user models.User
+	err := server.DB.First(&user, "str_calendar_key = ?", key).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Printf("Get calendar user: %s", err.Error())
+		}
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Календарь не найден"})
+	}
+
+	now := time.Now()
+	from := now.AddDate(0, 0, -CalendarPastDays).Format(PlanDateLayout)
+	entries, err := server.GetMealPlanEntries(&user, from, "9999-12-31")
+	if err != nil {
+		log.Printf("Get meal plan: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить план питания"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, no-cache")
+	return c.Blob(http.StatusOK, "text/calendar; charset=UTF-8", []byte(FormatMealPlanICS(entries, now)))
+}
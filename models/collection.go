@@ -0,0 +1,28 @@
+package models
+
+import "gorm.io/gorm"
+
+// Коллекция рецептов (личная кулинарная книга)
+type Collection struct {
+	gorm.Model
+
+	StrCollectionName     string             `gorm:"not null"`
+	StrCollectionDesc     string             `gorm:"not null"`
+	BoolCollectionPublic  bool               `gorm:"not null;default:false"` // видна всем по ссылке
+	BoolCollectionDefault bool               `gorm:"not null;default:false"` // коллекция по умолчанию, её нельзя удалить
+	IntCollectionOrder    int                `gorm:"not null;default:0"`     // позиция среди коллекций пользователя
+	IntUserId             uint               `gorm:"not null;index"`
+	User                  User               `gorm:"foreignKey:IntUserId" json:"-"`
+	CollectionRecipes     []CollectionRecipe `gorm:"foreignKey:IntCollectionId"`
+}
+
+// Рецепт в коллекции
+type CollectionRecipe struct {
+	gorm.Model
+
+	IntCollectionId uint       `gorm:"not null;uniqueIndex:idx_collection_recipe"`
+	Collection      Collection `gorm:"foreignKey:IntCollectionId" json:"-"`
+	IntRecipeId     uint       `gorm:"not null;uniqueIndex:idx_collection_recipe"`
+	Recipe          Recipe     `gorm:"foreignKey:IntRecipeId"`
+	IntRecipeOrder  int        `gorm:"not null;default:0"` // позиция рецепта в коллекции
+}
@@ -2,8 +2,26 @@ package models
 
 import "gorm.io/gorm"
 
+// Категории тегов
+const (
+	FilterCategoryDiet     = "diet"     // Диета
+	FilterCategoryCuisine  = "cuisine"  // Кухня
+	FilterCategoryMeal     = "meal"     // Приём пищи
+	FilterCategoryOccasion = "occasion" // Повод
+)
+
+// Список допустимых категорий тегов
+var FilterCategories = []string{
+	FilterCategoryDiet,
+	FilterCategoryCuisine,
+	FilterCategoryMeal,
+	FilterCategoryOccasion,
+}
+
 type Filter struct {
 	gorm.Model
 
-	StrFilterName string `gorm:"index;not null"`
+	StrFilterName     string   `gorm:"index:idx_filter_name,unique;not null"`
+	StrFilterCategory string   `gorm:"index:idx_filter_name,unique;not null"`
+	FilterRecipes     []Recipe `gorm:"many2many:recipe_filters" json:"-"`
 }
@@ -10,5 +10,7 @@ type Ingredient struct {
 	IntProteins       int                `gorm:"not null;default:0"`
 	IntFats           int                `gorm:"not null;default:0"`
 	IntCarbohydrates  int                `gorm:"not null;default:0"`
+	FloatDensity      float64            `gorm:"not null;default:1"` // плотность, г/мл
+	FloatPieceGrams   float64            `gorm:"not null;default:0"` // вес одной штуки, г
 	RecipeIngredients []RecipeIngredient `gorm:"foreignKey:IntIngredientId" json:"-"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+// Рецепт в плане питания на определённый день и приём пищи
+type MealPlanEntry struct {
+	gorm.Model
+
+	IntUserId   uint   `gorm:"not null;index:idx_meal_plan_user_date"`
+	User        User   `gorm:"foreignKey:IntUserId" json:"-"`
+	StrPlanDate string `gorm:"not null;size:10;index:idx_meal_plan_user_date"` // дата в формате ГГГГ-ММ-ДД
+	StrMealSlot string `gorm:"not null"`                                       // приём пищи
+	IntRecipeId uint   `gorm:"not null;index"`
+	Recipe      Recipe `gorm:"foreignKey:IntRecipeId"`
+	IntServings int    `gorm:"not null;default:1"`
+}
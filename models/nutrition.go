@@ -0,0 +1,9 @@
+package models
+
+// Пищевая ценность: калории, белки, жиры и углеводы
+type Nutrition struct {
+	FloatCalories      float64 `gorm:"not null;default:0"`
+	FloatProteins      float64 `gorm:"not null;default:0"`
+	FloatFats          float64 `gorm:"not null;default:0"`
+	FloatCarbohydrates float64 `gorm:"not null;default:0"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "gorm.io/gorm"
+
+// Файл в хранилище, на который не ссылается ни одна запись
+//
+// CreatedAt - время, когда файл освободили (см. ReleaseImage) или сборщик
+// мусора впервые увидел его без ссылок. Запись удаляется, когда на файл
+// снова появляется ссылка или его загружают повторно
+type OrphanFile struct {
+	gorm.Model
+
+	StrFileName string `gorm:"uniqueIndex;size:255;not null"`
+	IntFileSize int64  `gorm:"not null;default:0"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "gorm.io/gorm"
+
+// Продукт в кладовой пользователя
+type PantryItem struct {
+	gorm.Model
+
+	IntUserId       uint       `gorm:"not null;index"`
+	User            User       `gorm:"foreignKey:IntUserId" json:"-"`
+	IntIngredientId uint       `gorm:"not null;index"`
+	Ingredient      Ingredient `gorm:"foreignKey:IntIngredientId"`
+	FloatQuantity   float64    `gorm:"not null;default:0"` // количество в единицах StrUnit
+	StrUnit         string     `gorm:"not null;default:g"`
+	FloatGrams      float64    `gorm:"not null;default:0"`          // вес, г
+	StrExpiresAt    string     `gorm:"not null;size:10;default:''"` // срок годности ГГГГ-ММ-ДД, пусто - без срока
+}
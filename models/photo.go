@@ -5,7 +5,11 @@ import "gorm.io/gorm"
 type Photo struct {
 	gorm.Model
 
-	StrImage   string `gorm:"unique;not null"`
-	IntStageId uint   `gorm:"not null"`
-	Stage      Stage  `gorm:"foreignKey:IntStageId" json:"-"`
+	StrImage      string            `gorm:"index;not null"`            // одно изображение может быть у нескольких фото
+	PhotoVariants map[string]string `gorm:"serializer:json;type:text"` // уменьшенные копии и WebP-версии
+	IntImageSize  int64             `gorm:"not null;default:0"`        // размер изображения вместе с копиями
+	StrCaption    string            `gorm:"not null"`
+	IntPhotoOrder int               `gorm:"not null;default:0"` // позиция фото у этапа
+	IntStageId    uint              `gorm:"not null"`
+	Stage         Stage             `gorm:"foreignKey:IntStageId" json:"-"`
 }
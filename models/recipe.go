@@ -10,11 +10,23 @@ type Recipe struct {
 	IntTime              int                `gorm:"not null;default:0"`
 	StrRecipeCountry     string             `gorm:"not null"`
 	StrRecipeType        string             `gorm:"not null"`
-	StrRecipeImage       string             `gorm:"not null"`
+	StrRecipeImage       string             `gorm:"index;not null"`
+	RecipeImageVariants  map[string]string  `gorm:"serializer:json;type:text"`
+	IntRecipeImageSize   int64              `gorm:"not null;default:0"` // размер обложки вместе с копиями
 	BoolRecipeVisibility bool               `gorm:"not null"`
+	StrRecipeStatus      string             `gorm:"index;not null;default:draft"` // черновик, опубликован или в архиве
+	IntVersion           int                `gorm:"not null;default:1"`           // номер версии для проверки одновременных изменений
 	IntUserId            uint               `gorm:"not null"`
+	IntForkedFromId      *uint              `gorm:"index"`              // рецепт, копией которого является этот
+	IntForkedFromUserId  *uint              `gorm:"index"`              // автор исходного рецепта
+	IntForkCount         int                `gorm:"not null;default:0"` // сколько раз рецепт скопировали
+	IntFavoriteCount     int64              `gorm:"-"`                  // сколько пользователей добавили рецепт в избранное
+	BoolFavorite         bool               `gorm:"-"`                  // рецепт в избранном у текущего пользователя
 	User                 User               `gorm:"foreignKey:IntUserId"`
 	RecipeStages         []Stage            `gorm:"foreignKey:IntRecipeId"`
 	RecipeComments       []Comment          `gorm:"foreignKey:IntRecipeId"`
 	RecipeIngredients    []RecipeIngredient `gorm:"foreignKey:IntRecipeId"`
+	RecipeFilters        []Filter           `gorm:"many2many:recipe_filters"`
+	TotalNutrition       Nutrition          `gorm:"embedded;embeddedPrefix:total_"`
+	ServingNutrition     Nutrition          `gorm:"embedded;embeddedPrefix:serving_"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import "gorm.io/gorm"
+
+// Соавтор рецепта
+//
+// Приглашение действует после того, как приглашённый его примет
+type RecipeCollaborator struct {
+	gorm.Model
+
+	IntRecipeId    uint    `gorm:"not null;uniqueIndex:idx_recipe_collaborator"`
+	Recipe         *Recipe `gorm:"foreignKey:IntRecipeId" json:",omitempty"`
+	IntUserId      uint    `gorm:"not null;uniqueIndex:idx_recipe_collaborator;index"`
+	User           *User   `gorm:"foreignKey:IntUserId" json:",omitempty"`
+	StrRole        string  `gorm:"not null"`               // роль: editor или viewer
+	BoolAccepted   bool    `gorm:"not null;default:false"` // приглашение принято
+	IntInvitedById uint    `gorm:"not null"`               // кто пригласил
+}
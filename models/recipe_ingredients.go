@@ -6,6 +6,8 @@ type RecipeIngredient struct {
 	gorm.Model
 
 	IntGrams        int        `gorm:"not null;default:0"`
+	FloatQuantity   float64    `gorm:"not null;default:0"`
+	StrUnit         string     `gorm:"not null;default:g"`
 	IntRecipeId     uint       `gorm:"not null;index:idx_recipe_ingr,unique"`
 	Recipe          Recipe     `gorm:"foreignKey:IntRecipeId" json:"-"`
 	IntIngredientId uint       `gorm:"not null;index:idx_recipe_ingr,unique"`
@@ -0,0 +1,50 @@
+package models
+
+import "gorm.io/gorm"
+
+// Версия рецепта - снимок его состояния после очередного сохранения
+type RecipeRevision struct {
+	gorm.Model
+
+	IntRecipeId uint            `gorm:"not null;index:idx_recipe_revision,unique"`
+	IntRevision int             `gorm:"not null;index:idx_recipe_revision,unique"` // номер версии в рецепте, с единицы
+	IntUserId   uint            `gorm:"not null"`                                  // кто сохранил версию
+	StrMessage  string          `gorm:"not null"`                                  // что было изменено
+	Snapshot    *RecipeSnapshot `gorm:"serializer:json;type:text" json:",omitempty"`
+}
+
+// Снимок рецепта: описание, этапы и ингредиенты
+type RecipeSnapshot struct {
+	Name        string               `json:"name"`
+	Servings    int                  `json:"servings"`
+	Time        int                  `json:"time"`
+	Country     string               `json:"country"`
+	Type        string               `json:"type"`
+	Tags        []uint               `json:"tags"`
+	Stages      []StageSnapshot      `json:"stages"`
+	Ingredients []IngredientSnapshot `json:"ingredients"`
+}
+
+// Снимок этапа рецепта
+type StageSnapshot struct {
+	Id          uint            `json:"id"` // ID этапа на момент снимка
+	Description string          `json:"description"`
+	Photos      []PhotoSnapshot `json:"photos,omitempty"` // фото, чтобы вернуть их вместе с удалённым этапом
+}
+
+// Снимок фото этапа: ссылка на файл и подпись
+type PhotoSnapshot struct {
+	Image    string            `json:"image"`
+	Variants map[string]string `json:"variants,omitempty"`
+	Size     int64             `json:"size"`
+	Caption  string            `json:"caption"`
+}
+
+// Снимок ингредиента рецепта
+type IngredientSnapshot struct {
+	IngredientId uint    `json:"ingredient_id"`
+	Name         string  `json:"name"`
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit"`
+	Grams        int     `json:"grams"`
+}
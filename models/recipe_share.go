@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Ссылка для просмотра рецепта без публикации
+//
+// Даёт доступ только на чтение к одному рецепту, его комментариям
+// и изображениям. Отозванная ссылка удаляется
+type RecipeShare struct {
+	gorm.Model
+
+	IntRecipeId   uint       `gorm:"not null;index"`
+	Recipe        Recipe     `gorm:"foreignKey:IntRecipeId" json:"-"`
+	StrShareToken string     `gorm:"uniqueIndex;size:64;not null"`
+	TimeExpiresAt *time.Time // nil - ссылка бессрочная
+}
@@ -0,0 +1,37 @@
+package models
+
+import "gorm.io/gorm"
+
+// Список покупок
+type ShoppingList struct {
+	gorm.Model
+
+	StrListName string               `gorm:"not null"`
+	IntUserId   uint                 `gorm:"not null;index"`
+	User        User                 `gorm:"foreignKey:IntUserId" json:"-"`
+	ListRecipes []ShoppingListRecipe `gorm:"foreignKey:IntShoppingListId"`
+	ListItems   []ShoppingListItem   `gorm:"foreignKey:IntShoppingListId"`
+}
+
+// Рецепт, по которому составлен список покупок
+type ShoppingListRecipe struct {
+	gorm.Model
+
+	IntShoppingListId uint   `gorm:"not null;index"`
+	IntRecipeId       uint   `gorm:"not null"`
+	StrRecipeName     string `gorm:"not null"` // название на момент составления списка
+	IntServings       int    `gorm:"not null"` // на сколько порций нужны продукты
+}
+
+// Позиция списка покупок
+type ShoppingListItem struct {
+	gorm.Model
+
+	IntShoppingListId uint    `gorm:"not null;index"`
+	IntIngredientId   uint    `gorm:"not null"`
+	StrItemName       string  `gorm:"not null"`
+	FloatQuantity     float64 `gorm:"not null;default:0"` // количество в единицах StrUnit
+	StrUnit           string  `gorm:"not null"`
+	FloatGrams        float64 `gorm:"not null;default:0"`     // вес, г
+	BoolItemChecked   bool    `gorm:"not null;default:false"` // куплено
+}
@@ -5,8 +5,10 @@ import "gorm.io/gorm"
 type Stage struct {
 	gorm.Model
 
-	StrStageDesc string  `gorm:"not null"`
-	IntRecipeId  uint    `gorm:"not null"`
-	Recipe       Recipe  `gorm:"foreignKey:IntRecipeId" json:"-"`
-	StagePhotos  []Photo `gorm:"foreignKey:IntStageId"`
+	StrStageDesc  string  `gorm:"not null"`
+	IntStageOrder int     `gorm:"not null;default:0;index"` // позиция этапа в рецепте
+	IntRecipeId   uint    `gorm:"not null"`
+	IntVersion    int     `gorm:"not null;default:1"` // номер версии для проверки одновременных изменений
+	Recipe        Recipe  `gorm:"foreignKey:IntRecipeId" json:"-"`
+	StagePhotos   []Photo `gorm:"foreignKey:IntStageId"`
 }
@@ -9,7 +9,11 @@ type User struct {
 	StrUserPassword string    `gorm:"not null" json:"-"`
 	StrUserEmail    string    `gorm:"index;unique;not null" json:"-"`
 	IntUserRights   int       `gorm:"not null;default:0" json:"-"`
-	StrUserImage    string    `gorm:"not null"`
+	StrUserImage    string    `gorm:"index;not null"`
+	StrUnitSystem   string    `gorm:"not null;default:metric"`
+	IntStorageQuota int64     `gorm:"not null;default:0" json:"-"` // квота в байтах: 0 - по умолчанию, -1 - без ограничений
+	IntStorageUsed  int64     `gorm:"not null;default:0" json:"-"` // занято байт загруженными файлами
+	StrCalendarKey  string    `gorm:"index" json:"-"`              // ключ ссылки на календарь плана питания
 	UserRecipes     []Recipe  `gorm:"foreignKey:IntUserId" json:"-"`
 	UserComments    []Comment `gorm:"foreignKey:IntUserId" json:"-"`
 	UserFavorite    []Recipe  `gorm:"many2many:user_favorite_recipes" json:"-"`
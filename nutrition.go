@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"gorm.io/gorm"
+)
+
+// Функция для округления значения до одного знака после запятой
+func RoundNutritionValue(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// Функция для округления всех значений пищевой ценности
+func RoundNutrition(nutrition models.Nutrition) models.Nutrition {
+	return models.Nutrition{
+		FloatCalories:      RoundNutritionValue(nutrition.FloatCalories),
+		FloatProteins:      RoundNutritionValue(nutrition.FloatProteins),
+		FloatFats:          RoundNutritionValue(nutrition.FloatFats),
+		FloatCarbohydrates: RoundNutritionValue(nutrition.FloatCarbohydrates),
+	}
+}
+
+// Функция для умножения пищевой ценности на коэффициент
+func ScaleNutrition(nutrition models.Nutrition, factor float64) models.Nutrition {
+	return models.Nutrition{
+		FloatCalories:      nutrition.FloatCalories * factor,
+		FloatProteins:      nutrition.FloatProteins * factor,
+		FloatFats:          nutrition.FloatFats * factor,
+		FloatCarbohydrates: nutrition.FloatCarbohydrates * factor,
+	}
+}
+
+// Функция для подсчёта пищевой ценности ингредиентов рецепта
+//
+// Значения ингредиентов считаются заданными на 100 грамм
+func CalcIngredientsNutrition(recipeIngredients []models.RecipeIngredient) models.Nutrition {
+	var total models.Nutrition
+
+	for _, recipeIngredient := range recipeIngredients {
+		factor := float64(recipeIngredient.IntGrams) / 100
+		ingredient := recipeIngredient.Ingredient
+
+		total.FloatCalories += float64(ingredient.IntCalories) * factor
+		total.FloatProteins += float64(ingredient.IntProteins) * factor
+		total.FloatFats += float64(ingredient.IntFats) * factor
+		total.FloatCarbohydrates += float64(ingredient.IntCarbohydrates) * factor
+	}
+
+	return total
+}
+
+// Функция для подсчёта пищевой ценности рецепта целиком и на одну порцию
+//
+// Если количество порций не задано, то на порцию приходится весь рецепт
+func CalcRecipeNutrition(recipeIngredients []models.RecipeIngredient, servings int) (models.Nutrition, models.Nutrition) {
+	total := CalcIngredientsNutrition(recipeIngredients)
+
+	serving := total
+	if servings > 0 {
+		serving = ScaleNutrition(total, 1/float64(servings))
+	}
+
+	return RoundNutrition(total), RoundNutrition(serving)
+}
+
+// Функция для пересчёта сохранённой пищевой ценности рецепта
+//
+// Ингредиенты рецепта берутся из БД, поэтому вызывать её нужно
+// после любого изменения ингредиентов или количества порций
+func (server *Server) UpdateRecipeNutrition(recipeID uint) error {
+	var recipe models.Recipe
+	err := server.DB.
+		Preload("RecipeIngredients").
+		Preload("RecipeIngredients.Ingredient").
+		First(&recipe, "id = ?", recipeID).Error
+	if err != nil {
+		return err
+	}
+
+	total, serving := CalcRecipeNutrition(recipe.RecipeIngredients, recipe.IntServings)
+
+	return server.DB.Model(&recipe).UpdateColumns(map[string]interface{}{
+		"total_float_calories":        total.FloatCalories,
+		"total_float_proteins":        total.FloatProteins,
+		"total_float_fats":            total.FloatFats,
+		"total_float_carbohydrates":   total.FloatCarbohydrates,
+		"serving_float_calories":      serving.FloatCalories,
+		"serving_float_proteins":      serving.FloatProteins,
+		"serving_float_fats":          serving.FloatFats,
+		"serving_float_carbohydrates": serving.FloatCarbohydrates,
+	}).Error
+}
+
+// Функция для заполнения пищевой ценности рецептов, созданных до её появления
+//
+// Пересчитываются только рецепты с ингредиентами, у которых пищевая
+// ценность ещё не заполнена, поэтому при следующих запусках
+// уже посчитанные рецепты не трогаются
+func (server *Server) BackfillRecipesNutrition() error {
+	var recipeIDs []uint
+	err := server.DB.Model(&models.Recipe{}).
+		Where("total_float_calories = 0 AND total_float_proteins = 0 AND total_float_fats = 0 AND total_float_carbohydrates = 0").
+		Where("EXISTS (SELECT 1 FROM recipe_ingredients WHERE recipe_ingredients.int_recipe_id = recipes.id AND recipe_ingredients.deleted_at IS NULL)").
+		Pluck("id", &recipeIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, recipeID := range recipeIDs {
+		err = server.UpdateRecipeNutrition(recipeID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Диапазон значений для поиска, границы необязательны
+type NutritionRange struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// Диапазоны пищевой ценности на одну порцию для поиска
+type NutritionFilter struct {
+	Calories      NutritionRange `json:"calories"`
+	Proteins      NutritionRange `json:"proteins"`
+	Fats          NutritionRange `json:"fats"`
+	Carbohydrates NutritionRange `json:"carbohydrates"`
+}
+
+// Функция для проверки, что не задано ни одной границы
+func (filter *NutritionFilter) IsEmpty() bool {
+	for _, r := range []NutritionRange{filter.Calories, filter.Proteins, filter.Fats, filter.Carbohydrates} {
+		if r.Min != nil || r.Max != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Функция для отбора рецептов по пищевой ценности на порцию
+func FilterRecipesByNutrition(db *gorm.DB, filter NutritionFilter) *gorm.DB {
+	ranges := map[string]NutritionRange{
+		"serving_float_calories":      filter.Calories,
+		"serving_float_proteins":      filter.Proteins,
+		"serving_float_fats":          filter.Fats,
+		"serving_float_carbohydrates": filter.Carbohydrates,
+	}
+
+	for column, r := range ranges {
+		if r.Min != nil {
+			db = db.Where(column+" >= ?", *r.Min)
+		}
+		if r.Max != nil {
+			db = db.Where(column+" <= ?", *r.Max)
+		}
+	}
+
+	return db
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Сколько дней до окончания срока годности считаются "скоро"
+const (
+	DefaultExpiringDays = 3
+	MaxExpiringDays     = 365
+)
+
+// Сколько рецептов предлагать, чтобы использовать продукты
+const MaxUseItUpRecipes = 20
+
+type PantryItemData struct {
+	IngredientId uint    `json:"ingredient_id"`
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit"`
+	Expires      string  `json:"expires"` // ГГГГ-ММ-ДД, пусто - без срока
+}
+
+type CookData struct {
+	Servings int `json:"servings"` // если не указано, то как в рецепте
+}
+
+// Ингредиент, которого не хватило в кладовой
+type MissingIngredient struct {
+	IngredientId uint    `json:"ingredient_id"`
+	Name         string  `json:"name"`
+	Grams        float64 `json:"grams"` // сколько не хватило, г
+}
+
+// Рецепт, в котором можно использовать продукты из кладовой
+type UseItUpRecipe struct {
+	Recipe   models.Recipe `json:"recipe"`
+	Expiring []string      `json:"expiring"` // продукты, срок которых скоро истекает
+	Matched  int           `json:"matched"`  // сколько ингредиентов есть в кладовой
+	Missing  int           `json:"missing"`  // сколько ингредиентов нужно докупить
+}
+
+// Функция для сортировки продуктов кладовой: сначала те, что испортятся раньше
+func OrderPantryItems(db *gorm.DB) *gorm.DB {
+	return db.Order("CASE WHEN str_expires_at = '' THEN 1 ELSE 0 END, str_expires_at, id")
+}
+
+// Функция для получения даты через days дней от сегодняшней
+func DaysFromToday(days int) string {
+	return time.Now().AddDate(0, 0, days).Format(PlanDateLayout)
+}
+
+// Функция для проверки данных продукта и подсчёта его веса
+//
+// Возвращает сообщение об ошибке или пустую строку
+func (server *Server) preparePantryItem(item *models.PantryItem, item_data *PantryItemData) string {
+	if item_data.Expires != "" {
+		if _, err := time.Parse(PlanDateLayout, item_data.Expires); err != nil {
+			return "Неверный срок годности"
+		}
+	}
+
+	if item_data.Quantity <= 0 {
+		return "Количество должно быть больше нуля"
+	}
+
+	var ingredient models.Ingredient
+	err := server.DB.First(&ingredient, "id = ?", item_data.IngredientId).Error
+	if err != nil {
+		return "Не удалось найти ингредиент"
+	}
+
+	grams, err := ConvertToGrams(item_data.Quantity, item_data.Unit, &ingredient)
+	if err != nil {
+		return fmt.Sprintf("Не удалось перевести количество в граммы: %s", err.Error())
+	}
+
+	item.IntIngredientId = ingredient.ID
+	item.FloatQuantity = item_data.Quantity
+	item.StrUnit = item_data.Unit
+	item.FloatGrams = grams
+	item.StrExpiresAt = item_data.Expires
+
+	return ""
+}
+
+// Функция для списания продуктов, потраченных на рецепт
+//
+// Первыми списываются продукты с ближайшим сроком годности.
+// Возвращает ингредиенты, которых в кладовой не хватило
+func (server *Server) CookFromPantry(userID uint, recipe *models.Recipe, servings int) ([]MissingIngredient, error) {
+	factor := 1.0
+	if recipe.IntServings > 0 {
+		factor = float64(servings) / float64(recipe.IntServings)
+	}
+
+	missing := []MissingIngredient{}
+	err := server.DB.Transaction(func(tx *gorm.DB) error {
+		for _, recipeIngredient := range recipe.RecipeIngredients {
+			need := float64(recipeIngredient.IntGrams) * factor
+			if need <= 0 {
+				continue
+			}
+
+			var items []models.PantryItem
+			err := OrderPantryItems(tx).
+				Find(&items, "int_user_id = ? AND int_ingredient_id = ?", userID, recipeIngredient.IntIngredientId).Error
+			if err != nil {
+				return err
+			}
+
+			for i := range items {
+				if need <= 0 {
+					break
+				}
+				item := &items[i]
+
+				if item.FloatGrams <= need {
+					need -= item.FloatGrams
+					err = tx.Delete(item).Error
+				} else {
+					// Количество уменьшается в тех же единицах, в которых продукт добавлен
+					rest := item.FloatGrams - need
+					err = tx.Model(item).Updates(map[string]interface{}{
+						"float_grams":    rest,
+						"float_quantity": item.FloatQuantity * rest / item.FloatGrams,
+					}).Error
+					need = 0
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if need > 0 {
+				missing = append(missing, MissingIngredient{
+					IngredientId: recipeIngredient.IntIngredientId,
+					Name:         recipeIngredient.Ingredient.StrIngredientName,
+					Grams:        RoundGrams(need),
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return missing, nil
+}
+
+// Функция для получения продуктов кладовой
+//
+// С параметром expiring=N показываются только продукты,
+// срок годности которых истекает в ближайшие N дней или уже истёк
+func (server *Server) GetPantryHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	query := OrderPantryItems(server.DB.Preload("Ingredient")).Where("int_user_id = ?", user.ID)
+
+	if value := c.QueryParam("expiring"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 || days > MaxExpiringDays {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное количество дней"})
+		}
+		query = query.Where("str_expires_at <> '' AND str_expires_at <= ?", DaysFromToday(days))
+	}
+
+	items := []models.PantryItem{}
+	err = query.Find(&items).Error
+	if err != nil {
+		log.Printf("Get pantry: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить продукты"})
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+// Функция для добавления продукта в кладовую
+func (server *Server) AddPantryItemHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var item_data PantryItemData
+	err = c.Bind(&item_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	item := models.PantryItem{IntUserId: user.ID}
+	message := server.preparePantryItem(&item, &item_data)
+	if message != "" {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: message})
+	}
+
+	err = server.DB.Omit("Ingredient").Create(&item).Error
+	if err != nil {
+		log.Printf("Create pantry item: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось добавить продукт"})
+	}
+
+	return c.JSON(http.StatusOK, &PantryItemResponse{Message: "Продукт добавлен", Id: item.ID})
+}
+
+// Функция для изменения количества или срока годности продукта
+func (server *Server) UpdatePantryItemHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	itemID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id продукта"})
+	}
+
+	var item models.PantryItem
+	err = server.DB.First(&item, "id = ?", itemID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Продукт не найден"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец кладовой
+	if user.ID != item.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Продукт принадлежит другому пользователю"})
+	}
+
+	// Неуказанные поля не меняются, ингредиент поменять нельзя
+	item_data := PantryItemData{Quantity: item.FloatQuantity, Unit: item.StrUnit, Expires: item.StrExpiresAt}
+	err = c.Bind(&item_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+	item_data.IngredientId = item.IntIngredientId
+
+	message := server.preparePantryItem(&item, &item_data)
+	if message != "" {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: message})
+	}
+
+	err = server.DB.Model(&item).Updates(map[string]interface{}{
+		"float_quantity": item.FloatQuantity,
+		"str_unit":       item.StrUnit,
+		"float_grams":    item.FloatGrams,
+		"str_expires_at": item.StrExpiresAt,
+	}).Error
+	if err != nil {
+		log.Printf("Update pantry item: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить продукт"})
+	}
+
+	return c.JSON(http.StatusOK, &PantryItemResponse{Message: "Продукт изменен", Id: item.ID})
+}
+
+// Функция для удаления продукта из кладовой
+func (server *Server) DeletePantryItemHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	itemID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id продукта"})
+	}
+
+	var item models.PantryItem
+	err = server.DB.First(&item, "id = ?", itemID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Продукт не найден"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец кладовой
+	if user.ID != item.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Продукт принадлежит другому пользователю"})
+	}
+
+	err = server.DB.Delete(&item).Error
+	if err != nil {
+		log.Printf("Delete pantry item: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить продукт"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Продукт удален"})
+}
+
+// Функция для списания из кладовой продуктов, потраченных на рецепт
+//
+// Если каких-то продуктов не хватило, то списывается всё, что есть,
+// а недостающее возвращается в ответе
+func (server *Server) CookRecipeHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	var cook_data CookData
+	err = c.Bind(&cook_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Скрытые рецепты и черновики доступны только автору и соавторам
+	if !server.CanReadRecipe(user, recipe) {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	servings := cook_data.Servings
+	if servings == 0 {
+		servings = recipe.IntServings
+	}
+	if servings < 0 || servings > MaxScaleServings {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное количество порций"})
+	}
+
+	missing, err := server.CookFromPantry(user.ID, recipe, servings)
+	if err != nil {
+		log.Printf("Cook from pantry: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось списать продукты"})
+	}
+
+	return c.JSON(http.StatusOK, &CookResponse{Message: "Продукты списаны", Missing: missing})
+}
+
+// Функция для подбора рецептов, в которых можно использовать
+// продукты с истекающим сроком годности
+//
+// Параметр days - через сколько дней истекает срок (по умолчанию 3).
+// Выше в списке рецепты, в которых больше таких продуктов,
+// затем - те, для которых меньше нужно докупить
+func (server *Server) UseItUpHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	days := DefaultExpiringDays
+	if value := c.QueryParam("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 || days > MaxExpiringDays {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное количество дней"})
+		}
+	}
+
+	var items []models.PantryItem
+	err = server.DB.Preload("Ingredient").Find(&items, "int_user_id = ?", user.ID).Error
+	if err != nil {
+		log.Printf("Get pantry: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить продукты"})
+	}
+
+	limit := DaysFromToday(days)
+	inPantry := map[uint]bool{}
+	expiring := map[uint]string{}
+	var expiringIds []uint
+	for _, item := range items {
+		inPantry[item.IntIngredientId] = true
+		if item.StrExpiresAt != "" && item.StrExpiresAt <= limit {
+			if _, ok := expiring[item.IntIngredientId]; !ok {
+				expiringIds = append(expiringIds, item.IntIngredientId)
+			}
+			expiring[item.IntIngredientId] = item.Ingredient.StrIngredientName
+		}
+	}
+
+	suggestions := []UseItUpRecipe{}
+	if len(expiringIds) == 0 {
+		return c.JSON(http.StatusOK, suggestions)
+	}
+
+	pantryIds := make([]uint, 0, len(inPantry))
+	for ingredientID := range inPantry {
+		pantryIds = append(pantryIds, ingredientID)
+	}
+
+	// Сортировка и ограничение списка выполняются в БД,
+	// ингредиенты загружаются только для выбранных рецептов
+	ranking := clause.OrderBy{Expression: clause.Expr{
+		SQL: "(SELECT COUNT(*) FROM recipe_ingredients WHERE recipe_ingredients.int_recipe_id = recipes.id " +
+			"AND recipe_ingredients.int_ingredient_id IN ? AND recipe_ingredients.deleted_at IS NULL) DESC, " +
+			"(SELECT COUNT(*) FROM recipe_ingredients WHERE recipe_ingredients.int_recipe_id = recipes.id " +
+			"AND recipe_ingredients.int_ingredient_id NOT IN ? AND recipe_ingredients.deleted_at IS NULL), recipes.id",
+		Vars: []interface{}{expiringIds, pantryIds},
+	}}
+
+	var recipes []models.Recipe
+	err = FilterRecipesByIngredients(ReadableRecipes(server.DB.Preload("RecipeIngredients.Ingredient"), user.ID), expiringIds).
+		Clauses(ranking).Limit(MaxUseItUpRecipes).
+		Find(&recipes).Error
+	if err != nil {
+		log.Printf("Find recipes by ingredients: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепты"})
+	}
+
+	for _, recipe := range recipes {
+		suggestion := UseItUpRecipe{Recipe: recipe, Expiring: []string{}}
+		for _, recipeIngredient := range recipe.RecipeIngredients {
+			if name, ok := expiring[recipeIngredient.IntIngredientId]; ok {
+				suggestion.Expiring = append(suggestion.Expiring, name)
+			}
+			if inPantry[recipeIngredient.IntIngredientId] {
+				suggestion.Matched++
+			} else {
+				suggestion.Missing++
+			}
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return c.JSON(http.StatusOK, suggestions)
+}
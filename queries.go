@@ -1,18 +1,188 @@
 package main
 
-import "github.com/Recipe-book-PetrSU-2022/backend/models"
+import (
+	"errors"
+	"strconv"
+	"strings"
 
-// Функция для получения полной информации о рецепте
-func (server *Server) GetRecipeById(id int) (*models.Recipe, error) {
-	var recipe models.Recipe
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
 
-	err := server.DB.
+// Размер страницы списков
+const (
+	DefaultPageLimit = 20  // по умолчанию
+	MaxPageLimit     = 100 // наибольший
+)
+
+// Ошибка при неверных параметрах страницы
+var ErrWrongPage = errors.New("неверные параметры страницы")
+
+// Функция для получения номера страницы (с единицы) и её размера
+// из параметров page и limit
+func ParsePagination(c echo.Context) (int, int, error) {
+	page, limit := 1, DefaultPageLimit
+
+	var err error
+	if value := c.QueryParam("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return 0, 0, ErrWrongPage
+		}
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxPageLimit {
+			return 0, 0, ErrWrongPage
+		}
+	}
+
+	return page, limit, nil
+}
+
+// Функция для выбора одной страницы списка
+func Paginate(page int, limit int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Offset((page - 1) * limit).Limit(limit)
+	}
+}
+
+// Функция для подгрузки связанных с рецептом данных
+func PreloadRecipe(db *gorm.DB) *gorm.DB {
+	return db.
 		Preload("User").
-		Preload("RecipeStages").
-		Preload("RecipeStages.StagePhotos").
+		Preload("RecipeStages", OrderStages).
+		Preload("RecipeStages.StagePhotos", OrderPhotos).
 		Preload("RecipeComments").
 		Preload("RecipeIngredients").
 		Preload("RecipeIngredients.Ingredient").
+		Preload("RecipeFilters")
+}
+
+// Функция для сортировки этапов рецепта по порядку
+func OrderStages(db *gorm.DB) *gorm.DB {
+	return db.Order("int_stage_order, id")
+}
+
+// Функция для сортировки фото этапа по порядку
+func OrderPhotos(db *gorm.DB) *gorm.DB {
+	return db.Order("int_photo_order, id")
+}
+
+// Функция для получения этапов рецепта по порядку
+func GetRecipeStages(db *gorm.DB, recipeID uint) ([]models.Stage, error) {
+	var stages []models.Stage
+	err := OrderStages(db).Find(&stages, "int_recipe_id = ?", recipeID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return stages, nil
+}
+
+// Функция для сохранения порядка этапов
+//
+// Этапы нумеруются подряд с нуля в порядке следования в списке
+func SaveStagesOrder(db *gorm.DB, stages []models.Stage) error {
+	for i := range stages {
+		if stages[i].IntStageOrder == i {
+			continue
+		}
+
+		stages[i].IntStageOrder = i
+		err := db.Model(&stages[i]).UpdateColumn("int_stage_order", i).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Функция для сохранения порядка фото этапа
+//
+// Фото нумеруются подряд с нуля в порядке следования в списке
+func SavePhotosOrder(db *gorm.DB, photos []models.Photo) error {
+	for i := range photos {
+		if photos[i].IntPhotoOrder == i {
+			continue
+		}
+
+		photos[i].IntPhotoOrder = i
+		err := db.Model(&photos[i]).UpdateColumn("int_photo_order", i).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Функция для получения фото с информацией об этапе и рецепте
+func (server *Server) GetPhotoById(id int) (*models.Photo, error) {
+	var photo models.Photo
+
+	err := server.DB.
+		Preload("Stage").
+		Preload("Stage.Recipe").
+		First(&photo, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &photo, nil
+}
+
+// Функция для отбора рецептов, у которых есть все теги из списка
+func FilterRecipesByTags(db *gorm.DB, tags []uint) *gorm.DB {
+	if len(tags) == 0 {
+		return db
+	}
+
+	// Повторяющиеся теги не должны увеличивать нужное количество совпадений
+	tags = UniqueIds(tags)
+
+	return db.Where(
+		"recipes.id IN (SELECT recipe_id FROM recipe_filters WHERE filter_id IN ? GROUP BY recipe_id HAVING COUNT(DISTINCT filter_id) = ?)",
+		tags, len(tags),
+	)
+}
+
+// Функция для отбора рецептов, в которых есть хотя бы один ингредиент из списка
+func FilterRecipesByIngredients(db *gorm.DB, ingredients []uint) *gorm.DB {
+	return db.Where(
+		"recipes.id IN (SELECT int_recipe_id FROM recipe_ingredients WHERE int_ingredient_id IN ? AND deleted_at IS NULL)",
+		ingredients,
+	)
+}
+
+// Функция для разбора списка ID вида "1,2,3"
+func ParseIdList(str string) ([]uint, error) {
+	var ids []uint
+
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, uint(id))
+	}
+
+	return ids, nil
+}
+
+// Функция для получения полной информации о рецепте
+func (server *Server) GetRecipeById(id int) (*models.Recipe, error) {
+	var recipe models.Recipe
+
+	err := PreloadRecipe(server.DB).
 		First(&recipe, "id = ?", id).Error
 	if err != nil {
 		return nil, err
@@ -27,7 +197,7 @@ func (server *Server) GetStageById(id int) (*models.Stage, error) {
 
 	err := server.DB.
 		Preload("Recipe").
-		Preload("StagePhotos").
+		Preload("StagePhotos", OrderPhotos).
 		First(&stage, "id = ?", id).Error
 	if err != nil {
 		return nil, err
@@ -35,3 +205,32 @@ func (server *Server) GetStageById(id int) (*models.Stage, error) {
 
 	return &stage, nil
 }
+
+// Функция для удаления повторяющихся ID с сохранением порядка
+func UniqueIds(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
+
+// Функция для получения тегов по списку ID
+func (server *Server) GetFiltersByIds(ids []uint) ([]models.Filter, error) {
+	filters := []models.Filter{}
+	if len(ids) == 0 {
+		return filters, nil
+	}
+
+	err := server.DB.Find(&filters, "id IN ?", ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return filters, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/bytes"
+	"gorm.io/gorm"
+)
+
+// Ограничения на загрузку файлов по умолчанию
+const (
+	DefaultStorageQuota    = 100 << 20 // квота пользователя, 100 МБ
+	DefaultUploadBodyLimit = "20M"     // максимальный размер запроса с файлами
+)
+
+// Особые значения квоты пользователя
+const (
+	StorageQuotaDefault   = 0  // квота по умолчанию из настроек сервера
+	StorageQuotaUnlimited = -1 // без ограничений
+)
+
+// Ошибка превышения квоты
+var ErrQuotaExceeded = errors.New("превышена квота на хранение файлов")
+
+// Использование хранилища пользователем
+//
+// Переменные структуры:
+//   - Сколько байт занято
+//   - Квота в байтах (-1 - без ограничений)
+//   - Сколько байт осталось (-1 - без ограничений)
+type StorageUsage struct {
+	Used      int64 `json:"used"`
+	Quota     int64 `json:"quota"`
+	Remaining int64 `json:"remaining"`
+}
+
+type QuotaData struct {
+	Quota *int64 `json:"quota"` // 0 - квота по умолчанию, -1 - без ограничений
+}
+
+// Функция для получения ограничения на размер запроса с файлами
+func (server *Server) uploadBodyLimit() string {
+	if server.UploadBodyLimit == "" {
+		return DefaultUploadBodyLimit
+	}
+	return server.UploadBodyLimit
+}
+
+// Функция для получения middleware, ограничивающего размер запроса с файлами
+func (server *Server) UploadLimitMiddleware() echo.MiddlewareFunc {
+	return middleware.BodyLimit(server.uploadBodyLimit())
+}
+
+// Функция для получения наибольшего размера загружаемого файла в байтах
+//
+// Файл не может быть больше запроса, в котором его передали
+func (server *Server) UploadLimitBytes() int64 {
+	limit, err := bytes.Parse(server.uploadBodyLimit())
+	if err != nil {
+		limit, _ = bytes.Parse(DefaultUploadBodyLimit)
+	}
+	return limit
+}
+
+// Функция для получения квоты пользователя
+//
+// Квота, назначенная администратором, важнее квоты по умолчанию
+func (server *Server) GetUserQuota(user *models.User) int64 {
+	if user.IntStorageQuota != StorageQuotaDefault {
+		return user.IntStorageQuota
+	}
+
+	if server.DefaultStorageQuota <= 0 {
+		return StorageQuotaUnlimited
+	}
+	return server.DefaultStorageQuota
+}
+
+// Функция для получения использования хранилища пользователем
+func (server *Server) GetStorageUsage(user *models.User) StorageUsage {
+	usage := StorageUsage{Used: user.IntStorageUsed, Quota: server.GetUserQuota(user), Remaining: StorageQuotaUnlimited}
+	if usage.Quota != StorageQuotaUnlimited {
+		usage.Remaining = usage.Quota - usage.Used
+		if usage.Remaining < 0 {
+			usage.Remaining = 0
+		}
+	}
+	return usage
+}
+
+// Функция для предварительной проверки, что пользователь может загрузить ещё size байт
+//
+// Вызывается до обработки файлов, размер берётся из запроса. Место
+// под сохранённые файлы вместе с копиями занимается в ReserveStorage
+func (server *Server) CheckStorageQuota(user *models.User, size int64) error {
+	usage := server.GetStorageUsage(user)
+	if usage.Quota != StorageQuotaUnlimited && size > usage.Remaining {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Функция для занятия size байт под сохранённые файлы пользователя
+//
+// Квота проверяется и занятое место увеличивается одним запросом,
+// поэтому одновременные загрузки не выйдут за квоту. Если места
+// не хватает, то возвращается ErrQuotaExceeded. Отрицательный size
+// освобождает место без проверки
+func (server *Server) ReserveStorage(user *models.User, size int64) error {
+	if size <= 0 {
+		server.ChargeStorage(user.ID, size)
+		return nil
+	}
+
+	query := server.DB.Model(&models.User{}).Where("id = ?", user.ID)
+	quota := server.GetUserQuota(user)
+	if quota != StorageQuotaUnlimited {
+		query = query.Where("int_storage_used + ? <= ?", size, quota)
+	}
+
+	result := query.UpdateColumn("int_storage_used", gorm.Expr("int_storage_used + ?", size))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Функция для изменения занятого пользователем места на delta байт
+//
+// При освобождении места занятый объём не становится отрицательным
+func (server *Server) ChargeStorage(userID uint, delta int64) {
+	if delta == 0 {
+		return
+	}
+
+	err := server.DB.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("int_storage_used", gorm.Expr("int_storage_used + ?", delta)).Error
+	if err == nil && delta < 0 {
+		err = server.DB.Model(&models.User{}).Where("id = ? AND int_storage_used < 0", userID).
+			UpdateColumn("int_storage_used", 0).Error
+	}
+	if err != nil {
+		log.Printf("Charge storage for user %d: %s", userID, err.Error())
+	}
+}
+
+// Функция для подсчёта места, занятого изображением вместе с копиями
+func (server *Server) StoredImageBytes(filename string, variants map[string]string) int64 {
+	var total int64
+	for _, name := range append([]string{filename}, mapValues(variants)...) {
+		info, err := server.Storage.Stat(name)
+		if err == nil {
+			total += info.Size
+		}
+	}
+	return total
+}
+
+// Функция для получения значений словаря
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, value := range m {
+		values = append(values, value)
+	}
+	return values
+}
+
+// Функция для подсчёта места, занятого фото
+func PhotosBytes(photos []models.Photo) int64 {
+	var total int64
+	for _, photo := range photos {
+		total += photo.IntImageSize
+	}
+	return total
+}
+
+// Функция для изменения квоты пользователя (только для администратора)
+func (server *Server) SetUserQuotaHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	admin, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	if !IsAdmin(admin) {
+		return c.JSON(http.StatusForbidden, &DefaultResponse{Message: "Недостаточно прав"})
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id пользователя"})
+	}
+
+	var user models.User
+	err = server.DB.First(&user, "id = ?", userID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Пользователь не найден"})
+	}
+
+	var quota_data QuotaData
+	err = c.Bind(&quota_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if quota_data.Quota == nil || *quota_data.Quota < StorageQuotaUnlimited {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверная квота"})
+	}
+
+	err = server.DB.Model(&user).UpdateColumn("int_storage_quota", *quota_data.Quota).Error
+	if err != nil {
+		log.Printf("Set quota: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить квоту"})
+	}
+	user.IntStorageQuota = *quota_data.Quota
+
+	return c.JSON(http.StatusOK, &QuotaResponse{Message: "Квота изменена", Storage: server.GetStorageUsage(&user)})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -16,6 +17,7 @@ type RecipeData struct {
 	Time     int    `json:"time"`
 	Country  string `json:"country"`
 	Type     string `json:"type"`
+	Tags     []uint `json:"tags"`
 	// Image    string `json:"image"`
 }
 
@@ -31,9 +33,10 @@ func (server *Server) CreateEmptyRecipeHandle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
 	}
 
-	// Создаем структуру для рецепта
+	// Создаем структуру для рецепта, новый рецепт - черновик
 	recipe := models.Recipe{
-		User: *user,
+		User:            *user,
+		StrRecipeStatus: RecipeStatusDraft,
 	}
 
 	// Сохраняем пустой рецепт в БД
@@ -45,6 +48,9 @@ func (server *Server) CreateEmptyRecipeHandle(c echo.Context) error {
 	// Сохраняем ID рецепта, для передачи на фронтэнд
 	recipeID := recipe.ID
 
+	// Сохраняем первую версию рецепта
+	server.RecordRecipeRevision(recipe.ID, user.ID, "Рецепт создан")
+
 	return c.JSON(http.StatusOK, &RecipeResponse{Message: "Создан новый рецепт", Id: recipeID})
 }
 
@@ -80,24 +86,60 @@ func (server *Server) UpdateRecipeHandle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Название рецепта не может быть пустым"})
 	}
 
-	// Проверка на то, что текущий пользователь - автор рецепта
-	if user.ID != recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
-	// Заполняем данные о рецепте
-	recipe.StrRecipeName = recipe_data.Name
-	recipe.IntServings = recipe_data.Servings
-	recipe.IntTime = recipe_data.Time
-	recipe.StrRecipeCountry = recipe_data.Country
-	recipe.StrRecipeType = recipe_data.Type
+	// Если переданы теги, то проверяем, что все они существуют
+	var filters []models.Filter
+	if recipe_data.Tags != nil {
+		recipe_data.Tags = UniqueIds(recipe_data.Tags)
+		filters, err = server.GetFiltersByIds(recipe_data.Tags)
+		if err != nil || len(filters) != len(recipe_data.Tags) {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Тег не найден"})
+		}
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := checkIfMatch(c, RecipeETag(recipe), recipe); !ok {
+		return err
+	}
+
+	// Сохраняем обновленный рецепт в БД, если его версия не изменилась
+	err = UpdateVersioned(server.DB, &models.Recipe{}, recipe.ID, recipe.IntVersion, map[string]interface{}{
+		"str_recipe_name":    recipe_data.Name,
+		"int_servings":       recipe_data.Servings,
+		"int_time":           recipe_data.Time,
+		"str_recipe_country": recipe_data.Country,
+		"str_recipe_type":    recipe_data.Type,
+	})
+	if err == ErrVersionConflict {
+		return server.sendRecipeConflict(c, recipe.ID)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось обновить рецепт: %s", err.Error())})
+	}
+	recipe.IntVersion++
 
-	// Сохраняем обновленный рецепт в БД
-	err = server.DB.Save(&recipe).Error
+	// Количество порций могло измениться, пересчитываем пищевую ценность
+	err = server.UpdateRecipeNutrition(recipe.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось обновить рецепт: %s", err.Error())})
 	}
 
+	// Заменяем теги рецепта на переданные
+	if recipe_data.Tags != nil {
+		err = server.DB.Model(recipe).Association("RecipeFilters").Replace(filters)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось обновить теги рецепта: %s", err.Error())})
+		}
+	}
+
+	// Сохраняем версию рецепта
+	server.RecordRecipeRevision(recipe.ID, user.ID, "Рецепт обновлен")
+
+	c.Response().Header().Set("ETag", RecipeETag(recipe))
 	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт обновлен"})
 }
 
@@ -117,12 +159,12 @@ func (server *Server) GetRecipeHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Если рецепт скрыт из общего доступа, то пишем, что не удалось найти
-	if !recipe.BoolRecipeVisibility {
+	// Если рецепт скрыт из общего доступа или ещё не опубликован, то пишем, что не удалось найти
+	if !IsPublicRecipe(recipe) {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	return c.JSON(http.StatusOK, recipe)
+	return server.SendRecipe(c, recipe)
 }
 
 func (server *Server) GetMyRecipeHandle(c echo.Context) error {
@@ -147,31 +189,31 @@ func (server *Server) GetMyRecipeHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Проверка на то, что текущий пользователь - автор рецепта
-	if user.ID != recipe.IntUserId {
+	// Проверка на то, что пользователь - автор или соавтор рецепта
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessView) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
-	return c.JSON(http.StatusOK, recipe)
+	return server.SendRecipe(c, recipe)
 }
 
 func (server *Server) GetRecipesHandle(c echo.Context) error {
+	// Получаем список тегов для фильтрации, например ?tags=1,2
+	tags, err := ParseIdList(c.QueryParam("tags"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный список тегов"})
+	}
+
 	// Получаем информацию о рецепте
 	var recipes []models.Recipe
-	err := server.DB.
-		Preload("User").
-		Preload("RecipeStages").
-		Preload("RecipeStages.StagePhotos").
-		Preload("RecipeComments").
-		Preload("RecipeIngredients").
-		Preload("RecipeIngredients.Ingredient").
-		Find(&recipes, "bool_recipe_visibility = true").Error
+	err = FilterRecipesByTags(PublishedRecipes(PreloadRecipe(server.DB)), tags).
+		Find(&recipes).Error
 	if err != nil {
 		log.Printf("Get all recipes: %s", err.Error())
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	return c.JSON(http.StatusOK, recipes)
+	return server.SendRecipes(c, recipes)
 }
 
 func (server *Server) GetMyRecipesHandle(c echo.Context) error {
@@ -181,22 +223,26 @@ func (server *Server) GetMyRecipesHandle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
 	}
 
+	// Получаем состояние для фильтрации, например ?status=draft
+	query := PreloadRecipe(server.DB)
+	status := c.QueryParam("status")
+	if status != "" {
+		if !IsValidRecipeStatus(status) {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное состояние рецепта"})
+		}
+		query = query.Where("str_recipe_status = ?", status)
+	}
+
 	// Получаем информацию о рецепте
 	var recipes []models.Recipe
-	err = server.DB.
-		Preload("User").
-		Preload("RecipeStages").
-		Preload("RecipeStages.StagePhotos").
-		Preload("RecipeComments").
-		Preload("RecipeIngredients").
-		Preload("RecipeIngredients.Ingredient").
+	err = query.
 		Find(&recipes, "int_user_id = ?", user.ID).Error
 	if err != nil {
 		log.Printf("Get all recipes: %s", err.Error())
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	return c.JSON(http.StatusOK, recipes)
+	return server.SendRecipes(c, recipes)
 }
 
 func (server *Server) DeleteRecipeHandle(c echo.Context) error {
@@ -221,7 +267,7 @@ func (server *Server) DeleteRecipeHandle(c echo.Context) error {
 	}
 
 	// Проверка на то, что текущий пользователь - автор рецепта
-	if user.ID != recipe.IntUserId {
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessOwner) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
@@ -231,11 +277,24 @@ func (server *Server) DeleteRecipeHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить удалить рецепт: %s", err.Error())})
 	}
 
+	// Освобождаем место, занятое обложкой и фото этапов.
+	// Сами файлы удалит сборщик мусора
+	size := recipe.IntRecipeImageSize
+	for _, stage := range recipe.RecipeStages {
+		size += PhotosBytes(stage.StagePhotos)
+	}
+	server.ChargeStorage(recipe.IntUserId, -size)
+
+	// Копия больше не учитывается у исходного рецепта
+	server.ReleaseFork(recipe)
+
 	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт удален"})
 }
 
 type FindData struct {
-	Text string `json:"text"`
+	Text      string          `json:"text"`
+	Tags      []uint          `json:"tags"`
+	Nutrition NutritionFilter `json:"nutrition"` // пищевая ценность на порцию
 }
 
 func (server *Server) FindRecipesHandle(c echo.Context) error {
@@ -245,28 +304,25 @@ func (server *Server) FindRecipesHandle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
 	}
 
-	if find_data.Text == "" {
+	if find_data.Text == "" && len(find_data.Tags) == 0 && find_data.Nutrition.IsEmpty() {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Пустая строка поиска"})
 	}
 
 	// Получаем информацию о рецепте
 	var recipes []models.Recipe
-	err = server.DB.
-		Preload("User").
-		Preload("RecipeStages").
-		Preload("RecipeStages.StagePhotos").
-		Preload("RecipeComments").
-		Preload("RecipeIngredients").
+	query := FilterRecipesByTags(PublishedRecipes(PreloadRecipe(server.DB)), find_data.Tags)
+	query = FilterRecipesByNutrition(query, find_data.Nutrition)
+	err = query.
 		Find(&recipes, "LOWER(str_recipe_name) LIKE ?", fmt.Sprintf("%%%s%%", find_data.Text)).Error
 	if err != nil {
 		log.Printf("Find recipes: %s", err.Error())
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	return c.JSON(http.StatusOK, recipes)
+	return server.SendRecipes(c, recipes)
 }
 
-func (server *Server) AddRecipeToFavoritesHandle(c echo.Context) error {
+func (server *Server) ChangeVisibilityRecipeHandle(c echo.Context) error {
 	// Получаем информацию о пользователе
 	user, err := server.GetUserByClaims(c)
 	if err != nil {
@@ -287,22 +343,37 @@ func (server *Server) AddRecipeToFavoritesHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Добавляем рецепт в избранные пользователя
-	user.UserFavorite = append(user.UserFavorite, *recipe)
-	err = server.DB.Save(&user).Error
+	// Проверка на то, что текущий пользователь - автор рецепта
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessOwner) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	// Получаем данные о видимости с фронтэнда
+	var visibility VisibilitySwitch
+	err = c.Bind(&visibility)
 	if err != nil {
-		log.Printf("Favorite: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось добавить рецепт в избранное"})
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
 	}
 
-	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Ок"})
-}
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
 
-// func (server *Server) RemoveRecipeFromFavoritesHandle(c echo.Context) error {
-// 	return nil
-// }
+	// Обновляем значение видимости в БД
+	err = server.DB.Model(recipe).UpdateColumn("bool_recipe_visibility", visibility.Visible).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить обновить рецепт: %s", err.Error())})
+	}
 
-func (server *Server) ChangeVisibilityRecipeHandle(c echo.Context) error {
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт обновлен"})
+}
+
+// Функция для загрузки обложки рецепта
+//
+// Кроме оригинала сохраняются уменьшенные копии (thumbnail, card, hero)
+// и их WebP-версии. Старая обложка и её копии удаляются
+func (server *Server) UploadRecipeCoverHandle(c echo.Context) error {
 	// Получаем информацию о пользователе
 	user, err := server.GetUserByClaims(c)
 	if err != nil {
@@ -323,97 +394,84 @@ func (server *Server) ChangeVisibilityRecipeHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Проверка на то, что текущий пользователь - автор рецепта
-	if user.ID != recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
-	// Получаем данные о видимости с фронтэнда
-	var visibility VisibilitySwitch
-	err = c.Bind(&visibility)
+	// Получаем файл из формы
+	file, err := c.FormFile("file")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить файл из формы: %s", err.Error())})
 	}
 
-	// Сохраняем значение видимости
-	recipe.BoolRecipeVisibility = visibility.Visible
+	// Проверяем квоту автора рецепта до сохранения файла
+	owner, err := server.GetRecipeOwner(recipe)
+	if err != nil {
+		log.Printf("Get recipe owner: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+	err = server.CheckStorageQuota(owner, file.Size)
+	if err != nil {
+		return c.JSON(http.StatusRequestEntityTooLarge, &DefaultResponse{Message: "Превышена квота на хранение файлов"})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
 
-	// Обновляем запись в БД
-	err = server.DB.Save(&recipe).Error
+	// Сохраняем файл
+	filename, err := server.SaveFormFile(file)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить обновить рецепт: %s", err.Error())})
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Рецепт обновлен"})
-}
+	// Создаём уменьшенные копии обложки
+	variants, err := server.SaveImageVariants(filename, server.CoverSizes)
+	if err != nil {
+		server.ReleaseImage(filename, ImageVariantNames(filename, server.CoverSizes))
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось обработать изображение: %s", err.Error())})
+	}
+
+	oldFilename, oldVariants, oldSize := recipe.StrRecipeImage, recipe.RecipeImageVariants, recipe.IntRecipeImageSize
+	size := server.StoredImageBytes(filename, variants)
+
+	// Занимаем место под новую обложку вместо старой
+	err = server.ReserveStorage(owner, size-oldSize)
+	if err == ErrQuotaExceeded {
+		server.ReleaseImage(filename, variants)
+		return c.JSON(http.StatusRequestEntityTooLarge, &DefaultResponse{Message: "Превышена квота на хранение файлов"})
+	}
+	if err != nil {
+		server.ReleaseImage(filename, variants)
+		log.Printf("Reserve storage: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить рецепт"})
+	}
+
+	// Сохраняем обложку рецепта
+	err = server.DB.Model(recipe).Updates(&models.Recipe{
+		StrRecipeImage:      filename,
+		RecipeImageVariants: variants,
+		IntRecipeImageSize:  size,
+	}).Error
+	if err != nil {
+		server.ChargeStorage(owner.ID, oldSize-size)
+		server.ReleaseImage(filename, variants)
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось обновить рецепт: %s", err.Error())})
+	}
+
+	// Освобождаем старую обложку вместе с копиями, если она больше нигде не используется
+	server.ReleaseImage(oldFilename, oldVariants)
 
-// func (server *Server) UploadRecipeCoverHandle(c echo.Context) error {
-// 	// Получаем информацию о пользователе
-// 	user, err := server.GetUserByClaims(c)
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
-// 	}
-
-// 	// Получаем ID рецепта с фронтэнда
-// 	recipeID, err := strconv.Atoi(c.Param("id"))
-// 	if err != nil {
-// 		log.Printf("Recipe id: %s", err.Error())
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
-// 	}
-
-// 	// Получаем информацию о рецепте
-// 	recipe, err := server.GetRecipeById(recipeID)
-// 	if err != nil {
-// 		log.Printf("Get recipe by id: %s", err.Error())
-// 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
-// 	}
-
-// 	// Проверка на то, что текущий пользователь - автор рецепта
-// 	if user.ID != recipe.IntUserId {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
-// 	}
-
-// 	// Получаем файл из формы
-// 	file, err := c.FormFile("file")
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить файл из формы: %s", err.Error())})
-// 	}
-
-// 	// Получаем расширение файла
-// 	fileExt, err := server.GetFileExtByMimetype(file)
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось определить тип файла: %s", err.Error())})
-// 	}
-
-// 	// Пытаемся открыть файл
-// 	src, err := file.Open()
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось прочитать файл: %s", err.Error())})
-// 	}
-// 	defer src.Close()
-
-// 	// Сохраняем файл
-// 	filename, err := server.SaveFileWithExt(src, fileExt)
-// 	if err != nil {
-// 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось сохранить файл: %s", err.Error())})
-// 	}
-
-// 	// Сохраняем обложку рецепта
-// 	recipe.StrRecipeImage = filename
-
-// 	// Обновляем данные о рецепте
-// 	err = server.DB.Save(&recipe).Error
-// 	if err != nil {
-// 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось обновить рецепт: %s", err.Error())})
-// 	}
-
-// 	return c.JSON(http.StatusOK, &CoverResponse{Message: "Ок", Cover: filename})
-
-// }
+	return c.JSON(http.StatusOK, &CoverResponse{Message: "Ок", Cover: filename, Variants: variants})
+}
 
 type RecipeIngredientInfo struct {
-	IngredientId int `json:"ingredient_id"`
-	Grams        int `json:"grams"`
+	IngredientId int     `json:"ingredient_id"`
+	Grams        int     `json:"grams"`
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit"` // если не указана, то используется grams
 }
 
 func (server *Server) AddIngredientHandle(c echo.Context) error {
@@ -437,8 +495,8 @@ func (server *Server) AddIngredientHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Проверка на то, что текущий пользователь автор рецепта
-	if user.ID != recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
@@ -455,13 +513,31 @@ func (server *Server) AddIngredientHandle(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Ингредиент не найден"})
 	}
 
-	recipe.RecipeIngredients = append(recipe.RecipeIngredients, models.RecipeIngredient{
-		IntGrams:   recipe_ingredient_info.Grams,
-		Ingredient: ingredient,
-		Recipe:     *recipe,
-	})
+	// Для совместимости количество без единицы измерения считаем в граммах
+	quantity, unit := recipe_ingredient_info.Quantity, recipe_ingredient_info.Unit
+	if unit == "" {
+		quantity, unit = float64(recipe_ingredient_info.Grams), UnitGram
+	}
+
+	// Переводим количество в граммы для подсчёта пищевой ценности
+	grams, err := ConvertToGrams(quantity, unit, &ingredient)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Неверное количество ингредиента: %s", err.Error())})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
 
-	err = server.DB.Save(&recipe).Error
+	// Сохраняем только ингредиент, чтобы не перезаписать одновременные изменения рецепта
+	err = server.DB.Omit("Recipe", "Ingredient").Create(&models.RecipeIngredient{
+		IntGrams:        int(math.Round(grams)),
+		FloatQuantity:   quantity,
+		StrUnit:         unit,
+		IntRecipeId:     recipe.ID,
+		IntIngredientId: ingredient.ID,
+	}).Error
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError, &DefaultResponse{
@@ -470,6 +546,15 @@ func (server *Server) AddIngredientHandle(c echo.Context) error {
 		)
 	}
 
+	err = server.UpdateRecipeNutrition(recipe.ID)
+	if err != nil {
+		log.Printf("Update nutrition: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось пересчитать пищевую ценность рецепта"})
+	}
+
+	// Сохраняем версию рецепта
+	server.RecordRecipeRevision(recipe.ID, user.ID, "Ингредиент добавлен")
+
 	return c.JSON(http.StatusOK, &DefaultResponse{
 		Message: "Ингредиент добавлен",
 	})
@@ -494,8 +579,8 @@ func (server *Server) RemoveIngredientHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Проверка на то, что текущий пользователь автор рецепта
-	if user.ID != recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 	// Получаем данные с фронтенда
@@ -512,14 +597,16 @@ func (server *Server) RemoveIngredientHandle(c echo.Context) error {
 	}
 
 	var recipeIngredient models.RecipeIngredient
-	err = server.DB.Where(&models.RecipeIngredient{
-		Recipe:     *recipe,
-		Ingredient: ingredient,
-	}).First(&recipeIngredient).Error
+	err = server.DB.Where("int_recipe_id = ? AND int_ingredient_id = ?", recipe.ID, ingredient.ID).First(&recipeIngredient).Error
 	if err != nil {
 		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Ингредиент рецепта не найден"})
 	}
 
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
+
 	err = server.DB.Unscoped().Delete(&recipeIngredient).Error
 	if err != nil {
 		return c.JSON(
@@ -529,6 +616,15 @@ func (server *Server) RemoveIngredientHandle(c echo.Context) error {
 		)
 	}
 
+	err = server.UpdateRecipeNutrition(recipe.ID)
+	if err != nil {
+		log.Printf("Update nutrition: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось пересчитать пищевую ценность рецепта"})
+	}
+
+	// Сохраняем версию рецепта
+	server.RecordRecipeRevision(recipe.ID, user.ID, "Ингредиент удален")
+
 	return c.JSON(http.StatusOK, &DefaultResponse{
 		Message: "Ингредиент удален",
 	})
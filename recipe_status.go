@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Состояния рецепта
+const (
+	RecipeStatusDraft     = "draft"     // черновик, виден только автору
+	RecipeStatusPublished = "published" // опубликован и показывается в списках
+	RecipeStatusArchived  = "archived"  // снят с публикации, но доступен по ссылке
+)
+
+// Допустимые переходы между состояниями рецепта
+var recipeStatusTransitions = map[string][]string{
+	RecipeStatusDraft:     {RecipeStatusPublished},
+	RecipeStatusPublished: {RecipeStatusArchived, RecipeStatusDraft},
+	RecipeStatusArchived:  {RecipeStatusPublished, RecipeStatusDraft},
+}
+
+// Функция для проверки, что состояние рецепта существует
+func IsValidRecipeStatus(status string) bool {
+	_, ok := recipeStatusTransitions[status]
+	return ok
+}
+
+// Функция для проверки, что рецепт можно перевести в состояние status
+func CanChangeRecipeStatus(from string, to string) bool {
+	for _, status := range recipeStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция для проверки, что рецепт доступен всем по ссылке
+//
+// Черновики и скрытые рецепты видит только автор
+func IsPublicRecipe(recipe *models.Recipe) bool {
+	return recipe.BoolRecipeVisibility && recipe.StrRecipeStatus != RecipeStatusDraft
+}
+
+// Функция для выбора рецептов, которые показываются в общих списках
+func PublishedRecipes(db *gorm.DB) *gorm.DB {
+	return db.Where("recipes.bool_recipe_visibility = ? AND recipes.str_recipe_status = ?", true, RecipeStatusPublished)
+}
+
+// Функция для выбора рецептов, которые пользователь может смотреть
+//
+// Те же правила, что и в CanReadRecipe: рецепт доступен всем,
+// или пользователь - его автор, или принявший приглашение соавтор
+func ReadableRecipes(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Where(
+		"((recipes.bool_recipe_visibility = ? AND recipes.str_recipe_status <> ?) OR recipes.int_user_id = ? OR "+
+			"EXISTS (SELECT 1 FROM recipe_collaborators WHERE recipe_collaborators.int_recipe_id = recipes.id "+
+			"AND recipe_collaborators.int_user_id = ? AND recipe_collaborators.bool_accepted = ? AND recipe_collaborators.deleted_at IS NULL))",
+		true, RecipeStatusDraft, userID, userID, true,
+	)
+}
+
+// Функция для проверки, что рецепт можно опубликовать
+//
+// Возвращает список незаполненных обязательных полей
+func ValidateRecipeForPublish(recipe *models.Recipe) []string {
+	problems := []string{}
+	if recipe.StrRecipeName == "" {
+		problems = append(problems, "Не указано название рецепта")
+	}
+	if recipe.IntServings <= 0 {
+		problems = append(problems, "Количество порций должно быть больше нуля")
+	}
+	if len(recipe.RecipeStages) == 0 {
+		problems = append(problems, "В рецепте нет ни одного этапа")
+	}
+	if len(recipe.RecipeIngredients) == 0 {
+		problems = append(problems, "В рецепте нет ни одного ингредиента")
+	}
+	return problems
+}
+
+// Функция для изменения состояния рецепта
+//
+// Перед публикацией проверяется, что все обязательные поля заполнены
+func (server *Server) changeRecipeStatus(c echo.Context, status string) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Проверка на то, что текущий пользователь - автор рецепта
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessOwner) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	if !CanChangeRecipeStatus(recipe.StrRecipeStatus, status) {
+		return c.JSON(http.StatusConflict, &DefaultResponse{
+			Message: fmt.Sprintf("Нельзя перевести рецепт из состояния %s в состояние %s", recipe.StrRecipeStatus, status),
+		})
+	}
+
+	if status == RecipeStatusPublished {
+		problems := ValidateRecipeForPublish(recipe)
+		if len(problems) > 0 {
+			return c.JSON(http.StatusBadRequest, &RecipeValidationResponse{Message: "Рецепт не заполнен", Errors: problems})
+		}
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
+
+	err = server.DB.Model(recipe).UpdateColumn("str_recipe_status", status).Error
+	if err != nil {
+		log.Printf("Change recipe status: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить рецепт"})
+	}
+
+	return c.JSON(http.StatusOK, &RecipeStatusResponse{Message: "Рецепт обновлен", Status: status})
+}
+
+// Функция для публикации рецепта
+func (server *Server) PublishRecipeHandle(c echo.Context) error {
+	return server.changeRecipeStatus(c, RecipeStatusPublished)
+}
+
+// Функция для переноса рецепта в архив
+func (server *Server) ArchiveRecipeHandle(c echo.Context) error {
+	return server.changeRecipeStatus(c, RecipeStatusArchived)
+}
+
+// Функция для возврата рецепта в черновики
+func (server *Server) UnpublishRecipeHandle(c echo.Context) error {
+	return server.changeRecipeStatus(c, RecipeStatusDraft)
+}
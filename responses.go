@@ -1,5 +1,11 @@
 package main
 
+import (
+	"time"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+)
+
 // Структура обычного ответа
 //
 // Переменные структуры:
@@ -83,7 +89,225 @@ type IngredientResponse struct {
 // Переменные структуры:
 //   - Сообщение
 //   - Обложка рецепта
+//   - Уменьшенные копии и WebP-версии обложки
 type CoverResponse struct {
+	Message  string            `json:"message"` // Сообщение
+	Cover    string            `json:"cover"`
+	Variants map[string]string `json:"variants"`
+}
+
+// Структура ответа с тегом
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID тега
+type FilterResponse struct {
+	Message string `json:"message"` // Сообщение
+	Id      uint   `json:"id"`
+}
+
+// Структура ответа с категорией тегов
+//
+// Переменные структуры:
+//   - Категория
+//   - Теги категории
+type FilterCategoryResponse struct {
+	Category string          `json:"category"` // Категория
+	Filters  []models.Filter `json:"filters"`  // Теги категории
+}
+
+// Структура ответа с загруженными фото
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Фото
+type PhotosResponse struct {
+	Message string         `json:"message"` // Сообщение
+	Photos  []models.Photo `json:"photos"`  // Фото
+}
+
+// Структура ответа со ссылкой на файл
+//
+// Переменные структуры:
+//   - Ссылка для скачивания
+//   - Время, до которого действует ссылка (только для подписанных ссылок)
+type FileURLResponse struct {
+	Url       string     `json:"url"`                  // Ссылка для скачивания
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Время окончания действия ссылки
+}
+
+// Структура ответа с профилем пользователя и занятым местом
+//
+// Переменные структуры:
+//   - Пользователь
+//   - Использование хранилища
+type UserProfileResponse struct {
+	*models.User
+	Storage StorageUsage `json:"storage"` // Использование хранилища
+}
+
+// Структура ответа с квотой пользователя
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Использование хранилища
+type QuotaResponse struct {
+	Message string       `json:"message"` // Сообщение
+	Storage StorageUsage `json:"storage"` // Использование хранилища
+}
+
+// Структура ответа с состоянием рецепта
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Состояние рецепта
+type RecipeStatusResponse struct {
+	Message string `json:"message"` // Сообщение
+	Status  string `json:"status"`  // Состояние рецепта
+}
+
+// Структура ответа с ошибками проверки рецепта
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Незаполненные обязательные поля
+type RecipeValidationResponse struct {
+	Message string   `json:"message"` // Сообщение
+	Errors  []string `json:"errors"`  // Незаполненные обязательные поля
+}
+
+// Структура ответа с номером версии рецепта
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Номер версии
+type RevisionResponse struct {
+	Message  string `json:"message"`  // Сообщение
+	Revision int    `json:"revision"` // Номер версии
+}
+
+// Структура ответа с коллекцией
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID коллекции
+type CollectionResponse struct {
+	Message string `json:"message"` // Сообщение
+	Id      uint   `json:"id"`
+}
+
+// Структура ответа со списком покупок
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID списка покупок
+type ShoppingListResponse struct {
+	Message string `json:"message"` // Сообщение
+	Id      uint   `json:"id"`
+}
+
+// Структура ответа с записью плана питания
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID записи плана
+type MealPlanEntryResponse struct {
+	Message string `json:"message"` // Сообщение
+	Id      uint   `json:"id"`
+}
+
+// Структура ответа с планом питания на неделю
+//
+// Переменные структуры:
+//   - Первый день недели
+//   - Последний день недели
+//   - Дни недели с приёмами пищи
+//   - Пищевая ценность за неделю
+type MealPlanWeekResponse struct {
+	Start     string           `json:"start"`     // Первый день недели
+	End       string           `json:"end"`       // Последний день недели
+	Days      []MealPlanDay    `json:"days"`      // Дни недели
+	Nutrition models.Nutrition `json:"nutrition"` // Пищевая ценность за неделю
+}
+
+// Структура ответа со ссылкой на календарь
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Секретная ссылка на календарь в формате iCalendar
+type CalendarLinkResponse struct {
+	Message string `json:"message"` // Сообщение
+	URL     string `json:"url"`     // Ссылка на календарь
+}
+
+// Структура ответа с продуктом кладовой
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID продукта
+type PantryItemResponse struct {
 	Message string `json:"message"` // Сообщение
-	Cover   string `json:"cover"`
+	Id      uint   `json:"id"`
+}
+
+// Структура ответа на списание продуктов по рецепту
+//
+// Переменные структуры:
+//   - Сообщение
+//   - Ингредиенты, которых не хватило
+type CookResponse struct {
+	Message string              `json:"message"` // Сообщение
+	Missing []MissingIngredient `json:"missing"` // Недостающие ингредиенты
+}
+
+// Структура ответа со ссылкой на рецепт
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID ссылки
+//   - Ключ ссылки
+//   - Адрес рецепта по ссылке
+//   - Когда ссылка перестанет работать
+type RecipeShareResponse struct {
+	Message   string     `json:"message"`    // Сообщение
+	Id        uint       `json:"id"`         // ID ссылки
+	Token     string     `json:"token"`      // Ключ ссылки
+	URL       string     `json:"url"`        // Адрес рецепта
+	ExpiresAt *time.Time `json:"expires_at"` // Окончание срока действия, null - бессрочно
+}
+
+// Структура ответа с приглашением соавтора
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ID приглашения
+type CollaboratorResponse struct {
+	Message string `json:"message"` // Сообщение
+	Id      uint   `json:"id"`
+}
+
+// Структура ответа со страницей списка рецептов
+//
+// Переменные структуры:
+//   - Рецепты на странице
+//   - Номер страницы (с единицы)
+//   - Размер страницы
+//   - Всего рецептов в списке
+type RecipesPageResponse struct {
+	Recipes []models.Recipe `json:"recipes"` // Рецепты на странице
+	Page    int             `json:"page"`    // Номер страницы
+	Limit   int             `json:"limit"`   // Размер страницы
+	Total   int64           `json:"total"`   // Всего рецептов
+}
+
+// Структура ответа при конфликте версий
+//
+// Переменные структуры:
+//   - Сообщение
+//   - ETag текущей версии
+//   - Текущее состояние рецепта или этапа
+type VersionConflictResponse struct {
+	Message string      `json:"message"` // Сообщение
+	ETag    string      `json:"etag"`    // ETag текущей версии
+	Current interface{} `json:"current"` // Текущее состояние
 }
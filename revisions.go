@@ -0,0 +1,688 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Виды изменений в сравнении версий
+const (
+	DiffAdded   = "added"   // появилось в новой версии
+	DiffRemoved = "removed" // было только в старой версии
+	DiffChanged = "changed" // изменилось содержимое
+	DiffMoved   = "moved"   // изменилась только позиция
+)
+
+// Изменение поля рецепта
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Изменение этапа рецепта
+//
+// Позиции считаются с нуля, у добавленного этапа нет старой позиции,
+// а у удалённого - новой
+type StageChange struct {
+	Change         string `json:"change"`
+	Id             uint   `json:"id"`
+	OldPosition    *int   `json:"old_position,omitempty"`
+	NewPosition    *int   `json:"new_position,omitempty"`
+	OldDescription string `json:"old_description,omitempty"`
+	NewDescription string `json:"new_description,omitempty"`
+}
+
+// Изменение ингредиента рецепта
+type IngredientChange struct {
+	Change       string                     `json:"change"`
+	IngredientId uint                       `json:"ingredient_id"`
+	Name         string                     `json:"name"`
+	Old          *models.IngredientSnapshot `json:"old,omitempty"`
+	New          *models.IngredientSnapshot `json:"new,omitempty"`
+}
+
+// Разница между двумя версиями рецепта
+//
+// Переменные структуры:
+//   - Номер старой версии
+//   - Номер новой версии
+//   - Изменённые поля рецепта
+//   - Добавленные теги
+//   - Удалённые теги
+//   - Изменения этапов
+//   - Изменения ингредиентов
+type RecipeDiff struct {
+	From        int                `json:"from"`
+	To          int                `json:"to"`
+	Fields      []FieldChange      `json:"fields"`
+	TagsAdded   []uint             `json:"tags_added"`
+	TagsRemoved []uint             `json:"tags_removed"`
+	Stages      []StageChange      `json:"stages"`
+	Ingredients []IngredientChange `json:"ingredients"`
+}
+
+// Функция для получения снимка рецепта
+//
+// Рецепт должен быть загружен вместе с этапами, их фото, ингредиентами и тегами
+func NewRecipeSnapshot(recipe *models.Recipe) *models.RecipeSnapshot {
+	snapshot := &models.RecipeSnapshot{
+		Name:        recipe.StrRecipeName,
+		Servings:    recipe.IntServings,
+		Time:        recipe.IntTime,
+		Country:     recipe.StrRecipeCountry,
+		Type:        recipe.StrRecipeType,
+		Tags:        make([]uint, 0, len(recipe.RecipeFilters)),
+		Stages:      make([]models.StageSnapshot, 0, len(recipe.RecipeStages)),
+		Ingredients: make([]models.IngredientSnapshot, 0, len(recipe.RecipeIngredients)),
+	}
+
+	for _, filter := range recipe.RecipeFilters {
+		snapshot.Tags = append(snapshot.Tags, filter.ID)
+	}
+	for _, stage := range recipe.RecipeStages {
+		stageSnapshot := models.StageSnapshot{Id: stage.ID, Description: stage.StrStageDesc}
+		for _, photo := range stage.StagePhotos {
+			photoSnapshot := models.PhotoSnapshot{Image: photo.StrImage, Size: photo.IntImageSize, Caption: photo.StrCaption}
+			if len(photo.PhotoVariants) > 0 {
+				photoSnapshot.Variants = photo.PhotoVariants
+			}
+			stageSnapshot.Photos = append(stageSnapshot.Photos, photoSnapshot)
+		}
+		snapshot.Stages = append(snapshot.Stages, stageSnapshot)
+	}
+	for _, recipeIngredient := range recipe.RecipeIngredients {
+		snapshot.Ingredients = append(snapshot.Ingredients, models.IngredientSnapshot{
+			IngredientId: recipeIngredient.IntIngredientId,
+			Name:         recipeIngredient.Ingredient.StrIngredientName,
+			Quantity:     recipeIngredient.FloatQuantity,
+			Unit:         recipeIngredient.StrUnit,
+			Grams:        recipeIngredient.IntGrams,
+		})
+	}
+
+	return snapshot
+}
+
+// Количество попыток сохранить версию, если номер успели занять
+const revisionSaveAttempts = 3
+
+// Функция для сохранения новой версии рецепта
+//
+// Если рецепт не изменился с последней версии, то новая версия не создаётся
+// и возвращается последняя. Номер версии выбирается и сохраняется в одной
+// транзакции, а если его успел занять параллельный запрос, то попытка
+// повторяется с новым снимком
+func (server *Server) SaveRecipeRevision(recipeID uint, userID uint, message string) (*models.RecipeRevision, error) {
+	for attempt := 1; ; attempt++ {
+		recipe, err := server.GetRecipeById(int(recipeID))
+		if err != nil {
+			return nil, err
+		}
+		snapshot := NewRecipeSnapshot(recipe)
+
+		var revision models.RecipeRevision
+		err = server.DB.Transaction(func(tx *gorm.DB) error {
+			var last models.RecipeRevision
+			err := tx.Order("int_revision desc").Limit(1).Find(&last, "int_recipe_id = ?", recipeID).Error
+			if err != nil {
+				return err
+			}
+			if last.ID != 0 && reflect.DeepEqual(last.Snapshot, snapshot) {
+				revision = last
+				return nil
+			}
+
+			revision = models.RecipeRevision{
+				IntRecipeId: recipeID,
+				IntRevision: last.IntRevision + 1,
+				IntUserId:   userID,
+				StrMessage:  message,
+				Snapshot:    snapshot,
+			}
+			return tx.Create(&revision).Error
+		})
+		if err == nil {
+			return &revision, nil
+		}
+		if attempt == revisionSaveAttempts || !server.revisionExists(recipeID, revision.IntRevision) {
+			return nil, err
+		}
+	}
+}
+
+// Функция для проверки, что версия с таким номером уже сохранена
+func (server *Server) revisionExists(recipeID uint, number int) bool {
+	var count int64
+	err := server.DB.Model(&models.RecipeRevision{}).
+		Where("int_recipe_id = ? AND int_revision = ?", recipeID, number).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// Функция для сохранения версии рецепта после изменения
+//
+// Изменение уже сохранено, поэтому ошибка только пишется в лог
+func (server *Server) RecordRecipeRevision(recipeID uint, userID uint, message string) {
+	_, err := server.SaveRecipeRevision(recipeID, userID, message)
+	if err != nil {
+		log.Printf("Save revision of recipe %d: %s", recipeID, err.Error())
+	}
+}
+
+// Функция для сохранения исходной версии рецептов, у которых ещё нет версий
+//
+// Нужна для рецептов, созданных до появления истории изменений,
+// чтобы к их текущему состоянию можно было вернуться
+func (server *Server) BackfillRecipeRevisions() error {
+	var recipes []models.Recipe
+	err := server.DB.Select("id, int_user_id").
+		Where("NOT EXISTS (SELECT 1 FROM recipe_revisions WHERE recipe_revisions.int_recipe_id = recipes.id)").
+		Find(&recipes).Error
+	if err != nil {
+		return err
+	}
+
+	for _, recipe := range recipes {
+		_, err = server.SaveRecipeRevision(recipe.ID, recipe.IntUserId, "Исходная версия")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Функция для получения версии рецепта по номеру
+func (server *Server) GetRecipeRevision(recipeID uint, number int) (*models.RecipeRevision, error) {
+	var revision models.RecipeRevision
+	err := server.DB.First(&revision, "int_recipe_id = ? AND int_revision = ?", recipeID, number).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// Функция для сравнения двух снимков рецепта
+//
+// Этапы сопоставляются по ID, ингредиенты - по ID ингредиента
+func DiffRecipeSnapshots(from *models.RecipeSnapshot, to *models.RecipeSnapshot) RecipeDiff {
+	diff := RecipeDiff{
+		Fields:      []FieldChange{},
+		TagsAdded:   []uint{},
+		TagsRemoved: []uint{},
+		Stages:      []StageChange{},
+		Ingredients: []IngredientChange{},
+	}
+
+	// Поля рецепта
+	fields := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"name", from.Name, to.Name},
+		{"servings", from.Servings, to.Servings},
+		{"time", from.Time, to.Time},
+		{"country", from.Country, to.Country},
+		{"type", from.Type, to.Type},
+	}
+	for _, field := range fields {
+		if field.old != field.new {
+			diff.Fields = append(diff.Fields, FieldChange{Field: field.name, Old: field.old, New: field.new})
+		}
+	}
+
+	// Теги
+	oldTags := map[uint]bool{}
+	for _, tag := range from.Tags {
+		oldTags[tag] = true
+	}
+	newTags := map[uint]bool{}
+	for _, tag := range to.Tags {
+		newTags[tag] = true
+		if !oldTags[tag] {
+			diff.TagsAdded = append(diff.TagsAdded, tag)
+		}
+	}
+	for _, tag := range from.Tags {
+		if !newTags[tag] {
+			diff.TagsRemoved = append(diff.TagsRemoved, tag)
+		}
+	}
+
+	// Этапы
+	oldStages := map[uint]int{}
+	for i, stage := range from.Stages {
+		oldStages[stage.Id] = i
+	}
+	newStages := map[uint]bool{}
+	for i, stage := range to.Stages {
+		newPosition := i
+		newStages[stage.Id] = true
+
+		oldPosition, found := oldStages[stage.Id]
+		if !found {
+			diff.Stages = append(diff.Stages, StageChange{
+				Change: DiffAdded, Id: stage.Id, NewPosition: &newPosition, NewDescription: stage.Description,
+			})
+			continue
+		}
+
+		old := from.Stages[oldPosition]
+		if old.Description != stage.Description {
+			diff.Stages = append(diff.Stages, StageChange{
+				Change: DiffChanged, Id: stage.Id, OldPosition: &oldPosition, NewPosition: &newPosition,
+				OldDescription: old.Description, NewDescription: stage.Description,
+			})
+		} else if oldPosition != newPosition {
+			diff.Stages = append(diff.Stages, StageChange{
+				Change: DiffMoved, Id: stage.Id, OldPosition: &oldPosition, NewPosition: &newPosition,
+			})
+		}
+	}
+	for i, stage := range from.Stages {
+		oldPosition := i
+		if !newStages[stage.Id] {
+			diff.Stages = append(diff.Stages, StageChange{
+				Change: DiffRemoved, Id: stage.Id, OldPosition: &oldPosition, OldDescription: stage.Description,
+			})
+		}
+	}
+
+	// Ингредиенты
+	oldIngredients := map[uint]*models.IngredientSnapshot{}
+	for i := range from.Ingredients {
+		oldIngredients[from.Ingredients[i].IngredientId] = &from.Ingredients[i]
+	}
+	newIngredients := map[uint]bool{}
+	for i := range to.Ingredients {
+		ingredient := &to.Ingredients[i]
+		newIngredients[ingredient.IngredientId] = true
+
+		old, found := oldIngredients[ingredient.IngredientId]
+		if !found {
+			diff.Ingredients = append(diff.Ingredients, IngredientChange{
+				Change: DiffAdded, IngredientId: ingredient.IngredientId, Name: ingredient.Name, New: ingredient,
+			})
+		} else if *old != *ingredient {
+			diff.Ingredients = append(diff.Ingredients, IngredientChange{
+				Change: DiffChanged, IngredientId: ingredient.IngredientId, Name: ingredient.Name, Old: old, New: ingredient,
+			})
+		}
+	}
+	for i := range from.Ingredients {
+		ingredient := &from.Ingredients[i]
+		if !newIngredients[ingredient.IngredientId] {
+			diff.Ingredients = append(diff.Ingredients, IngredientChange{
+				Change: DiffRemoved, IngredientId: ingredient.IngredientId, Name: ingredient.Name, Old: ingredient,
+			})
+		}
+	}
+
+	return diff
+}
+
+// Функция для возврата рецепта к снимку
+//
+// Сохранившиеся этапы обновляются и сохраняют свои фото, удалённые создаются
+// заново вместе с фото из снимка, если их файлы ещё не удалил сборщик мусора,
+// а лишние удаляются. Теги и ингредиенты, которых уже нет, пропускаются.
+// Если рецепт успели изменить, то возвращается ErrVersionConflict.
+// Возвращает фото удалённых и возвращённых этапов
+func (server *Server) RestoreRecipeSnapshot(recipe *models.Recipe, snapshot *models.RecipeSnapshot) ([]models.Photo, []models.Photo, error) {
+	var removedPhotos, restoredPhotos []models.Photo
+
+	err := server.DB.Transaction(func(tx *gorm.DB) error {
+		err := UpdateVersioned(tx, &models.Recipe{}, recipe.ID, recipe.IntVersion, map[string]interface{}{
+			"str_recipe_name":    snapshot.Name,
+			"int_servings":       snapshot.Servings,
+			"int_time":           snapshot.Time,
+			"str_recipe_country": snapshot.Country,
+			"str_recipe_type":    snapshot.Type,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Теги
+		var filters []models.Filter
+		if len(snapshot.Tags) > 0 {
+			err = tx.Find(&filters, "id IN ?", snapshot.Tags).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(filters) > 0 {
+			err = tx.Model(recipe).Association("RecipeFilters").Replace(filters)
+		} else {
+			err = tx.Model(recipe).Association("RecipeFilters").Clear()
+		}
+		if err != nil {
+			return err
+		}
+
+		// Этапы
+		current := map[uint]models.Stage{}
+		for _, stage := range recipe.RecipeStages {
+			current[stage.ID] = stage
+		}
+		for i, stageSnapshot := range snapshot.Stages {
+			stage, found := current[stageSnapshot.Id]
+			if !found {
+				stage = models.Stage{
+					StrStageDesc:  stageSnapshot.Description,
+					IntStageOrder: i,
+					IntRecipeId:   recipe.ID,
+				}
+				err = tx.Create(&stage).Error
+				if err != nil {
+					return err
+				}
+
+				// Возвращаем фото этапа, файлы которых ещё хранятся,
+				// и снимаем с них отметку о файлах без ссылок (см. KeepFiles)
+				for _, photoSnapshot := range stageSnapshot.Photos {
+					if !server.FilesStored(photoSnapshot.Image, photoSnapshot.Variants) {
+						continue
+					}
+					err = tx.Unscoped().Where("str_file_name IN ?", append(mapValues(photoSnapshot.Variants), photoSnapshot.Image)).
+						Delete(&models.OrphanFile{}).Error
+					if err != nil {
+						return err
+					}
+
+					photo := models.Photo{
+						StrImage:      photoSnapshot.Image,
+						PhotoVariants: photoSnapshot.Variants,
+						IntImageSize:  photoSnapshot.Size,
+						StrCaption:    photoSnapshot.Caption,
+						IntPhotoOrder: len(stage.StagePhotos),
+						IntStageId:    stage.ID,
+					}
+					err = tx.Create(&photo).Error
+					if err != nil {
+						return err
+					}
+					stage.StagePhotos = append(stage.StagePhotos, photo)
+					restoredPhotos = append(restoredPhotos, photo)
+				}
+				continue
+			}
+
+			delete(current, stageSnapshot.Id)
+			err = tx.Model(&stage).UpdateColumns(map[string]interface{}{
+				"str_stage_desc":  stageSnapshot.Description,
+				"int_stage_order": i,
+				"int_version":     gorm.Expr("int_version + 1"),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, stage := range current {
+			err = tx.Unscoped().Where("int_stage_id = ?", stage.ID).Delete(&models.Photo{}).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Unscoped().Delete(&stage).Error
+			if err != nil {
+				return err
+			}
+			removedPhotos = append(removedPhotos, stage.StagePhotos...)
+		}
+
+		// Ингредиенты
+		err = tx.Unscoped().Where("int_recipe_id = ?", recipe.ID).Delete(&models.RecipeIngredient{}).Error
+		if err != nil {
+			return err
+		}
+		for _, ingredientSnapshot := range snapshot.Ingredients {
+			var count int64
+			err = tx.Model(&models.Ingredient{}).Where("id = ?", ingredientSnapshot.IngredientId).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				continue
+			}
+
+			err = tx.Create(&models.RecipeIngredient{
+				IntRecipeId:     recipe.ID,
+				IntIngredientId: ingredientSnapshot.IngredientId,
+				FloatQuantity:   ingredientSnapshot.Quantity,
+				StrUnit:         ingredientSnapshot.Unit,
+				IntGrams:        ingredientSnapshot.Grams,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return removedPhotos, restoredPhotos, server.UpdateRecipeNutrition(recipe.ID)
+}
+
+// Функция для получения списка версий рецепта (без снимков)
+func (server *Server) GetRecipeRevisionsHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Проверка на то, что пользователь - автор или соавтор рецепта
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessView) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	var revisions []models.RecipeRevision
+	err = server.DB.Omit("Snapshot").Order("int_revision desc").Find(&revisions, "int_recipe_id = ?", recipe.ID).Error
+	if err != nil {
+		log.Printf("Get revisions: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить версии рецепта"})
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// Функция для получения версии рецепта со снимком
+func (server *Server) GetRecipeRevisionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	number, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный номер версии"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Проверка на то, что пользователь - автор или соавтор рецепта
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessView) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	revision, err := server.GetRecipeRevision(recipe.ID, number)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Версия рецепта не найдена"})
+	}
+
+	return c.JSON(http.StatusOK, revision)
+}
+
+// Функция для сравнения двух версий рецепта
+//
+// Номера версий передаются в параметрах from и to,
+// по умолчанию to - последняя версия
+func (server *Server) DiffRecipeRevisionsHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	fromNumber, err := strconv.Atoi(c.QueryParam("from"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный номер версии"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Проверка на то, что пользователь - автор или соавтор рецепта
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessView) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	from, err := server.GetRecipeRevision(recipe.ID, fromNumber)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Версия рецепта не найдена"})
+	}
+
+	var to models.RecipeRevision
+	if c.QueryParam("to") == "" {
+		err = server.DB.Order("int_revision desc").First(&to, "int_recipe_id = ?", recipe.ID).Error
+	} else {
+		toNumber, convErr := strconv.Atoi(c.QueryParam("to"))
+		if convErr != nil {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный номер версии"})
+		}
+		err = server.DB.First(&to, "int_recipe_id = ? AND int_revision = ?", recipe.ID, toNumber).Error
+	}
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Версия рецепта не найдена"})
+	}
+
+	diff := DiffRecipeSnapshots(from.Snapshot, to.Snapshot)
+	diff.From, diff.To = from.IntRevision, to.IntRevision
+
+	return c.JSON(http.StatusOK, &diff)
+}
+
+// Функция для возврата рецепта к одной из прошлых версий
+//
+// Перед восстановлением текущее состояние сохраняется как версия,
+// а само восстановление - как ещё одна, поэтому его можно отменить.
+// Фото удалённых этапов освобождаются не сразу, а через сборщик мусора,
+// и до этого их можно вернуть восстановлением более поздней версии
+func (server *Server) RestoreRecipeRevisionHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта с фронтэнда
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	number, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный номер версии"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	revision, err := server.GetRecipeRevision(recipe.ID, number)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Версия рецепта не найдена"})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := checkIfMatch(c, RecipeETag(recipe), recipe); !ok {
+		return err
+	}
+
+	// Сохраняем текущее состояние, чтобы к нему можно было вернуться
+	server.RecordRecipeRevision(recipe.ID, user.ID, fmt.Sprintf("Перед восстановлением версии %d", number))
+
+	removedPhotos, restoredPhotos, err := server.RestoreRecipeSnapshot(recipe, revision.Snapshot)
+	if err == ErrVersionConflict {
+		return server.sendRecipeConflict(c, recipe.ID)
+	}
+	if err != nil {
+		log.Printf("Restore revision: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось восстановить версию рецепта"})
+	}
+
+	// Файлы фото удалённых этапов удалит сборщик мусора,
+	// если на них не осталось ссылок
+	server.ChargeStorage(recipe.IntUserId, PhotosBytes(restoredPhotos)-PhotosBytes(removedPhotos))
+	server.ReleasePhotos(removedPhotos)
+
+	restored, err := server.SaveRecipeRevision(recipe.ID, user.ID, fmt.Sprintf("Восстановлена версия %d", number))
+	if err != nil {
+		log.Printf("Save revision of recipe %d: %s", recipe.ID, err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось сохранить версию рецепта"})
+	}
+
+	recipe.IntVersion++
+	c.Response().Header().Set("ETag", RecipeETag(recipe))
+	return c.JSON(http.StatusOK, &RevisionResponse{Message: "Версия рецепта восстановлена", Revision: restored.IntRevision})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Максимальное количество порций при масштабировании
+const MaxScaleServings = 100
+
+// Ингредиент рецепта после масштабирования
+type ScaledIngredient struct {
+	IngredientId uint    `json:"ingredient_id"`
+	Name         string  `json:"name"`
+	Grams        float64 `json:"grams"`    // вес после масштабирования
+	Quantity     float64 `json:"quantity"` // количество в единицах unit
+	Unit         string  `json:"unit"`
+}
+
+// Информация о масштабировании рецепта
+type RecipeScale struct {
+	OriginalServings int                `json:"original_servings"`
+	Servings         int                `json:"servings"`
+	Factor           float64            `json:"factor"`
+	Ingredients      []ScaledIngredient `json:"ingredients"`
+}
+
+// Функция для округления веса до практичной точности
+//
+// Чем больше вес, тем грубее шаг: до 10 г - десятые доли,
+// до 100 г - целые граммы, до 1 кг - по 5 г, дальше - по 10 г
+func RoundGrams(grams float64) float64 {
+	switch {
+	case grams < 10:
+		return math.Round(grams*10) / 10
+	case grams < 100:
+		return math.Round(grams)
+	case grams < 1000:
+		return math.Round(grams/5) * 5
+	}
+	return math.Round(grams/10) * 10
+}
+
+// Функция для округления количества ложек до четверти
+func RoundSpoons(spoons float64) float64 {
+	rounded := math.Round(spoons*4) / 4
+	if rounded == 0 {
+		return 0.25
+	}
+	return rounded
+}
+
+// Функция для масштабирования рецепта на нужное количество порций
+//
+// Изменяет переданный рецепт: количество порций, количество и вес
+// ингредиентов и общую пищевую ценность. Пищевая ценность на порцию не меняется
+func ScaleRecipe(recipe *models.Recipe, servings int) (*RecipeScale, error) {
+	if recipe.IntServings <= 0 {
+		return nil, errors.New("у рецепта не указано количество порций")
+	}
+
+	if servings <= 0 || servings > MaxScaleServings {
+		return nil, errors.New("неверное количество порций")
+	}
+
+	factor := float64(servings) / float64(recipe.IntServings)
+
+	scale := &RecipeScale{
+		OriginalServings: recipe.IntServings,
+		Servings:         servings,
+		Factor:           factor,
+		Ingredients:      make([]ScaledIngredient, 0, len(recipe.RecipeIngredients)),
+	}
+
+	for i := range recipe.RecipeIngredients {
+		recipeIngredient := &recipe.RecipeIngredients[i]
+		grams := RoundGrams(float64(recipeIngredient.IntGrams) * factor)
+
+		recipeIngredient.FloatQuantity *= factor
+		recipeIngredient.IntGrams = int(math.Round(grams))
+
+		scale.Ingredients = append(scale.Ingredients, ScaledIngredient{
+			IngredientId: recipeIngredient.IntIngredientId,
+			Name:         recipeIngredient.Ingredient.StrIngredientName,
+			Grams:        grams,
+			Quantity:     recipeIngredient.FloatQuantity,
+			Unit:         recipeIngredient.StrUnit,
+		})
+	}
+
+	recipe.IntServings = servings
+	recipe.TotalNutrition = RoundNutrition(ScaleNutrition(recipe.TotalNutrition, factor))
+
+	return scale, nil
+}
+
+// Функция для перевода количества ингредиента в единицы для отображения
+func DisplayQuantity(quantity float64, unit string, grams float64, ingredient *models.Ingredient, kitchen bool, system string) (float64, string) {
+	if kitchen {
+		quantity, unit = ToKitchenUnits(quantity, unit, grams, ingredient)
+	}
+	return ConvertToSystem(quantity, unit, system)
+}
+
+// Функция для получения системы мер, в которой нужно показать рецепт
+//
+// Сначала смотрим параметр units, затем настройки пользователя, если он вошёл
+func (server *Server) GetUnitSystem(c echo.Context) string {
+	system := c.QueryParam("units")
+	if IsValidUnitSystem(system) {
+		return system
+	}
+
+	if userID := OptionalUserId(c); userID != 0 {
+		var user models.User
+		err := server.DB.First(&user, "id = ?", userID).Error
+		if err == nil && IsValidUnitSystem(user.StrUnitSystem) {
+			return user.StrUnitSystem
+		}
+	}
+
+	return UnitSystemMetric
+}
+
+// Структура ответа с масштабированным рецептом
+type ScaledRecipeResponse struct {
+	*models.Recipe
+	Scale      *RecipeScale      `json:"scale,omitempty"`
+	SignedURLs map[string]string `json:"signed_urls,omitempty"` // ссылки на изображения скрытого рецепта
+}
+
+// Функция для отправки рецепта на фронтэнд
+//
+// Если передан параметр servings, то рецепт масштабируется
+// на указанное количество порций, а при kitchen=true количество
+// ингредиентов переводится в кухонные единицы. Количество показывается
+// в системе мер из параметра units или из настроек пользователя.
+// Для скрытого рецепта или черновика (их получают только автор, соавторы
+// и открывшие ссылку на рецепт) к ответу добавляются
+// подписанные ссылки на изображения. В заголовке ETag передаётся версия
+// рецепта для If-Match при изменении. Тело ответа зависит от параметров
+// и пользователя, поэтому ETag слабый, а ответ различается по Authorization
+func (server *Server) SendRecipe(c echo.Context, recipe *models.Recipe) error {
+	kitchen, _ := strconv.ParseBool(c.QueryParam("kitchen"))
+	system := server.GetUnitSystem(c)
+
+	var scale *RecipeScale
+	servingsStr := c.QueryParam("servings")
+	if servingsStr != "" {
+		servings, err := strconv.Atoi(servingsStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное количество порций"})
+		}
+
+		scale, err = ScaleRecipe(recipe, servings)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось изменить количество порций: %s", err.Error())})
+		}
+
+		for i := range scale.Ingredients {
+			scaled := &scale.Ingredients[i]
+			ingredient := &recipe.RecipeIngredients[i].Ingredient
+			scaled.Quantity, scaled.Unit = DisplayQuantity(scaled.Quantity, scaled.Unit, scaled.Grams, ingredient, kitchen, system)
+		}
+	}
+
+	// Переводим количество ингредиентов в единицы для отображения
+	for i := range recipe.RecipeIngredients {
+		recipeIngredient := &recipe.RecipeIngredients[i]
+		recipeIngredient.FloatQuantity, recipeIngredient.StrUnit = DisplayQuantity(
+			recipeIngredient.FloatQuantity, recipeIngredient.StrUnit, float64(recipeIngredient.IntGrams),
+			&recipeIngredient.Ingredient, kitchen, system,
+		)
+	}
+
+	err := server.FillFavorites([]*models.Recipe{recipe}, OptionalUserId(c))
+	if err != nil {
+		log.Printf("Fill favorites: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	var signedURLs map[string]string
+	if !IsPublicRecipe(recipe) {
+		signedURLs = server.RecipeSignedURLs(recipe)
+	}
+
+	c.Response().Header().Set("ETag", WeakETag(RecipeETag(recipe)))
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAuthorization)
+
+	if scale == nil && len(signedURLs) == 0 {
+		return c.JSON(http.StatusOK, recipe)
+	}
+
+	return c.JSON(http.StatusOK, &ScaledRecipeResponse{Recipe: recipe, Scale: scale, SignedURLs: signedURLs})
+}
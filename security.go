@@ -1,11 +1,16 @@
 package main
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"math/big"
 
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Права администратора
+const UserRightsAdmin = 1
+
 var letters []rune = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 // Функция для хэширования пароля
@@ -21,10 +26,23 @@ func CheckPasswordHash(hash string, password string) bool {
 }
 
 // Функция для генерации случайной строки длины n
+//
+// Использует криптографически стойкий генератор
 func RandomString(n int) string {
+	max := big.NewInt(int64(len(letters)))
+
 	b := make([]rune, n)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = letters[index.Int64()]
 	}
 	return string(b)
 }
+
+// Функция для проверки, является ли пользователь администратором
+func IsAdmin(user *models.User) bool {
+	return user.IntUserRights >= UserRightsAdmin
+}
@@ -20,8 +20,14 @@ var (
 		Host: "0.0.0.0",
 		Port: 11111,
 		// DBConnectionInfo: "file::memory:/test?cache=shared", // БД в оперативке
-		TokenKey:    []byte("test"),
-		UploadsPath: "/tmp/test/recipe_book_uploads",
+		TokenKey:            []byte("test"),
+		UploadsPath:         "/tmp/test/recipe_book_uploads",
+		MaxStagePhotos:      3,
+		MaxImageSide:        2000,
+		CoverSizes:          DefaultCoverSizes,
+		PhotoSizes:          DefaultPhotoSizes,
+		UploadBodyLimit:     "1M",
+		DefaultStorageQuota: DefaultStorageQuota,
 	}
 	UserJWT           = ""
 	UserJWT2          = ""
@@ -59,10 +65,28 @@ func TestMain(m *testing.M) {
 		&models.Comment{},
 		&models.Photo{},
 		&models.RecipeIngredient{},
+		&models.OrphanFile{},
+		&models.RecipeRevision{},
+		&models.Collection{},
+		&models.CollectionRecipe{},
+		&models.ShoppingList{},
+		&models.ShoppingListRecipe{},
+		&models.ShoppingListItem{},
+		&models.MealPlanEntry{},
+		&models.PantryItem{},
+		&models.RecipeShare{},
+		&models.RecipeCollaborator{},
 	)
 	if err != nil {
 		panic(err)
 	}
 
+	err = TestServer.InitStorage()
+	if err != nil {
+		panic(err)
+	}
+
+	TestServer.Images = NewImagePool(2)
+
 	os.Exit(m.Run())
 }
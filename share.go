@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Длина ключа ссылки на рецепт
+const ShareTokenLength = 32
+
+// Наибольший срок действия ссылки на рецепт, часов
+const MaxShareHours = 24 * 365
+
+// Ошибка при просроченной ссылке
+var ErrShareExpired = errors.New("срок действия ссылки истёк")
+
+type RecipeShareData struct {
+	ExpiresIn int `json:"expires_in"` // срок действия в часах, 0 - бессрочно
+}
+
+// Функция для получения адреса рецепта по ссылке
+func ShareURL(token string) string {
+	return fmt.Sprintf("/shared/%s", token)
+}
+
+// Функция для получения рецепта по ключу ссылки
+//
+// Ссылка должна быть не отозвана и не просрочена
+func (server *Server) GetSharedRecipe(token string) (*models.Recipe, error) {
+	var share models.RecipeShare
+	err := server.DB.First(&share, "str_share_token = ?", token).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if share.TimeExpiresAt != nil && time.Now().After(*share.TimeExpiresAt) {
+		return nil, ErrShareExpired
+	}
+
+	return server.GetRecipeById(int(share.IntRecipeId))
+}
+
+// Функция для создания ссылки на свой рецепт
+func (server *Server) CreateRecipeShareHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Ссылками управляет только автор рецепта
+	recipe, err := server.recipeForAccess(c, user, RecipeAccessOwner)
+	if recipe == nil {
+		return err
+	}
+
+	var share_data RecipeShareData
+	err = c.Bind(&share_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if share_data.ExpiresIn < 0 || share_data.ExpiresIn > MaxShareHours {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный срок действия ссылки"})
+	}
+
+	share := models.RecipeShare{
+		IntRecipeId:   recipe.ID,
+		StrShareToken: RandomString(ShareTokenLength),
+	}
+	if share_data.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(share_data.ExpiresIn) * time.Hour)
+		share.TimeExpiresAt = &expiresAt
+	}
+
+	err = server.DB.Omit("Recipe").Create(&share).Error
+	if err != nil {
+		log.Printf("Create recipe share: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать ссылку"})
+	}
+
+	return c.JSON(http.StatusOK, &RecipeShareResponse{
+		Message:   "Ссылка создана",
+		Id:        share.ID,
+		Token:     share.StrShareToken,
+		URL:       ShareURL(share.StrShareToken),
+		ExpiresAt: share.TimeExpiresAt,
+	})
+}
+
+// Функция для получения ссылок на свой рецепт
+func (server *Server) GetRecipeSharesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Ссылками управляет только автор рецепта
+	recipe, err := server.recipeForAccess(c, user, RecipeAccessOwner)
+	if recipe == nil {
+		return err
+	}
+
+	shares := []models.RecipeShare{}
+	err = server.DB.Order("id").Find(&shares, "int_recipe_id = ?", recipe.ID).Error
+	if err != nil {
+		log.Printf("Get recipe shares: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось получить ссылки"})
+	}
+
+	return c.JSON(http.StatusOK, shares)
+}
+
+// Функция для отзыва ссылки на свой рецепт
+func (server *Server) RevokeRecipeShareHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Ссылками управляет только автор рецепта
+	recipe, err := server.recipeForAccess(c, user, RecipeAccessOwner)
+	if recipe == nil {
+		return err
+	}
+
+	shareID, err := strconv.Atoi(c.Param("share_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id ссылки"})
+	}
+
+	result := server.DB.Where("id = ? AND int_recipe_id = ?", shareID, recipe.ID).Delete(&models.RecipeShare{})
+	if result.Error != nil {
+		log.Printf("Revoke recipe share: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось отозвать ссылку"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Ссылка не найдена"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Ссылка отозвана"})
+}
+
+// Функция для просмотра рецепта по ссылке
+//
+// Вход не нужен. Для скрытого рецепта в ответ добавляются
+// подписанные ссылки на изображения
+func (server *Server) GetSharedRecipeHandle(c echo.Context) error {
+	recipe, err := server.GetSharedRecipe(c.Param("token"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Ссылка недействительна"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, no-cache")
+	return server.SendRecipe(c, recipe)
+}
+
+// Функция для просмотра комментариев к рецепту по ссылке
+func (server *Server) GetSharedRecipeCommentsHandle(c echo.Context) error {
+	recipe, err := server.GetSharedRecipe(c.Param("token"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Ссылка недействительна"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, no-cache")
+	return c.JSON(http.StatusOK, &recipe.RecipeComments)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Название списка покупок, если пользователь его не указал
+const DefaultShoppingListName = "Список покупок"
+
+// Форматы выгрузки списка покупок
+const (
+	ShoppingListFormatText     = "text"
+	ShoppingListFormatMarkdown = "markdown"
+)
+
+type ShoppingListRecipeData struct {
+	RecipeId uint `json:"recipe_id"`
+	Servings int  `json:"servings"` // если не указано, то как в рецепте
+}
+
+type ShoppingListData struct {
+	Name    string                   `json:"name"`
+	Recipes []ShoppingListRecipeData `json:"recipes"`
+}
+
+type ShoppingListItemData struct {
+	Checked bool `json:"checked"`
+}
+
+// Рецепт с количеством порций, на которое нужно купить продукты
+type ShoppingEntry struct {
+	Recipe   *models.Recipe
+	Servings int
+}
+
+// Функция для перевода количества в основной единице вида
+// (граммы, миллилитры или штуки) в удобную единицу системы мер
+func NormalizeQuantity(base float64, kind string, system string) (float64, string) {
+	var unit string
+	switch kind {
+	case UnitKindMass:
+		unit = UnitGram
+		if base >= 1000 {
+			unit = UnitKilogram
+		}
+	case UnitKindVolume:
+		unit = UnitMilliliter
+		if base >= 1000 {
+			unit = UnitLiter
+		}
+	default:
+		return RoundQuantity(base, UnitPiece), UnitPiece
+	}
+	return ConvertToSystem(base/Units[unit].Factor, unit, system)
+}
+
+// Функция для сведения ингредиентов нескольких рецептов в список покупок
+//
+// Количество каждого ингредиента пересчитывается на нужное число порций
+// и складывается. Если ингредиент указан в рецептах в единицах одного вида
+// (вес, объём или штуки), то складываются именно они, иначе - граммы.
+// Позиции упорядочены по названию
+func AggregateIngredients(entries []ShoppingEntry, system string) []models.ShoppingListItem {
+	type amount struct {
+		ingredient *models.Ingredient
+		kind       string
+		mixed      bool
+		base       float64
+		grams      float64
+	}
+
+	amounts := map[uint]*amount{}
+	for _, entry := range entries {
+		factor := 1.0
+		if entry.Recipe.IntServings > 0 {
+			factor = float64(entry.Servings) / float64(entry.Recipe.IntServings)
+		}
+
+		for i := range entry.Recipe.RecipeIngredients {
+			recipeIngredient := &entry.Recipe.RecipeIngredients[i]
+
+			info, ok := Units[recipeIngredient.StrUnit]
+			kind := info.Kind
+			if kind == UnitKindSpoon {
+				kind = UnitKindVolume
+			}
+
+			item, found := amounts[recipeIngredient.IntIngredientId]
+			if !found {
+				item = &amount{ingredient: &recipeIngredient.Ingredient, kind: kind}
+				amounts[recipeIngredient.IntIngredientId] = item
+			}
+
+			item.mixed = item.mixed || !ok || item.kind != kind
+			item.base += recipeIngredient.FloatQuantity * info.Factor * factor
+			item.grams += float64(recipeIngredient.IntGrams) * factor
+		}
+	}
+
+	items := make([]models.ShoppingListItem, 0, len(amounts))
+	for id, item := range amounts {
+		var quantity float64
+		var unit string
+		if item.mixed {
+			quantity, unit = NormalizeQuantity(item.grams, UnitKindMass, system)
+		} else {
+			quantity, unit = NormalizeQuantity(item.base, item.kind, system)
+		}
+
+		items = append(items, models.ShoppingListItem{
+			IntIngredientId: id,
+			StrItemName:     item.ingredient.StrIngredientName,
+			FloatQuantity:   quantity,
+			StrUnit:         unit,
+			FloatGrams:      RoundGrams(item.grams),
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StrItemName < items[j].StrItemName
+	})
+
+	return items
+}
+
+// Функция для выгрузки списка покупок в текст или Markdown
+func FormatShoppingList(list *models.ShoppingList, format string) string {
+	var builder strings.Builder
+
+	if format == ShoppingListFormatMarkdown {
+		fmt.Fprintf(&builder, "# %s\n\n", list.StrListName)
+	} else {
+		fmt.Fprintf(&builder, "%s\n\n", list.StrListName)
+	}
+
+	for _, item := range list.ListItems {
+		mark := " "
+		if item.BoolItemChecked {
+			mark = "x"
+		}
+		quantity := strconv.FormatFloat(item.FloatQuantity, 'f', -1, 64)
+
+		if format == ShoppingListFormatMarkdown {
+			fmt.Fprintf(&builder, "- [%s] **%s** — %s %s\n", mark, item.StrItemName, quantity, item.StrUnit)
+		} else {
+			fmt.Fprintf(&builder, "[%s] %s - %s %s\n", mark, item.StrItemName, quantity, item.StrUnit)
+		}
+	}
+
+	if len(list.ListRecipes) > 0 {
+		if format == ShoppingListFormatMarkdown {
+			builder.WriteString("\n## Рецепты\n\n")
+		} else {
+			builder.WriteString("\nРецепты:\n")
+		}
+		for _, recipe := range list.ListRecipes {
+			fmt.Fprintf(&builder, "- %s (порций: %d)\n", recipe.StrRecipeName, recipe.IntServings)
+		}
+	}
+
+	return builder.String()
+}
+
+// Функция для получения списка покупок вместе с позициями
+func (server *Server) GetShoppingListById(id int) (*models.ShoppingList, error) {
+	var list models.ShoppingList
+
+	err := server.DB.
+		Preload("ListRecipes").
+		Preload("ListItems", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).
+		First(&list, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// Функция для составления списка покупок по рецептам
+//
+// Для каждого рецепта можно указать количество порций. Количество
+// показывается в системе мер из параметра units или из настроек пользователя
+func (server *Server) CreateShoppingListHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var list_data ShoppingListData
+	err = c.Bind(&list_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	if len(list_data.Recipes) == 0 {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не выбраны рецепты"})
+	}
+
+	list := models.ShoppingList{
+		StrListName: list_data.Name,
+		IntUserId:   user.ID,
+	}
+	if list.StrListName == "" {
+		list.StrListName = DefaultShoppingListName
+	}
+
+	entries := make([]ShoppingEntry, 0, len(list_data.Recipes))
+	for _, recipe_data := range list_data.Recipes {
+		recipe, err := server.GetRecipeById(int(recipe_data.RecipeId))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, &DefaultResponse{Message: fmt.Sprintf("Не удалось найти рецепт %d", recipe_data.RecipeId)})
+		}
+
+		// Скрытые рецепты и черновики доступны только автору и соавторам
+		if !server.CanReadRecipe(user, recipe) {
+			return c.JSON(http.StatusNotFound, &DefaultResponse{Message: fmt.Sprintf("Не удалось найти рецепт %d", recipe_data.RecipeId)})
+		}
+
+		servings := recipe_data.Servings
+		if servings == 0 {
+			servings = recipe.IntServings
+		}
+		if servings < 0 || servings > MaxScaleServings {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверное количество порций"})
+		}
+
+		entries = append(entries, ShoppingEntry{Recipe: recipe, Servings: servings})
+		list.ListRecipes = append(list.ListRecipes, models.ShoppingListRecipe{
+			IntRecipeId:   recipe.ID,
+			StrRecipeName: recipe.StrRecipeName,
+			IntServings:   servings,
+		})
+	}
+
+	list.ListItems = AggregateIngredients(entries, server.GetUnitSystem(c))
+
+	err = server.DB.Create(&list).Error
+	if err != nil {
+		log.Printf("Create shopping list: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось создать список покупок"})
+	}
+
+	return c.JSON(http.StatusOK, &ShoppingListResponse{Message: "Список покупок создан", Id: list.ID})
+}
+
+// Функция для получения списков покупок пользователя
+func (server *Server) GetShoppingListsHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	var lists []models.ShoppingList
+	err = server.DB.Preload("ListRecipes").Order("id DESC").Find(&lists, "int_user_id = ?", user.ID).Error
+	if err != nil {
+		log.Printf("Get shopping lists: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти списки покупок"})
+	}
+
+	return c.JSON(http.StatusOK, lists)
+}
+
+// Функция для получения списка покупок
+func (server *Server) GetShoppingListHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id списка покупок"})
+	}
+
+	list, err := server.GetShoppingListById(listID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Список покупок не найден"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец списка
+	if user.ID != list.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список покупок принадлежит другому пользователю"})
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// Функция для отметки позиции списка покупок купленной или некупленной
+func (server *Server) CheckShoppingListItemHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id списка покупок"})
+	}
+
+	itemID, err := strconv.Atoi(c.Param("item_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id позиции"})
+	}
+
+	var item_data ShoppingListItemData
+	err = c.Bind(&item_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	var list models.ShoppingList
+	err = server.DB.First(&list, "id = ?", listID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Список покупок не найден"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец списка
+	if user.ID != list.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список покупок принадлежит другому пользователю"})
+	}
+
+	result := server.DB.Model(&models.ShoppingListItem{}).
+		Where("id = ? AND int_shopping_list_id = ?", itemID, list.ID).
+		Update("bool_item_checked", item_data.Checked)
+	if result.Error != nil {
+		log.Printf("Check shopping list item: %s", result.Error.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить позицию"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Позиция не найдена"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Позиция изменена"})
+}
+
+// Функция для удаления списка покупок
+func (server *Server) DeleteShoppingListHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id списка покупок"})
+	}
+
+	var list models.ShoppingList
+	err = server.DB.First(&list, "id = ?", listID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Список покупок не найден"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец списка
+	if user.ID != list.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список покупок принадлежит другому пользователю"})
+	}
+
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("int_shopping_list_id = ?", list.ID).Delete(&models.ShoppingListItem{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("int_shopping_list_id = ?", list.ID).Delete(&models.ShoppingListRecipe{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&list).Error
+	})
+	if err != nil {
+		log.Printf("Delete shopping list: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить список покупок"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Список покупок удален"})
+}
+
+// Функция для выгрузки списка покупок
+//
+// Параметр format: text (по умолчанию) или markdown
+func (server *Server) ExportShoppingListHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = ShoppingListFormatText
+	}
+	if format != ShoppingListFormatText && format != ShoppingListFormatMarkdown {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный формат"})
+	}
+
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id списка покупок"})
+	}
+
+	list, err := server.GetShoppingListById(listID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Список покупок не найден"})
+	}
+
+	// Проверка на то, что текущий пользователь - владелец списка
+	if user.ID != list.IntUserId {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список покупок принадлежит другому пользователю"})
+	}
+
+	contentType := "text/plain; charset=UTF-8"
+	if format == ShoppingListFormatMarkdown {
+		contentType = "text/markdown; charset=UTF-8"
+	}
+
+	return c.Blob(http.StatusOK, contentType, []byte(FormatShoppingList(list, format)))
+}
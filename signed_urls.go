@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+)
+
+// Параметры подписанной ссылки на файл
+const (
+	ExpiresParam   = "expires"   // время окончания действия ссылки (unix)
+	SignatureParam = "signature" // HMAC-SHA256 от имени файла и времени окончания
+)
+
+// Функция для получения подписи имени файла
+func (server *Server) SignFileName(filename string, expires int64) string {
+	mac := hmac.New(sha256.New, server.TokenKey)
+	fmt.Fprintf(mac, "assets\n%s\n%d", filename, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Функция для получения подписанной ссылки на файл
+//
+// Ссылка действует server.GetPresignExpiry() и открывает
+// файл вместе со всеми его копиями (параметр variant и форматы)
+func (server *Server) SignedFileURL(filename string) string {
+	expires := time.Now().Add(server.GetPresignExpiry()).Unix()
+
+	query := url.Values{}
+	query.Set(ExpiresParam, strconv.FormatInt(expires, 10))
+	query.Set(SignatureParam, server.SignFileName(filename, expires))
+
+	return fmt.Sprintf("/assets/%s?%s", filename, query.Encode())
+}
+
+// Функция для проверки подписи ссылки на файл
+//
+// Возвращает время окончания действия ссылки, если подпись верна и не истекла
+func (server *Server) VerifyFileSignature(filename string, expiresStr string, signature string) (time.Time, bool) {
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || signature == "" {
+		return time.Time{}, false
+	}
+
+	expected := server.SignFileName(filename, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return time.Time{}, false
+	}
+
+	expiresAt := time.Unix(expires, 0)
+	if time.Now().After(expiresAt) {
+		return time.Time{}, false
+	}
+
+	return expiresAt, true
+}
+
+// Функция для получения общей части имён изображения и его копий
+//
+// Копии называются <имя>_<вариант>.<расширение> и <имя>.webp,
+// а в самих именах изображений подчёркиваний нет
+func ImageFamily(filename string) string {
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	family, _, _ := strings.Cut(base, "_")
+	return family
+}
+
+// Функция для проверки, что файл относится только к скрытым рецептам
+//
+// Файл считается закрытым, если он (или изображение, копией которого он
+// является) используется в скрытых рецептах или черновиках и не используется
+// ни в одном доступном всем рецепте (см. IsPublicRecipe) или профиле. Такие файлы отдаются только
+// по подписанным ссылкам
+func (server *Server) IsPrivateFile(filename string) (bool, error) {
+	private, _, err := server.CheckFileAccess(filename)
+	return private, err
+}
+
+// Функция для проверки доступа к файлу
+//
+// Возвращает, что файл закрытый (см. IsPrivateFile) и что он используется
+// хотя бы в одном рецепте. Доступ к таким файлам может измениться вместе
+// с рецептом, поэтому их нельзя надолго кэшировать
+func (server *Server) CheckFileAccess(filename string) (private bool, recipeFile bool, err error) {
+	pattern := ImageFamily(filename) + ".%"
+
+	var recipes []models.Recipe
+	err = server.DB.Model(&models.Recipe{}).
+		Select("bool_recipe_visibility, str_recipe_status").
+		Where("str_recipe_image LIKE ?", pattern).
+		Find(&recipes).Error
+	if err != nil {
+		return false, false, err
+	}
+
+	var photoRecipes []models.Recipe
+	err = server.DB.Model(&models.Photo{}).
+		Scopes(LiveStagePhotos).
+		Select("recipes.bool_recipe_visibility, recipes.str_recipe_status").
+		Where("photos.str_image LIKE ?", pattern).
+		Scan(&photoRecipes).Error
+	if err != nil {
+		return false, false, err
+	}
+
+	recipes = append(recipes, photoRecipes...)
+	if len(recipes) == 0 {
+		return false, false, nil
+	}
+	for i := range recipes {
+		if IsPublicRecipe(&recipes[i]) {
+			return false, true, nil
+		}
+	}
+
+	var users int64
+	err = server.DB.Model(&models.User{}).Where("str_user_image LIKE ?", pattern).Count(&users).Error
+	if err != nil {
+		return false, false, err
+	}
+
+	return users == 0, true, nil
+}
+
+// Функция для получения подписанных ссылок на изображения рецепта
+//
+// Возвращает словарь "имя файла - ссылка" для обложки, фото этапов и их копий
+func (server *Server) RecipeSignedURLs(recipe *models.Recipe) map[string]string {
+	urls := map[string]string{}
+	add := func(filename string, variants map[string]string) {
+		if filename == "" {
+			return
+		}
+		urls[filename] = server.SignedFileURL(filename)
+		for _, variant := range variants {
+			urls[variant] = server.SignedFileURL(variant)
+		}
+	}
+
+	add(recipe.StrRecipeImage, recipe.RecipeImageVariants)
+	for _, stage := range recipe.RecipeStages {
+		for _, photo := range stage.StagePhotos {
+			add(photo.StrImage, photo.PhotoVariants)
+		}
+	}
+
+	return urls
+}
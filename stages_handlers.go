@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,10 +9,30 @@ import (
 
 	"github.com/Recipe-book-PetrSU-2022/backend/models"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
+// Ошибка при вставке этапа на несуществующую позицию
+var ErrWrongStagePosition = errors.New("неверная позиция этапа")
+
+// Ошибка при неполном или повторяющемся списке этапов
+var ErrWrongStagesOrder = errors.New("неверный порядок этапов")
+
 type StageData struct {
 	Description string `json:"description"`
+	Position    *int   `json:"position"` // позиция с нуля, по умолчанию в конец
+}
+
+type PhotoData struct {
+	Caption string `json:"caption"`
+}
+
+type PhotosOrderData struct {
+	Photos []uint `json:"photos"` // ID всех фото этапа в нужном порядке
+}
+
+type StagesOrderData struct {
+	Stages []uint `json:"stages"` // ID всех этапов рецепта в нужном порядке
 }
 
 // Функция для создания этапа
@@ -36,8 +57,8 @@ func (server *Server) CreateStageHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
 	}
 
-	// Проверка на то, что текущий пользователь автор рецепта
-	if user.ID != recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
@@ -48,16 +69,44 @@ func (server *Server) CreateStageHandle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
 	}
 
-	log.Printf("stage data = %+v", stage_data)
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
 
 	// Создаем этап
 	stage := models.Stage{
 		StrStageDesc: stage_data.Description,
-		Recipe:       *recipe,
+		IntRecipeId:  recipe.ID,
 	}
 
-	// Сохраняем этап в БД
-	err = server.DB.Create(&stage).Error
+	// Сохраняем этап в БД и сдвигаем следующие за ним этапы
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		stages, err := GetRecipeStages(tx, recipe.ID)
+		if err != nil {
+			return err
+		}
+
+		position := len(stages)
+		if stage_data.Position != nil {
+			position = *stage_data.Position
+		}
+		if position < 0 || position > len(stages) {
+			return ErrWrongStagePosition
+		}
+
+		stage.IntStageOrder = position
+		err = tx.Create(&stage).Error
+		if err != nil {
+			return err
+		}
+
+		stages = append(stages[:position], append([]models.Stage{stage}, stages[position:]...)...)
+		return SaveStagesOrder(tx, stages)
+	})
+	if err == ErrWrongStagePosition {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверная позиция этапа"})
+	}
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError, &DefaultResponse{
@@ -66,6 +115,9 @@ func (server *Server) CreateStageHandle(c echo.Context) error {
 		)
 	}
 
+	// Сохраняем версию рецепта
+	server.RecordRecipeRevision(recipe.ID, user.ID, "Создан новый этап")
+
 	return c.JSON(http.StatusOK, &StageResponse{Message: "Создан новый этап", Id: stage.ID})
 }
 
@@ -91,12 +143,35 @@ func (server *Server) DeleteStageHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
 	}
 
-	// Проверка на то, что текущий пользователь автор рецепта
-	if user.ID != stage.Recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, &stage.Recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
-	err = server.DB.Unscoped().Delete(&stage).Error
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, &stage.Recipe); !ok {
+		return err
+	}
+
+	// Удаляем этап вместе с фото и сдвигаем оставшиеся этапы
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("int_stage_id = ?", stage.ID).Delete(&models.Photo{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Delete(&stage).Error
+		if err != nil {
+			return err
+		}
+
+		stages, err := GetRecipeStages(tx, stage.IntRecipeId)
+		if err != nil {
+			return err
+		}
+
+		return SaveStagesOrder(tx, stages)
+	})
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError, &DefaultResponse{
@@ -105,9 +180,46 @@ func (server *Server) DeleteStageHandle(c echo.Context) error {
 		)
 	}
 
+	// Удаляем файлы фото этапа
+	server.ChargeStorage(stage.Recipe.IntUserId, -PhotosBytes(stage.StagePhotos))
+	server.ReleasePhotos(stage.StagePhotos)
+
+	// Сохраняем версию рецепта
+	server.RecordRecipeRevision(stage.IntRecipeId, user.ID, "Этап удален")
+
 	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Этап удален"})
 }
 
+// Функция для получения этапа своего рецепта
+//
+// В заголовке ETag передаётся версия этапа для If-Match при изменении
+func (server *Server) GetStageHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	stageID, err := strconv.Atoi(c.Param("stage_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id этапа"})
+	}
+
+	stage, err := server.GetStageById(stageID)
+	if err != nil {
+		log.Printf("Get stage by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
+	}
+
+	// Проверка на то, что пользователь - автор или соавтор рецепта
+	if !server.CanAccessRecipe(user, &stage.Recipe, RecipeAccessView) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	c.Response().Header().Set("ETag", StageETag(stage))
+	return c.JSON(http.StatusOK, stage)
+}
+
 // Функция для обновления данных об этапе
 func (server *Server) UpdateStageHandle(c echo.Context) error {
 	//
@@ -129,8 +241,8 @@ func (server *Server) UpdateStageHandle(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
 	}
 
-	// Проверка на то, что текущий пользователь автор рецепта
-	if user.ID != stage.Recipe.IntUserId {
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, &stage.Recipe, RecipeAccessEdit) {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
 	}
 
@@ -141,11 +253,18 @@ func (server *Server) UpdateStageHandle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
 	}
 
-	// Обновляем описание этапа
-	stage.StrStageDesc = stage_data.Description
+	// Проверяем, что этап не изменили с момента получения
+	if ok, err := checkIfMatch(c, StageETag(stage), stage); !ok {
+		return err
+	}
 
-	// Сохраняем изменееные данные в БД
-	err = server.DB.Save(&stage).Error
+	// Сохраняем описание этапа в БД, если его версия не изменилась
+	err = UpdateVersioned(server.DB, &models.Stage{}, stage.ID, stage.IntVersion, map[string]interface{}{
+		"str_stage_desc": stage_data.Description,
+	})
+	if err == ErrVersionConflict {
+		return server.sendStageConflict(c, stage.ID)
+	}
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError, &DefaultResponse{
@@ -153,76 +272,391 @@ func (server *Server) UpdateStageHandle(c echo.Context) error {
 			},
 		)
 	}
+	stage.IntVersion++
 
+	// Сохраняем версию рецепта
+	server.BumpRecipeVersion(stage.IntRecipeId)
+	server.RecordRecipeRevision(stage.IntRecipeId, user.ID, "Этап обновлен")
+
+	c.Response().Header().Set("ETag", StageETag(stage))
 	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Этап обновлен"})
 }
 
+// Функция для изменения порядка этапов рецепта
+//
+// Принимает полный список ID этапов рецепта в новом порядке
+// и сохраняет его в одной транзакции
+func (server *Server) ReorderStagesHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID рецепта, этапы которого будем переставлять
+	recipeID, err := strconv.Atoi(c.Param("recipe_id"))
+	if err != nil {
+		log.Printf("Recipe id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
+	}
+
+	// Получаем информацию о рецепте
+	recipe, err := server.GetRecipeById(recipeID)
+	if err != nil {
+		log.Printf("Get recipe by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти рецепт"})
+	}
+
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, recipe, RecipeAccessEdit) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	// Получаем новый порядок этапов с фронтенда
+	var order_data StagesOrderData
+	err = c.Bind(&order_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, recipe); !ok {
+		return err
+	}
+
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		stages, err := GetRecipeStages(tx, recipe.ID)
+		if err != nil {
+			return err
+		}
+
+		// Список должен содержать каждый этап рецепта ровно один раз
+		if len(order_data.Stages) != len(stages) {
+			return ErrWrongStagesOrder
+		}
+
+		stagesById := make(map[uint]models.Stage, len(stages))
+		for _, stage := range stages {
+			stagesById[stage.ID] = stage
+		}
+
+		ordered := make([]models.Stage, 0, len(stages))
+		for _, stageID := range order_data.Stages {
+			stage, ok := stagesById[stageID]
+			if !ok {
+				return ErrWrongStagesOrder
+			}
+			delete(stagesById, stageID)
+			ordered = append(ordered, stage)
+		}
+
+		return SaveStagesOrder(tx, ordered)
+	})
+	if err == ErrWrongStagesOrder {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список должен содержать все этапы рецепта по одному разу"})
+	}
+	if err != nil {
+		log.Printf("Reorder stages: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить порядок этапов"})
+	}
+
+	// Сохраняем версию рецепта
+	server.RecordRecipeRevision(recipe.ID, user.ID, "Порядок этапов изменен")
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Порядок этапов изменен"})
+}
+
 // Функция для добавления фото к этапу
-// func (server *Server) AddStagePhotoHandle(c echo.Context) error {
-// 	// Получаем информацию о пользователе
-// 	user, err := server.GetUserByClaims(c)
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
-// 	}
-
-// 	// Получаем ID рецепта, к которому будет добавлен этап
-// 	recipeID, err := strconv.Atoi(c.Param("stage_id"))
-// 	if err != nil {
-// 		log.Printf("Recipe id: %s", err.Error())
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id рецепта"})
-// 	}
-
-// 	// Получаем информацию об этапе, который будем изменять
-// 	stage, err := server.GetStageById(recipeID)
-// 	if err != nil {
-// 		log.Printf("Get stage by id: %s", err.Error())
-// 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
-// 	}
-
-// 	// Проверка на то, что текущий пользователь автор рецепта
-// 	if user.ID != stage.Recipe.IntUserId {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
-// 	}
-
-// 	// Получаем файл
-// 	file, err := c.FormFile("file")
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить файл из формы: %s", err.Error())})
-// 	}
-
-// 	// Получаем расширение файла
-// 	fileExt, err := server.GetFileExtByMimetype(file)
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось определить тип файла: %s", err.Error())})
-// 	}
-
-// 	// Пытаемся прочитать файл
-// 	src, err := file.Open()
-// 	if err != nil {
-// 		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось прочитать файл: %s", err.Error())})
-// 	}
-// 	defer src.Close()
-
-// 	// Сохраняем файл
-// 	filename, err := server.SaveFileWithExt(src, fileExt)
-// 	if err != nil {
-// 		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: fmt.Sprintf("Не удалось сохранить файл: %s", err.Error())})
-// 	}
-
-// 	// Добавляем имя файла к этапу
-// 	stage.StagePhotos = append(stage.StagePhotos, models.Photo{
-// 		StrImage: filename,
-// 	})
-
-// 	// Обновляем данные об этапе в БД
-// 	err = server.DB.Save(&stage).Error
-// 	if err != nil {
-// 		return c.JSON(
-// 			http.StatusInternalServerError, &DefaultResponse{
-// 				Message: "Не удалось обновить этап",
-// 			},
-// 		)
-// 	}
-
-// 	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Этап обновлен"})
-// }
+//
+// В поле формы file можно передать несколько файлов сразу,
+// поле caption задаёт подпись для всех загружаемых фото
+func (server *Server) AddStagePhotoHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID этапа, к которому будет добавлено фото
+	stageID, err := strconv.Atoi(c.Param("stage_id"))
+	if err != nil {
+		log.Printf("Stage id: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id этапа"})
+	}
+
+	// Получаем информацию об этапе, который будем изменять
+	stage, err := server.GetStageById(stageID)
+	if err != nil {
+		log.Printf("Get stage by id: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
+	}
+
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, &stage.Recipe, RecipeAccessEdit) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	// Получаем файлы из формы
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить файл из формы: %s", err.Error())})
+	}
+
+	files := form.File["file"]
+	if len(files) == 0 {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось получить файл из формы"})
+	}
+
+	// Проверяем, что не превышено количество фото у этапа
+	if len(stage.StagePhotos)+len(files) > server.MaxStagePhotos {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("У этапа может быть не больше %d фото", server.MaxStagePhotos)})
+	}
+
+	// Проверяем квоту автора рецепта до сохранения файлов
+	var uploadSize int64
+	for _, file := range files {
+		uploadSize += file.Size
+	}
+	owner, err := server.GetRecipeOwner(&stage.Recipe)
+	if err != nil {
+		log.Printf("Get recipe owner: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+	err = server.CheckStorageQuota(owner, uploadSize)
+	if err != nil {
+		return c.JSON(http.StatusRequestEntityTooLarge, &DefaultResponse{Message: "Превышена квота на хранение файлов"})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, &stage.Recipe); !ok {
+		return err
+	}
+
+	// Сохраняем файлы вместе с уменьшенными копиями
+	photos := make([]models.Photo, 0, len(files))
+	for i, file := range files {
+		filename, err := server.SaveFormFile(file)
+		if err != nil {
+			server.ReleasePhotos(photos)
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: err.Error()})
+		}
+
+		variants, err := server.SaveImageVariants(filename, server.PhotoSizes)
+		if err != nil {
+			server.ReleaseImage(filename, ImageVariantNames(filename, server.PhotoSizes))
+			server.ReleasePhotos(photos)
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось обработать изображение: %s", err.Error())})
+		}
+
+		// Добавляем фото в конец списка фото этапа
+		photos = append(photos, models.Photo{
+			StrImage:      filename,
+			PhotoVariants: variants,
+			IntImageSize:  server.StoredImageBytes(filename, variants),
+			StrCaption:    c.FormValue("caption"),
+			IntPhotoOrder: len(stage.StagePhotos) + i,
+			IntStageId:    stage.ID,
+		})
+	}
+
+	// Занимаем место под фото вместе с копиями
+	err = server.ReserveStorage(owner, PhotosBytes(photos))
+	if err == ErrQuotaExceeded {
+		server.ReleasePhotos(photos)
+		return c.JSON(http.StatusRequestEntityTooLarge, &DefaultResponse{Message: "Превышена квота на хранение файлов"})
+	}
+	if err != nil {
+		server.ReleasePhotos(photos)
+		log.Printf("Reserve storage: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить этап"})
+	}
+
+	// Сохраняем фото в БД
+	err = server.DB.Create(&photos).Error
+	if err != nil {
+		server.ChargeStorage(owner.ID, -PhotosBytes(photos))
+		server.ReleasePhotos(photos)
+		return c.JSON(
+			http.StatusInternalServerError, &DefaultResponse{
+				Message: "Не удалось обновить этап",
+			},
+		)
+	}
+
+	return c.JSON(http.StatusOK, &PhotosResponse{Message: "Этап обновлен", Photos: photos})
+}
+
+// Функция для изменения порядка фото этапа
+//
+// Принимает полный список ID фото этапа в новом порядке
+func (server *Server) ReorderStagePhotosHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID этапа
+	stageID, err := strconv.Atoi(c.Param("stage_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id этапа"})
+	}
+
+	// Получаем информацию об этапе вместе с фото
+	stage, err := server.GetStageById(stageID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось найти этап"})
+	}
+
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, &stage.Recipe, RecipeAccessEdit) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	// Получаем новый порядок фото с фронтенда
+	var order_data PhotosOrderData
+	err = c.Bind(&order_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	// Список должен содержать каждое фото этапа ровно один раз
+	if len(order_data.Photos) != len(stage.StagePhotos) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список должен содержать все фото этапа по одному разу"})
+	}
+
+	photosById := make(map[uint]models.Photo, len(stage.StagePhotos))
+	for _, photo := range stage.StagePhotos {
+		photosById[photo.ID] = photo
+	}
+
+	ordered := make([]models.Photo, 0, len(stage.StagePhotos))
+	for _, photoID := range order_data.Photos {
+		photo, ok := photosById[photoID]
+		if !ok {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Список должен содержать все фото этапа по одному разу"})
+		}
+		delete(photosById, photoID)
+		ordered = append(ordered, photo)
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, &stage.Recipe); !ok {
+		return err
+	}
+
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		return SavePhotosOrder(tx, ordered)
+	})
+	if err != nil {
+		log.Printf("Reorder photos: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось изменить порядок фото"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Порядок фото изменен"})
+}
+
+// Функция для изменения подписи к фото этапа
+func (server *Server) UpdateStagePhotoHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID фото
+	photoID, err := strconv.Atoi(c.Param("photo_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id фото"})
+	}
+
+	// Получаем информацию о фото
+	photo, err := server.GetPhotoById(photoID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Фото не найдено"})
+	}
+
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, &photo.Stage.Recipe, RecipeAccessEdit) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	// Получаем данные с фронтенда
+	var photo_data PhotoData
+	err = c.Bind(&photo_data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: fmt.Sprintf("Не удалось получить данные от пользователя: %s", err.Error())})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, &photo.Stage.Recipe); !ok {
+		return err
+	}
+
+	// Обновляем подпись к фото
+	err = server.DB.Model(photo).Update("str_caption", photo_data.Caption).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось обновить фото"})
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Фото обновлено"})
+}
+
+// Функция для удаления фото этапа
+//
+// Вместе с записью в БД удаляется и сам файл
+func (server *Server) DeleteStagePhotoHandle(c echo.Context) error {
+	// Получаем информацию о пользователе
+	user, err := server.GetUserByClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Не удалось найти пользователя"})
+	}
+
+	// Получаем ID фото
+	photoID, err := strconv.Atoi(c.Param("photo_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неверный id фото"})
+	}
+
+	// Получаем информацию о фото
+	photo, err := server.GetPhotoById(photoID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &DefaultResponse{Message: "Фото не найдено"})
+	}
+
+	// Проверка на то, что пользователь может изменять рецепт
+	if !server.CanAccessRecipe(user, &photo.Stage.Recipe, RecipeAccessEdit) {
+		return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Рецепт принадлежит другому пользователю"})
+	}
+
+	// Проверяем, что рецепт не изменили с момента получения
+	if ok, err := server.claimRecipeVersion(c, &photo.Stage.Recipe); !ok {
+		return err
+	}
+
+	// Удаляем фото и сдвигаем оставшиеся фото этапа
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Delete(photo).Error
+		if err != nil {
+			return err
+		}
+
+		var photos []models.Photo
+		err = OrderPhotos(tx).Find(&photos, "int_stage_id = ?", photo.IntStageId).Error
+		if err != nil {
+			return err
+		}
+
+		return SavePhotosOrder(tx, photos)
+	})
+	if err != nil {
+		log.Printf("Delete photo: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, &DefaultResponse{Message: "Не удалось удалить фото"})
+	}
+
+	// Файл удаляем только после успешного удаления записи
+	server.ChargeStorage(photo.Stage.Recipe.IntUserId, -photo.IntImageSize)
+	server.ReleaseImage(photo.StrImage, photo.PhotoVariants)
+
+	return c.JSON(http.StatusOK, &DefaultResponse{Message: "Фото удалено"})
+}
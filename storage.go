@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"time"
+)
+
+// Драйверы хранилища файлов
+const (
+	StorageDriverLocal = "local" // папка на диске сервера
+	StorageDriverS3    = "s3"    // S3-совместимое хранилище (MinIO, AWS S3 и т.д.)
+)
+
+// Время жизни подписанной ссылки на файл по умолчанию
+const DefaultPresignExpiry = 15 * time.Minute
+
+// Ошибки хранилища
+var (
+	ErrFileNotFound         = errors.New("файл не найден")
+	ErrPresignNotSupported  = errors.New("хранилище не поддерживает подписанные ссылки")
+	ErrUnknownStorageDriver = errors.New("неизвестный драйвер хранилища")
+)
+
+// Информация о файле в хранилище
+//
+// Переменные структуры:
+//   - Имя файла
+//   - Размер в байтах
+//   - Время последнего изменения
+//   - MIME-тип файла
+type FileInfo struct {
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Хранилище загруженных файлов
+//
+// Файлы адресуются только по имени, без папок. Put перезаписывает
+// файл с тем же именем, Delete не считает ошибкой отсутствие файла,
+// Get и Stat возвращают ErrFileNotFound, если файла нет.
+// Возвращаемый Get поток нужно закрыть. List возвращает все файлы хранилища
+type Storage interface {
+	Put(name string, r io.Reader, size int64, contentType string) error
+	Get(name string) (ReadSeekCloser, *FileInfo, error)
+	Delete(name string) error
+	Stat(name string) (*FileInfo, error)
+	List() ([]FileInfo, error)
+	PresignedURL(name string, expires time.Duration) (string, error)
+}
+
+// Поток файла из хранилища с возможностью перемотки
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// Функция для получения MIME-типа файла по его имени
+func ContentTypeByName(name string) string {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// Функция для создания хранилища по настройкам сервера
+//
+// Для локального хранилища создаётся папка для загрузок,
+// для S3 - бакет, если его ещё нет
+func (server *Server) InitStorage() error {
+	switch server.StorageDriver {
+	case "", StorageDriverLocal:
+		storage, err := NewLocalStorage(server.UploadsPath)
+		if err != nil {
+			return err
+		}
+		server.Storage = storage
+	case StorageDriverS3:
+		storage, err := NewS3Storage(server.S3)
+		if err != nil {
+			return err
+		}
+
+		err = storage.EnsureBucket()
+		if err != nil {
+			return err
+		}
+		server.Storage = storage
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownStorageDriver, server.StorageDriver)
+	}
+
+	return nil
+}
+
+// Функция для получения времени жизни подписанных ссылок
+func (server *Server) GetPresignExpiry() time.Duration {
+	if server.PresignExpiry <= 0 {
+		return DefaultPresignExpiry
+	}
+	return server.PresignExpiry
+}
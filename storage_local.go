@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Хранилище файлов в папке на диске сервера
+type LocalStorage struct {
+	Root string // папка, в которой лежат файлы
+}
+
+// Функция для создания локального хранилища
+//
+// Папка создаётся, если её ещё нет
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalStorage{Root: root}, nil
+}
+
+// Функция для получения пути к файлу
+//
+// Берётся только последняя часть имени, чтобы нельзя было
+// выйти за пределы папки хранилища
+func (storage *LocalStorage) filePath(name string) string {
+	return path.Join(storage.Root, path.Base(name))
+}
+
+// Функция для сохранения файла
+//
+// Файл сначала пишется во временный и только потом переименовывается,
+// поэтому читатели никогда не видят недописанный файл
+func (storage *LocalStorage) Put(name string, r io.Reader, size int64, contentType string) error {
+	tmp, err := os.CreateTemp(storage.Root, ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), storage.filePath(name))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Функция для открытия файла на чтение
+func (storage *LocalStorage) Get(name string) (ReadSeekCloser, *FileInfo, error) {
+	file, err := os.Open(storage.filePath(name))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, localFileInfo(name, stat), nil
+}
+
+// Функция для удаления файла
+func (storage *LocalStorage) Delete(name string) error {
+	err := os.Remove(storage.filePath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Функция для получения информации о файле
+func (storage *LocalStorage) Stat(name string) (*FileInfo, error) {
+	stat, err := os.Stat(storage.filePath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return localFileInfo(name, stat), nil
+}
+
+// Функция для получения списка всех файлов
+//
+// Временные файлы недописанных загрузок не возвращаются
+func (storage *LocalStorage) List() ([]FileInfo, error) {
+	entries, err := os.ReadDir(storage.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		stat, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, *localFileInfo(entry.Name(), stat))
+	}
+
+	return files, nil
+}
+
+// Локальное хранилище отдаёт файлы только через сервер
+func (storage *LocalStorage) PresignedURL(name string, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// Функция для перевода информации о файле на диске в FileInfo
+func localFileInfo(name string, stat os.FileInfo) *FileInfo {
+	return &FileInfo{
+		Name:        path.Base(name),
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		ContentType: ContentTypeByName(name),
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Регион S3 по умолчанию, MinIO принимает его без настройки
+const DefaultS3Region = "us-east-1"
+
+// Время ожидания операций с S3 по умолчанию
+const DefaultS3Timeout = 30 * time.Second
+
+// Настройки подключения к S3-совместимому хранилищу
+//
+// Переменные структуры:
+//   - Адрес хранилища (host:port)
+//   - Адрес, по которому хранилище доступно клиентам (для подписанных ссылок)
+//   - Ключ доступа
+//   - Секретный ключ
+//   - Имя бакета
+//   - Регион
+//   - Использовать ли HTTPS
+//   - Время ожидания операций
+type S3Config struct {
+	Endpoint       string
+	PublicEndpoint string
+	AccessKey      string
+	SecretKey      string
+	Bucket         string
+	Region         string
+	UseSSL         bool
+	Timeout        time.Duration
+}
+
+// Хранилище файлов в S3-совместимом объектном хранилище
+type S3Storage struct {
+	client  *minio.Client // клиент для операций с файлами
+	public  *minio.Client // клиент для подписи ссылок для клиентов
+	bucket  string
+	region  string
+	timeout time.Duration
+}
+
+// Функция для создания S3-хранилища
+//
+// Подключение к хранилищу при этом не проверяется
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("не указан адрес хранилища S3 или имя бакета")
+	}
+
+	if config.Region == "" {
+		config.Region = DefaultS3Region
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultS3Timeout
+	}
+
+	newClient := func(endpoint string) (*minio.Client, error) {
+		return minio.New(endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+			Secure: config.UseSSL,
+			Region: config.Region,
+		})
+	}
+
+	client, err := newClient(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Подпись включает адрес хранилища, поэтому ссылки для клиентов
+	// подписываются тем адресом, по которому они будут открываться
+	public := client
+	if config.PublicEndpoint != "" {
+		public, err = newClient(config.PublicEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3Storage{
+		client:  client,
+		public:  public,
+		bucket:  config.Bucket,
+		region:  config.Region,
+		timeout: config.Timeout,
+	}, nil
+}
+
+// Функция для создания бакета, если его ещё нет
+func (storage *S3Storage) EnsureBucket() error {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.timeout)
+	defer cancel()
+
+	exists, err := storage.client.BucketExists(ctx, storage.bucket)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return storage.client.MakeBucket(ctx, storage.bucket, minio.MakeBucketOptions{Region: storage.region})
+}
+
+// Функция для сохранения файла
+func (storage *S3Storage) Put(name string, r io.Reader, size int64, contentType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.timeout)
+	defer cancel()
+
+	_, err := storage.client.PutObject(ctx, storage.bucket, path.Base(name), r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// Функция для открытия файла на чтение
+//
+// Файл читается по сети по мере чтения потока,
+// поэтому время ожидания здесь не ограничивается
+func (storage *S3Storage) Get(name string) (ReadSeekCloser, *FileInfo, error) {
+	object, err := storage.client.GetObject(context.Background(), storage.bucket, path.Base(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, convertS3Error(err)
+	}
+
+	stat, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, nil, convertS3Error(err)
+	}
+
+	return object, s3FileInfo(stat), nil
+}
+
+// Функция для удаления файла
+func (storage *S3Storage) Delete(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.timeout)
+	defer cancel()
+
+	err := storage.client.RemoveObject(ctx, storage.bucket, path.Base(name), minio.RemoveObjectOptions{})
+	if err != nil && convertS3Error(err) != ErrFileNotFound {
+		return err
+	}
+	return nil
+}
+
+// Функция для получения информации о файле
+func (storage *S3Storage) Stat(name string) (*FileInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.timeout)
+	defer cancel()
+
+	stat, err := storage.client.StatObject(ctx, storage.bucket, path.Base(name), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+
+	return s3FileInfo(stat), nil
+}
+
+// Функция для получения списка всех файлов бакета
+func (storage *S3Storage) List() ([]FileInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.timeout)
+	defer cancel()
+
+	var files []FileInfo
+	for object := range storage.client.ListObjects(ctx, storage.bucket, minio.ListObjectsOptions{}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		files = append(files, *s3FileInfo(object))
+	}
+
+	return files, nil
+}
+
+// Функция для получения подписанной ссылки на скачивание файла
+//
+// По ссылке клиент скачивает файл напрямую из хранилища,
+// пока не истечёт время expires
+func (storage *S3Storage) PresignedURL(name string, expires time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.timeout)
+	defer cancel()
+
+	presigned, err := storage.public.PresignedGetObject(ctx, storage.bucket, path.Base(name), expires, url.Values{})
+	if err != nil {
+		return "", err
+	}
+
+	return presigned.String(), nil
+}
+
+// Функция для перевода ошибки "нет такого файла" в ErrFileNotFound
+func convertS3Error(err error) error {
+	response := minio.ToErrorResponse(err)
+	if response.Code == "NoSuchKey" || response.StatusCode == http.StatusNotFound {
+		return ErrFileNotFound
+	}
+	return err
+}
+
+// Функция для перевода информации об объекте S3 в FileInfo
+func s3FileInfo(stat minio.ObjectInfo) *FileInfo {
+	return &FileInfo{
+		Name:        stat.Key,
+		Size:        stat.Size,
+		ModTime:     stat.LastModified,
+		ContentType: stat.ContentType,
+	}
+}
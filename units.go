@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"math"
+
+	"github.com/Recipe-book-PetrSU-2022/backend/models"
+)
+
+// Единицы измерения ингредиентов
+const (
+	UnitGram       = "g"
+	UnitKilogram   = "kg"
+	UnitMilligram  = "mg"
+	UnitOunce      = "oz"
+	UnitPound      = "lb"
+	UnitMilliliter = "ml"
+	UnitLiter      = "l"
+	UnitFluidOunce = "fl_oz"
+	UnitCup        = "cup"
+	UnitTeaspoon   = "tsp"
+	UnitTablespoon = "tbsp"
+	UnitPiece      = "pcs"
+)
+
+// Системы мер для отображения
+const (
+	UnitSystemMetric   = "metric"   // метрическая
+	UnitSystemImperial = "imperial" // имперская
+)
+
+// Виды единиц измерения
+const (
+	UnitKindMass   = "mass"   // вес, переводится в граммы
+	UnitKindVolume = "volume" // объём, переводится в миллилитры
+	UnitKindSpoon  = "spoon"  // ложки, по сути тоже объём
+	UnitKindPiece  = "piece"  // штуки
+)
+
+// Информация о единице измерения
+//
+// Переменные структуры:
+//   - Вид единицы
+//   - Сколько граммов (для веса) или миллилитров (для объёма) в одной единице
+//   - Система мер, к которой относится единица
+type UnitInfo struct {
+	Kind   string
+	Factor float64
+	System string
+}
+
+// Все поддерживаемые единицы измерения
+var Units = map[string]UnitInfo{
+	UnitGram:       {UnitKindMass, 1, UnitSystemMetric},
+	UnitKilogram:   {UnitKindMass, 1000, UnitSystemMetric},
+	UnitMilligram:  {UnitKindMass, 0.001, UnitSystemMetric},
+	UnitOunce:      {UnitKindMass, 28.3495, UnitSystemImperial},
+	UnitPound:      {UnitKindMass, 453.592, UnitSystemImperial},
+	UnitMilliliter: {UnitKindVolume, 1, UnitSystemMetric},
+	UnitLiter:      {UnitKindVolume, 1000, UnitSystemMetric},
+	UnitFluidOunce: {UnitKindVolume, 29.5735, UnitSystemImperial},
+	UnitCup:        {UnitKindVolume, 236.588, UnitSystemImperial},
+	UnitTeaspoon:   {UnitKindSpoon, 4.92892, ""},
+	UnitTablespoon: {UnitKindSpoon, 14.7868, ""},
+	UnitPiece:      {UnitKindPiece, 1, ""},
+}
+
+// Функция для проверки, что система мер допустима
+func IsValidUnitSystem(system string) bool {
+	return system == UnitSystemMetric || system == UnitSystemImperial
+}
+
+// Функция для перевода количества ингредиента в граммы
+//
+// Объём переводится через плотность ингредиента (г/мл),
+// штуки - через вес одной штуки
+func ConvertToGrams(quantity float64, unit string, ingredient *models.Ingredient) (float64, error) {
+	info, ok := Units[unit]
+	if !ok {
+		return 0, errors.New("неизвестная единица измерения")
+	}
+
+	if quantity < 0 {
+		return 0, errors.New("количество не может быть отрицательным")
+	}
+
+	switch info.Kind {
+	case UnitKindMass:
+		return quantity * info.Factor, nil
+	case UnitKindVolume, UnitKindSpoon:
+		density := ingredient.FloatDensity
+		if density <= 0 {
+			density = 1
+		}
+		return quantity * info.Factor * density, nil
+	}
+
+	if ingredient.FloatPieceGrams <= 0 {
+		return 0, errors.New("для ингредиента не задан вес одной штуки")
+	}
+	return quantity * ingredient.FloatPieceGrams, nil
+}
+
+// Функция для перевода веса в удобные на кухне единицы
+//
+// Малые количества переводятся в ложки с учётом плотности ингредиента,
+// большие - в килограммы. Штуки остаются штуками
+func ToKitchenUnits(quantity float64, unit string, grams float64, ingredient *models.Ingredient) (float64, string) {
+	if unit == UnitPiece {
+		return quantity, unit
+	}
+
+	density := ingredient.FloatDensity
+	if density <= 0 {
+		density = 1
+	}
+	milliliters := grams / density
+
+	switch {
+	case grams >= 1000:
+		return grams / Units[UnitKilogram].Factor, UnitKilogram
+	case milliliters < Units[UnitTablespoon].Factor:
+		return milliliters / Units[UnitTeaspoon].Factor, UnitTeaspoon
+	case milliliters < 4*Units[UnitTablespoon].Factor:
+		return milliliters / Units[UnitTablespoon].Factor, UnitTablespoon
+	}
+	return grams, UnitGram
+}
+
+// Функция для округления количества в зависимости от единицы измерения
+func RoundQuantity(quantity float64, unit string) float64 {
+	if quantity <= 0 {
+		return 0
+	}
+
+	switch unit {
+	case UnitGram, UnitMilliliter:
+		return RoundGrams(quantity)
+	case UnitTeaspoon, UnitTablespoon, UnitCup:
+		return RoundSpoons(quantity)
+	case UnitPiece:
+		rounded := math.Round(quantity*2) / 2
+		if rounded == 0 {
+			return 0.5
+		}
+		return rounded
+	}
+	return math.Round(quantity*100) / 100
+}
+
+// Функция для перевода количества в единицы выбранной системы мер
+//
+// Штуки и ложки одинаковы в обеих системах и не переводятся
+func ConvertToSystem(quantity float64, unit string, system string) (float64, string) {
+	info, ok := Units[unit]
+	if !ok || info.System == "" || info.System == system {
+		return RoundQuantity(quantity, unit), unit
+	}
+
+	base := quantity * info.Factor
+
+	var target string
+	switch {
+	case info.Kind == UnitKindMass && system == UnitSystemImperial:
+		target = UnitOunce
+		if base >= Units[UnitPound].Factor {
+			target = UnitPound
+		}
+	case info.Kind == UnitKindMass:
+		target = UnitGram
+		if base >= 1000 {
+			target = UnitKilogram
+		}
+	case system == UnitSystemImperial:
+		target = UnitFluidOunce
+		if base >= Units[UnitCup].Factor/2 {
+			target = UnitCup
+		}
+	default:
+		target = UnitMilliliter
+		if base >= 1000 {
+			target = UnitLiter
+		}
+	}
+
+	return RoundQuantity(base/Units[target].Factor, target), target
+}
@@ -47,6 +47,7 @@ type UserDataSignin struct {
 //   - Пароль
 //   - Пароль для подтверждения
 //   - Фото профиля
+//   - Система мер
 type ChangeUserData struct {
 	Login           string `json:"login"`            // Никнейм
 	Email           string `json:"email"`            // Почта
@@ -54,6 +55,7 @@ type ChangeUserData struct {
 	Password        string `json:"password"`         // Новый пароль
 	ConfirmPassword string `json:"confirm_password"` // Подтверждение нового пароля
 	Photo           string `json:"photo"`            // Фото профиля
+	UnitSystem      string `json:"unit_system"`      // Система мер (metric, imperial)
 }
 
 // Функция для регистрации
@@ -228,7 +230,7 @@ func (server *Server) ProfileHandle(c echo.Context) error {
 	// Создаем ответ с ID, логином, почтой и фотографией профиля
 	// response := &ProfileResponse{Message: "Удачный вход на страницу профиля", Id: user.ID, Username: user.StrUserName, Email: user.StrUserEmail, ProfilePhoto: user.StrUserImage}
 
-	return c.JSON(http.StatusOK, user)
+	return c.JSON(http.StatusOK, &UserProfileResponse{User: user, Storage: server.GetStorageUsage(user)})
 }
 
 // Изменение данных о пользователе
@@ -267,7 +269,7 @@ func (server *Server) ChangeProfileHandle(c echo.Context) error {
 	}
 
 	// Если пользователь ничего не меняет
-	if len(user_data.Login) == 0 && len(user_data.Email) == 0 && len(user_data.OldPassword) == 0 && len(user_data.Password) == 0 && len(user_data.ConfirmPassword) == 0 {
+	if len(user_data.Login) == 0 && len(user_data.Email) == 0 && len(user_data.OldPassword) == 0 && len(user_data.Password) == 0 && len(user_data.ConfirmPassword) == 0 && len(user_data.UnitSystem) == 0 {
 		return c.JSON(http.StatusOK, &DefaultResponse{Message: "Нечего изменять"})
 	}
 
@@ -291,6 +293,14 @@ func (server *Server) ChangeProfileHandle(c echo.Context) error {
 		}
 	}
 
+	// Если пользователь выбрал систему мер
+	if len(user_data.UnitSystem) != 0 {
+		if !IsValidUnitSystem(user_data.UnitSystem) {
+			return c.JSON(http.StatusBadRequest, &DefaultResponse{Message: "Неизвестная система мер"})
+		}
+		server.DB.Model(&user).Update("StrUnitSystem", user_data.UnitSystem)
+	}
+
 	// Если пользователь ввёл что-то в поле старого пароля
 	if len(user_data.OldPassword) == 0 {
 		// Пользователь не ввёл текущий пароль